@@ -77,32 +77,32 @@ func (cr *store) ConfigDescriptor() model.ConfigDescriptor {
 	return cr.descriptor
 }
 
-func (cr *store) Get(typ, key string) (proto.Message, bool, string) {
+func (cr *store) Get(typ, key, namespace string) (*model.Config, bool) {
 	store, exists := cr.stores[typ]
 	if !exists {
-		return nil, false, ""
+		return nil, false
 	}
-	return store.Get(typ, key)
+	return store.Get(typ, key, namespace)
 }
 
-func (cr *store) List(typ string) ([]model.Config, error) {
+func (cr *store) List(typ, namespace string) ([]model.Config, error) {
 	store, exists := cr.stores[typ]
 	if !exists {
 		return nil, nil
 	}
-	return store.List(typ)
+	return store.List(typ, namespace)
 }
 
-func (cr *store) Delete(typ, key string) error {
+func (cr *store) Delete(typ, key, namespace string) error {
 	store, exists := cr.stores[typ]
 	if !exists {
 		return fmt.Errorf("missing type %q", typ)
 	}
-	return store.Delete(typ, key)
+	return store.Delete(typ, key, namespace)
 }
 
-func (cr *store) Post(config proto.Message) (string, error) {
-	schema, exists := cr.descriptor.GetByMessageName(proto.MessageName(config))
+func (cr *store) Post(config model.Config) (string, error) {
+	schema, exists := cr.descriptor.GetByMessageName(proto.MessageName(config.Content))
 	if !exists {
 		return "", errors.New("missing type")
 	}
@@ -110,13 +110,13 @@ func (cr *store) Post(config proto.Message) (string, error) {
 	return store.Post(config)
 }
 
-func (cr *store) Put(config proto.Message, oldRevision string) (string, error) {
-	schema, exists := cr.descriptor.GetByMessageName(proto.MessageName(config))
+func (cr *store) Put(config model.Config) (string, error) {
+	schema, exists := cr.descriptor.GetByMessageName(proto.MessageName(config.Content))
 	if !exists {
 		return "", errors.New("missing type")
 	}
 	store := cr.stores[schema.Type]
-	return store.Put(config, oldRevision)
+	return store.Put(config)
 }
 
 type storeCache struct {
@@ -128,24 +128,24 @@ func (cr *storeCache) ConfigDescriptor() model.ConfigDescriptor {
 	return cr.store.ConfigDescriptor()
 }
 
-func (cr *storeCache) Get(typ, key string) (config proto.Message, exists bool, revision string) {
-	return cr.store.Get(typ, key)
+func (cr *storeCache) Get(typ, key, namespace string) (*model.Config, bool) {
+	return cr.store.Get(typ, key, namespace)
 }
 
-func (cr *storeCache) List(typ string) ([]model.Config, error) {
-	return cr.store.List(typ)
+func (cr *storeCache) List(typ, namespace string) ([]model.Config, error) {
+	return cr.store.List(typ, namespace)
 }
 
-func (cr *storeCache) Post(val proto.Message) (string, error) {
-	return cr.store.Post(val)
+func (cr *storeCache) Post(config model.Config) (string, error) {
+	return cr.store.Post(config)
 }
 
-func (cr *storeCache) Put(val proto.Message, revision string) (string, error) {
-	return cr.store.Put(val, revision)
+func (cr *storeCache) Put(config model.Config) (string, error) {
+	return cr.store.Put(config)
 }
 
-func (cr *storeCache) Delete(typ, key string) error {
-	return cr.store.Delete(typ, key)
+func (cr *storeCache) Delete(typ, key, namespace string) error {
+	return cr.store.Delete(typ, key, namespace)
 }
 
 func (cr *storeCache) HasSynced() bool {
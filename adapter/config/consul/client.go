@@ -0,0 +1,324 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package consul provides an implementation of the config store and cache
+// using the Consul key/value store. Config objects are stored as JSON
+// documents under the key prefix "<prefix>/<plural type>/<namespace>/<key>".
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/consul/api"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+)
+
+// document is the serialized form of a config object in a KV pair
+type document struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Spec        json.RawMessage   `json:"spec"`
+}
+
+// Client is a basic Consul KV client implementing config store
+type Client struct {
+	descriptor model.ConfigDescriptor
+	kv         *api.KV
+
+	// prefix is the root of the key hierarchy for config objects
+	prefix string
+
+	// namespace is the default namespace for config objects
+	namespace string
+}
+
+// NewClient creates a config store client for a Consul agent at the address.
+// prefix argument provides the root of the config keys, and namespace
+// argument provides the default namespace for config objects without a
+// namespace.
+func NewClient(address, prefix string, descriptor model.ConfigDescriptor, namespace string) (*Client, error) {
+	if err := descriptor.Validate(); err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		return nil, errors.New("default namespace is required")
+	}
+
+	conf := api.DefaultConfig()
+	conf.Address = address
+	client, err := api.NewClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		descriptor: descriptor,
+		kv:         client.KV(),
+		prefix:     strings.Trim(prefix, "/"),
+		namespace:  namespace,
+	}, nil
+}
+
+// namespaceOrDefault returns the client namespace for an empty namespace
+func (cl *Client) namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return cl.namespace
+	}
+	return namespace
+}
+
+// typePrefix is the key prefix for the config objects of a type
+func (cl *Client) typePrefix(schema model.ProtoSchema) string {
+	return cl.prefix + "/" + schema.Plural + "/"
+}
+
+// path is the key of the KV pair holding a config object
+func (cl *Client) path(schema model.ProtoSchema, namespace, key string) string {
+	return cl.typePrefix(schema) + cl.namespaceOrDefault(namespace) + "/" + key
+}
+
+// parsePath splits a KV pair key into the config type, namespace, and key
+func (cl *Client) parsePath(path string) (model.ProtoSchema, string, string, error) {
+	if !strings.HasPrefix(path, cl.prefix+"/") {
+		return model.ProtoSchema{}, "", "", fmt.Errorf("key %q is outside of prefix %q", path, cl.prefix)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, cl.prefix+"/"), "/", 3)
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return model.ProtoSchema{}, "", "", fmt.Errorf("malformed key %q", path)
+	}
+	for _, schema := range cl.descriptor {
+		if schema.Plural == parts[0] {
+			return schema, parts[1], parts[2], nil
+		}
+	}
+	return model.ProtoSchema{}, "", "", fmt.Errorf("unknown type %q in key %q", parts[0], path)
+}
+
+// decode translates and validates a KV pair to a config object
+func (cl *Client) decode(pair *api.KVPair) (*model.Config, error) {
+	schema, namespace, key, err := cl.parsePath(pair.Key)
+	if err != nil {
+		return nil, err
+	}
+	var doc document
+	if err = json.Unmarshal(pair.Value, &doc); err != nil {
+		return nil, multierror.Prefix(err, pair.Key+":")
+	}
+	content, err := schema.FromJSON(string(doc.Spec))
+	if err != nil {
+		return nil, multierror.Prefix(err, pair.Key+":")
+	}
+	if schema.Key(content) != key {
+		return nil, fmt.Errorf("%s: key does not match content key %q", pair.Key, schema.Key(content))
+	}
+	config := &model.Config{
+		Type:        schema.Type,
+		Key:         key,
+		Name:        key,
+		Namespace:   namespace,
+		Labels:      doc.Labels,
+		Annotations: doc.Annotations,
+		Revision:    strconv.FormatUint(pair.ModifyIndex, 10),
+		Content:     content,
+	}
+
+	// KV pairs written directly to Consul bypass the validation on encode
+	if err = schema.ValidateConfig(*config); err != nil {
+		return nil, multierror.Prefix(err, pair.Key+":")
+	}
+	return config, nil
+}
+
+// encode validates and translates a config object to a KV pair
+func (cl *Client) encode(config model.Config) (*api.KVPair, error) {
+	messageName := proto.MessageName(config.Content)
+	schema, exists := cl.descriptor.GetByMessageName(messageName)
+	if !exists {
+		return nil, fmt.Errorf("unrecognized message name %q", messageName)
+	}
+
+	if err := schema.ValidateConfig(config); err != nil {
+		return nil, multierror.Prefix(err, "validation error:")
+	}
+
+	spec, err := schema.ToJSON(config.Content)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(document{
+		Labels:      config.Labels,
+		Annotations: config.Annotations,
+		Spec:        json.RawMessage(spec),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.KVPair{
+		Key:   cl.path(schema, config.Namespace, schema.Key(config.Content)),
+		Value: value,
+	}, nil
+}
+
+// cas writes a KV pair if its modify index matches the stored one, and
+// returns the new modify index. A zero index writes the pair only if it
+// does not exist.
+func (cl *Client) cas(pair *api.KVPair) (string, bool, error) {
+	ok, resp, _, err := cl.kv.Txn(api.KVTxnOps{{
+		Verb:  api.KVCAS,
+		Key:   pair.Key,
+		Value: pair.Value,
+		Index: pair.ModifyIndex,
+	}}, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if !ok || len(resp.Results) == 0 || resp.Results[0] == nil {
+		return "", false, nil
+	}
+	return strconv.FormatUint(resp.Results[0].ModifyIndex, 10), true, nil
+}
+
+// ConfigDescriptor for the store
+func (cl *Client) ConfigDescriptor() model.ConfigDescriptor {
+	return cl.descriptor
+}
+
+// Get implements store interface
+func (cl *Client) Get(typ, key, namespace string) (*model.Config, bool) {
+	schema, exists := cl.descriptor.GetByType(typ)
+	if !exists {
+		return nil, false
+	}
+
+	pair, _, err := cl.kv.Get(cl.path(schema, namespace, key), nil)
+	if err != nil {
+		glog.Warning(err)
+		return nil, false
+	}
+	if pair == nil {
+		return nil, false
+	}
+
+	config, err := cl.decode(pair)
+	if err != nil {
+		glog.Warning(err)
+		return nil, false
+	}
+	return config, true
+}
+
+// Post implements store interface
+func (cl *Client) Post(config model.Config) (string, error) {
+	pair, err := cl.encode(config)
+	if err != nil {
+		return "", err
+	}
+
+	rev, ok, err := cl.cas(pair)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", &model.ItemAlreadyExistsError{Key: pair.Key}
+	}
+	return rev, nil
+}
+
+// Put implements store interface
+func (cl *Client) Put(config model.Config) (string, error) {
+	pair, err := cl.encode(config)
+	if err != nil {
+		return "", err
+	}
+
+	if config.Revision == "" {
+		return "", errors.New("revision is required")
+	}
+	if pair.ModifyIndex, err = strconv.ParseUint(config.Revision, 10, 64); err != nil || pair.ModifyIndex == 0 {
+		return "", fmt.Errorf("invalid revision %q", config.Revision)
+	}
+
+	rev, ok, err := cl.cas(pair)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("revision %s of %q is missing or outdated", config.Revision, pair.Key)
+	}
+	return rev, nil
+}
+
+// Delete implements store interface
+func (cl *Client) Delete(typ, key, namespace string) error {
+	schema, exists := cl.descriptor.GetByType(typ)
+	if !exists {
+		return fmt.Errorf("missing type %q", typ)
+	}
+
+	path := cl.path(schema, namespace, key)
+	pair, _, err := cl.kv.Get(path, nil)
+	if err != nil {
+		return err
+	}
+	if pair == nil {
+		return &model.ItemNotFoundError{Key: key}
+	}
+
+	ok, _, err := cl.kv.DeleteCAS(pair, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("concurrent modification of %q", path)
+	}
+	return nil
+}
+
+// List implements store interface
+func (cl *Client) List(typ, namespace string) ([]model.Config, error) {
+	schema, exists := cl.descriptor.GetByType(typ)
+	if !exists {
+		return nil, fmt.Errorf("missing type %q", typ)
+	}
+
+	prefix := cl.typePrefix(schema)
+	if namespace != model.NamespaceAll {
+		prefix = prefix + namespace + "/"
+	}
+	pairs, _, err := cl.kv.List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs error
+	out := make([]model.Config, 0, len(pairs))
+	for _, pair := range pairs {
+		config, err := cl.decode(pair)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		} else {
+			out = append(out, *config)
+		}
+	}
+	return out, errs
+}
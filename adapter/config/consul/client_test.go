@@ -0,0 +1,248 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+// kvServer is a minimal Consul KV HTTP API supporting blocking queries,
+// check-and-set transactions, and check-and-set deletions
+type kvServer struct {
+	mutex sync.Mutex
+	index uint64
+	data  map[string]*api.KVPair
+}
+
+func (s *kvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/v1/txn" && r.Method == http.MethodPut:
+		s.txn(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodGet:
+		s.get(w, r, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodDelete:
+		s.delete(w, r, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *kvServer) txn(w http.ResponseWriter, r *http.Request) {
+	var ops []struct{ KV *api.KVTxnOp }
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil || len(ops) != 1 || ops[0].KV.Verb != api.KVCAS {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	op := ops[0].KV
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	old, exists := s.data[op.Key]
+	if (op.Index == 0 && exists) || (op.Index != 0 && (!exists || old.ModifyIndex != op.Index)) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"Errors":[{"OpIndex":0,"What":"failed to set key"}]}`)
+		return
+	}
+	s.index++
+	pair := &api.KVPair{Key: op.Key, Value: op.Value, CreateIndex: s.index, ModifyIndex: s.index}
+	if exists {
+		pair.CreateIndex = old.CreateIndex
+	}
+	s.data[op.Key] = pair
+	// the HTTP API wraps the KV pairs in the transaction results, the client
+	// library unwraps them into KVTxnResponse.Results
+	out, _ := json.Marshal(map[string]interface{}{
+		"Results": []map[string]interface{}{{"KV": &api.KVPair{
+			Key: pair.Key, CreateIndex: pair.CreateIndex, ModifyIndex: pair.ModifyIndex}}},
+	})
+	_, _ = w.Write(out)
+}
+
+func (s *kvServer) get(w http.ResponseWriter, r *http.Request, key string) {
+	// block until the index changes or the wait time elapses
+	if index, err := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); err == nil && index > 0 {
+		wait, err := time.ParseDuration(r.URL.Query().Get("wait"))
+		if err != nil {
+			wait = time.Second
+		}
+		deadline := time.Now().Add(wait)
+		for time.Now().Before(deadline) {
+			s.mutex.Lock()
+			changed := s.index != index
+			s.mutex.Unlock()
+			if changed {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	out := make([]*api.KVPair, 0)
+	_, recurse := r.URL.Query()["recurse"]
+	for k, pair := range s.data {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			out = append(out, pair)
+		}
+	}
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	data, _ := json.Marshal(out)
+	_, _ = w.Write(data)
+}
+
+func (s *kvServer) delete(w http.ResponseWriter, r *http.Request, key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	old, exists := s.data[key]
+	if cas := r.URL.Query().Get("cas"); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		if !exists || old.ModifyIndex != index {
+			fmt.Fprint(w, "false")
+			return
+		}
+	}
+	if exists {
+		s.index++
+		delete(s.data, key)
+	}
+	fmt.Fprint(w, "true")
+}
+
+func makeClient(t *testing.T) (*Client, func()) {
+	return makeClientWithTypes(t, append(model.IstioConfigTypes, mock.Types...))
+}
+
+func makeClientWithTypes(t *testing.T, desc model.ConfigDescriptor) (*Client, func()) {
+	ts := httptest.NewServer(&kvServer{data: make(map[string]*api.KVPair)})
+	cl, err := NewClient(ts.URL, "/istio/config/", desc, "default")
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return cl, ts.Close
+}
+
+func TestStoreInvariant(t *testing.T) {
+	client, cleanup := makeClient(t)
+	defer cleanup()
+	mock.CheckMapInvariant(client, t, "default", 5)
+}
+
+func TestNamespaces(t *testing.T) {
+	client, cleanup := makeClient(t)
+	defer cleanup()
+	mock.CheckNamespaces(client, t, []string{"default", "istio-system"})
+}
+
+func TestIstioConfig(t *testing.T) {
+	client, cleanup := makeClient(t)
+	defer cleanup()
+	mock.CheckIstioConfigTypes(client, "default", t)
+}
+
+func TestPaths(t *testing.T) {
+	client, cleanup := makeClient(t)
+	defer cleanup()
+
+	path := client.path(model.RouteRule, "", "reviews-default")
+	if path != "istio/config/route-rules/default/reviews-default" {
+		t.Errorf("path() => got %q", path)
+	}
+	schema, namespace, key, err := client.parsePath(path)
+	if err != nil || schema.Type != model.RouteRule.Type || namespace != "default" || key != "reviews-default" {
+		t.Errorf("parsePath(%q) => got %q, %q, %q, %v", path, schema.Type, namespace, key, err)
+	}
+
+	for _, invalid := range []string{
+		"other/route-rules/default/reviews-default",
+		"istio/config/route-rules/default",
+		"istio/config/unknown-types/default/reviews-default",
+	} {
+		if _, _, _, err = client.parsePath(invalid); err == nil {
+			t.Errorf("parsePath(%q) => expected an error", invalid)
+		}
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	client, cleanup := makeClient(t)
+	defer cleanup()
+
+	rev, err := client.Post(model.Config{Content: mock.Make(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, exists := client.Get(model.MockConfig.Type, mock.Make(0).Key, "")
+	if !exists || config.Revision != rev {
+		t.Fatalf("Get() => got %v, want revision %s", config, rev)
+	}
+
+	// a stale revision is rejected
+	next, err := client.Put(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Put(*config); err == nil {
+		t.Errorf("Put() with revision %s after update to %s => expected an error", rev, next)
+	}
+}
+
+func TestDecodeValidation(t *testing.T) {
+	client, cleanup := makeClientWithTypes(t, model.IstioConfigTypes)
+	defer cleanup()
+
+	key := model.RouteRule.Key(mock.ExampleRouteRule)
+	spec, err := model.RouteRule.ToJSON(mock.ExampleRouteRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		annotations map[string]string
+		valid       bool
+	}{
+		{valid: true},
+		{annotations: map[string]string{model.RetryPolicyAnnotation: `{"retryOn": ["5xx"]}`}, valid: true},
+		{annotations: map[string]string{model.RetryPolicyAnnotation: `{"retryOn": ["sometimes"]}`}},
+	} {
+		value, err := json.Marshal(document{Annotations: c.annotations, Spec: json.RawMessage(spec)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pair := &api.KVPair{Key: client.path(model.RouteRule, "default", key), Value: value}
+		if _, err = client.decode(pair); (err == nil) != c.valid {
+			t.Errorf("decode(%v) => got error %v, want valid %t", c.annotations, err, c.valid)
+		}
+	}
+}
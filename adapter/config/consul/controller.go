@@ -0,0 +1,214 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/consul/api"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+)
+
+// controller is a local cache of the config objects under the client prefix,
+// synchronized with blocking queries on the key prefix
+type controller struct {
+	client   *Client
+	period   time.Duration
+	queue    kube.Queue
+	handlers map[string]*kube.ChainHandler
+
+	// mutex guards the cached KV pairs by key, the last index, and the sync flag
+	mutex     sync.RWMutex
+	pairs     map[string]*model.Config
+	lastIndex uint64
+	synced    bool
+}
+
+// NewController creates a config store cache for the Consul client. The
+// period is the maximum wait time for a blocking query and the retry delay
+// after a failed query.
+func NewController(client *Client, period time.Duration) model.ConfigStoreCache {
+	out := &controller{
+		client: client,
+		period: period,
+		// queue requires a time duration for a retry delay after a handler error
+		queue:    kube.NewQueue(1 * time.Second),
+		handlers: make(map[string]*kube.ChainHandler),
+		pairs:    make(map[string]*model.Config),
+	}
+	for _, typ := range client.ConfigDescriptor().Types() {
+		out.handlers[typ] = &kube.ChainHandler{}
+	}
+	return out
+}
+
+func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
+	handler, exists := c.handlers[typ]
+	if !exists {
+		return
+	}
+	handler.Append(func(obj interface{}, event model.Event) error {
+		f(obj.(model.Config), event)
+		return nil
+	})
+}
+
+func (c *controller) HasSynced() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.synced
+}
+
+func (c *controller) Run(stop <-chan struct{}) {
+	go c.queue.Run(stop)
+	for {
+		select {
+		case <-stop:
+			glog.V(2).Info("Consul config controller terminated")
+			return
+		default:
+			if err := c.update(); err != nil {
+				glog.Warningf("Error synchronizing Consul config: %v", err)
+				select {
+				case <-stop:
+				case <-time.After(c.period):
+				}
+			}
+		}
+	}
+}
+
+// update waits for a change of the key prefix and emits events for the
+// added, modified, and deleted config objects
+func (c *controller) update() error {
+	c.mutex.RLock()
+	lastIndex := c.lastIndex
+	c.mutex.RUnlock()
+
+	pairs, meta, err := c.client.kv.List(c.client.prefix+"/", &api.QueryOptions{
+		WaitIndex: lastIndex,
+		WaitTime:  c.period,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch {
+	case meta.LastIndex == c.lastIndex && c.synced:
+		// blocking query timed out without changes
+		return nil
+	case meta.LastIndex < c.lastIndex:
+		// the index went backwards, e.g. after a restore of the Consul state
+		glog.V(2).Infof("Consul index reset from %d to %d", c.lastIndex, meta.LastIndex)
+		c.lastIndex = 0
+	default:
+		c.lastIndex = meta.LastIndex
+	}
+
+	next := make(map[string]*model.Config, len(pairs))
+	for _, pair := range pairs {
+		config, err := c.client.decode(pair)
+		if err != nil {
+			glog.V(2).Infof("Skipping KV pair: %v", err)
+			continue
+		}
+		next[pair.Key] = config
+
+		if old, exists := c.pairs[pair.Key]; !exists {
+			c.emit(*config, model.EventAdd)
+		} else if old.Revision != config.Revision {
+			c.emit(*config, model.EventUpdate)
+		}
+	}
+
+	keys := make([]string, 0)
+	for key := range c.pairs {
+		if _, exists := next[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		c.emit(*c.pairs[key], model.EventDelete)
+	}
+
+	c.pairs = next
+	c.synced = true
+	return nil
+}
+
+// emit queues an event for the handlers, must be called with the lock held
+func (c *controller) emit(config model.Config, event model.Event) {
+	if handler, exists := c.handlers[config.Type]; exists {
+		c.queue.Push(kube.NewTask(handler.Apply, config, event))
+	}
+}
+
+func (c *controller) ConfigDescriptor() model.ConfigDescriptor {
+	return c.client.ConfigDescriptor()
+}
+
+func (c *controller) Get(typ, key, namespace string) (*model.Config, bool) {
+	schema, exists := c.client.ConfigDescriptor().GetByType(typ)
+	if !exists {
+		return nil, false
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	config, exists := c.pairs[c.client.path(schema, namespace, key)]
+	if !exists {
+		return nil, false
+	}
+	out := *config
+	return &out, true
+}
+
+func (c *controller) List(typ, namespace string) ([]model.Config, error) {
+	if _, exists := c.client.ConfigDescriptor().GetByType(typ); !exists {
+		return nil, fmt.Errorf("missing type %q", typ)
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	out := make([]model.Config, 0)
+	for _, config := range c.pairs {
+		if config.Type == typ && (namespace == model.NamespaceAll || config.Namespace == namespace) {
+			out = append(out, *config)
+		}
+	}
+	return out, nil
+}
+
+func (c *controller) Post(config model.Config) (string, error) {
+	return c.client.Post(config)
+}
+
+func (c *controller) Put(config model.Config) (string, error) {
+	return c.client.Put(config)
+}
+
+func (c *controller) Delete(typ, key, namespace string) error {
+	return c.client.Delete(typ, key, namespace)
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/aggregate"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+const (
+	period = 100 * time.Millisecond
+)
+
+func TestControllerEvents(t *testing.T) {
+	cl, cleanup := makeClient(t)
+	defer cleanup()
+	ctl := NewController(cl, period)
+	mock.CheckCacheEvents(cl, ctl, 5, t)
+}
+
+func TestControllerCacheFreshness(t *testing.T) {
+	cl, cleanup := makeClient(t)
+	defer cleanup()
+	ctl := NewController(cl, period)
+	mock.CheckCacheFreshness(ctl, t)
+}
+
+func TestControllerClientSync(t *testing.T) {
+	cl, cleanup := makeClient(t)
+	defer cleanup()
+	ctl := NewController(cl, period)
+	mock.CheckCacheSync(cl, ctl, 5, t)
+}
+
+func TestControllerAggregate(t *testing.T) {
+	cl, cleanup := makeClientWithTypes(t, mock.Types)
+	defer cleanup()
+
+	cache, err := aggregate.MakeCache([]model.ConfigStoreCache{
+		NewController(cl, period),
+		memory.NewController(model.IstioConfigTypes),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.CheckIstioConfigTypes(cache, "default", t)
+	mock.CheckCacheEvents(cl, cache, 5, t)
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	admission "k8s.io/api/admission/v1alpha1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/pilot/model"
+)
+
+// Admission validates Istio config objects submitted to the Kubernetes API
+// server. It implements the webhook endpoint for the admission reviews of
+// the Istio custom resources.
+type Admission struct {
+	descriptor model.ConfigDescriptor
+
+	// kinds is a mapping from the CRD kind to the config schema
+	kinds map[string]model.ProtoSchema
+}
+
+// NewAdmission creates an admission controller for the config types
+func NewAdmission(descriptor model.ConfigDescriptor) (*Admission, error) {
+	if err := descriptor.Validate(); err != nil {
+		return nil, err
+	}
+	kinds := make(map[string]model.ProtoSchema, len(descriptor))
+	for _, schema := range descriptor {
+		kinds[kabobCaseToCamelCase(schema.Type)] = schema
+	}
+	return &Admission{
+		descriptor: descriptor,
+		kinds:      kinds,
+	}, nil
+}
+
+// Admit reviews a create or update operation on an Istio config object.
+// The operation is allowed if the object decodes to a valid config object,
+// otherwise the result lists all validation errors. Operations on other
+// objects are allowed unconditionally.
+func (ac *Admission) Admit(spec *admission.AdmissionReviewSpec) admission.AdmissionReviewStatus {
+	if spec.Kind.Group != model.IstioAPIGroup ||
+		(spec.Operation != admission.Create && spec.Operation != admission.Update) {
+		return admission.AdmissionReviewStatus{Allowed: true}
+	}
+
+	schema, exists := ac.kinds[spec.Kind.Kind]
+	if !exists {
+		glog.V(2).Infof("Admitting unrecognized kind %q", spec.Kind.Kind)
+		return admission.AdmissionReviewStatus{Allowed: true}
+	}
+
+	var obj IstioKind
+	if err := json.Unmarshal(spec.Object.Raw, &obj); err != nil {
+		return rejected(spec, multierror.Prefix(err, "cannot decode object:"))
+	}
+
+	config, err := convertObject(schema, &obj)
+	if err != nil {
+		return rejected(spec, multierror.Prefix(err, "cannot decode spec:"))
+	}
+
+	if err = schema.ValidateConfig(config); err != nil {
+		return rejected(spec, err)
+	}
+
+	return admission.AdmissionReviewStatus{Allowed: true}
+}
+
+// rejected translates a validation error to an admission review status with
+// a cause per validation error
+func rejected(spec *admission.AdmissionReviewSpec, err error) admission.AdmissionReviewStatus {
+	errs := []error{err}
+	if merr, ok := err.(*multierror.Error); ok && len(merr.Errors) > 0 {
+		errs = merr.Errors
+	}
+	causes := make([]meta_v1.StatusCause, 0, len(errs))
+	for _, cause := range errs {
+		causes = append(causes, meta_v1.StatusCause{
+			Type:    meta_v1.CauseTypeFieldValueInvalid,
+			Message: cause.Error(),
+			Field:   "spec",
+		})
+	}
+
+	return admission.AdmissionReviewStatus{
+		Allowed: false,
+		Result: &meta_v1.Status{
+			Status:  meta_v1.StatusFailure,
+			Message: fmt.Sprintf("%s %q is invalid: %v", spec.Kind.Kind, spec.Name, err),
+			Reason:  meta_v1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Details: &meta_v1.StatusDetails{
+				Name:   spec.Name,
+				Group:  spec.Kind.Group,
+				Kind:   spec.Kind.Kind,
+				Causes: causes,
+			},
+		},
+	}
+}
+
+// ServeHTTP decodes an admission review request and responds with the review
+// status
+func (ac *Admission) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var review admission.AdmissionReview
+	if err = json.Unmarshal(body, &review); err != nil {
+		http.Error(w, fmt.Sprintf("cannot decode admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Status = ac.Admit(&review.Spec)
+	if !review.Status.Allowed {
+		glog.V(2).Infof("Rejected %s %s/%s: %s", review.Spec.Operation, review.Spec.Namespace,
+			review.Spec.Name, review.Status.Result.Message)
+	}
+
+	out, err := json.Marshal(&review)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(out); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// Run serves the admission webhook over HTTPS at the port with the TLS
+// certificate and key files until the stop channel is closed
+func (ac *Admission) Run(port int, certFile, keyFile string, stop <-chan struct{}) {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: ac,
+	}
+	go func() {
+		<-stop
+		if err := server.Close(); err != nil {
+			glog.Warning(err)
+		}
+	}()
+
+	glog.Infof("Starting admission webhook at %v", server.Addr)
+	if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+		glog.Warning(err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	admission "k8s.io/api/admission/v1alpha1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func makeReviewSpec(t *testing.T, schema model.ProtoSchema, msg proto.Message,
+	operation admission.Operation) *admission.AdmissionReviewSpec {
+	obj, err := modelToKube(schema, model.Config{Namespace: "default", Content: msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admission.AdmissionReviewSpec{
+		Kind: meta_v1.GroupVersionKind{
+			Group:   model.IstioAPIGroup,
+			Version: model.IstioAPIVersion,
+			Kind:    kabobCaseToCamelCase(schema.Type),
+		},
+		Object:    runtime.RawExtension{Raw: raw},
+		Operation: operation,
+		Name:      obj.GetObjectMeta().Name,
+		Namespace: "default",
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	ac, err := NewAdmission(model.IstioConfigTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalidRule := &proxyconfig.RouteRule{
+		Name: "invalid-rule",
+		Route: []*proxyconfig.DestinationWeight{
+			{Weight: 80, Tags: map[string]string{"version": "v1"}},
+		},
+	}
+
+	malformed := makeReviewSpec(t, model.RouteRule, mock.ExampleRouteRule, admission.Create)
+	malformed.Object.Raw = []byte(`{"spec": {"destination": 1}}`)
+
+	otherGroup := makeReviewSpec(t, model.RouteRule, invalidRule, admission.Create)
+	otherGroup.Kind.Group = "extensions"
+
+	invalidAnnotations := makeReviewSpec(t, model.DestinationPolicy, mock.ExampleDestinationPolicy, admission.Create)
+	annotated, err := modelToKube(model.DestinationPolicy, model.Config{
+		Namespace: "default",
+		Content:   mock.ExampleDestinationPolicy,
+		Annotations: map[string]string{
+			model.ConsistentHashAnnotation: "httpHeaderName=",
+			model.HealthCheckAnnotation:    `[{"type": "udp", "interval": "1s", "timeout": "1s"}]`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidAnnotations.Object.Raw, err = json.Marshal(annotated); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidRetries := makeReviewSpec(t, model.RouteRule, mock.ExampleRouteRule, admission.Update)
+	annotated, err = modelToKube(model.RouteRule, model.Config{
+		Namespace:   "default",
+		Content:     mock.ExampleRouteRule,
+		Annotations: map[string]string{model.RetryPolicyAnnotation: `{"retryOn": ["sometimes"]}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidRetries.Object.Raw, err = json.Marshal(annotated); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		spec    *admission.AdmissionReviewSpec
+		allowed bool
+		// minimum number of causes in a rejection
+		causes int
+	}{
+		{"valid route rule", makeReviewSpec(t, model.RouteRule, mock.ExampleRouteRule, admission.Create), true, 0},
+		{"valid ingress rule", makeReviewSpec(t, model.IngressRule, mock.ExampleIngressRule, admission.Update), true, 0},
+		{"valid destination policy",
+			makeReviewSpec(t, model.DestinationPolicy, mock.ExampleDestinationPolicy, admission.Create), true, 0},
+		{"invalid route rule", makeReviewSpec(t, model.RouteRule, invalidRule, admission.Create), false, 2},
+		{"invalid destination policy annotations", invalidAnnotations, false, 4},
+		{"invalid route rule annotations", invalidRetries, false, 1},
+		{"invalid route rule update", makeReviewSpec(t, model.RouteRule, invalidRule, admission.Update), false, 2},
+		{"invalid route rule deletion", makeReviewSpec(t, model.RouteRule, invalidRule, admission.Delete), true, 0},
+		{"malformed route rule", malformed, false, 1},
+		{"other group", otherGroup, true, 0},
+	}
+
+	for _, c := range cases {
+		status := ac.Admit(c.spec)
+		if status.Allowed != c.allowed {
+			t.Errorf("%s: Admit() => got allowed %t, want %t (%v)", c.name, status.Allowed, c.allowed, status.Result)
+			continue
+		}
+		if c.allowed {
+			continue
+		}
+		if status.Result == nil || status.Result.Reason != meta_v1.StatusReasonInvalid ||
+			status.Result.Details == nil || len(status.Result.Details.Causes) < c.causes {
+			t.Errorf("%s: Admit() => got result %#v, want at least %d causes", c.name, status.Result, c.causes)
+		}
+	}
+}
+
+func TestAdmissionServeHTTP(t *testing.T) {
+	ac, err := NewAdmission(model.IstioConfigTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(ac)
+	defer ts.Close()
+
+	review := admission.AdmissionReview{
+		Spec: *makeReviewSpec(t, model.RouteRule, &proxyconfig.RouteRule{Name: "invalid-rule"}, admission.Create),
+	}
+	body, err := json.Marshal(&review)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	var out admission.AdmissionReview
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Status.Allowed || out.Status.Result == nil || out.Status.Result.Code != http.StatusUnprocessableEntity {
+		t.Errorf("ServeHTTP() => got status %#v, want rejection", out.Status)
+	}
+
+	resp, err = http.Post(ts.URL, "application/json", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() with malformed review => got code %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -68,7 +68,7 @@ type Client struct {
 	// dynamic REST client for accessing config CRDs
 	dynamic *rest.RESTClient
 
-	// namespace is the namespace for storing CRDs
+	// namespace is the default namespace for storing CRDs
 	namespace string
 }
 
@@ -109,7 +109,8 @@ func CreateRESTConfig(kubeconfig string) (config *rest.Config, err error) {
 }
 
 // NewClient creates a client to Kubernetes API using a kubeconfig file.
-// namespace argument provides the namespace to store CRDs
+// namespace argument provides the default namespace to store CRDs, used for
+// config objects without a namespace
 // Use an empty value for `kubeconfig` to use the in-cluster config.
 // If the kubeconfig file is empty, defaults to in-cluster config as well.
 func NewClient(config string, descriptor model.ConfigDescriptor, namespace string) (*Client, error) {
@@ -234,114 +235,162 @@ func (cl *Client) ConfigDescriptor() model.ConfigDescriptor {
 	return cl.descriptor
 }
 
+// namespaceOrDefault returns the client namespace for an empty namespace
+func (cl *Client) namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return cl.namespace
+	}
+	return namespace
+}
+
 // Get implements store interface
-func (cl *Client) Get(typ, key string) (proto.Message, bool, string) {
+func (cl *Client) Get(typ, key, namespace string) (*model.Config, bool) {
 	schema, exists := cl.descriptor.GetByType(typ)
 	if !exists {
-		return nil, false, ""
+		return nil, false
 	}
 
 	config := knownTypes[typ].object.DeepCopyObject().(IstioObject)
 	err := cl.dynamic.Get().
-		Namespace(cl.namespace).
+		Namespace(cl.namespaceOrDefault(namespace)).
 		Resource(schema.Plural).
 		Name(configKey(typ, key)).
 		Do().Into(config)
 
+	if apierrors.IsNotFound(err) {
+		// resources with a custom name are located by their key
+		return cl.getByKey(typ, key, namespace)
+	}
 	if err != nil {
 		glog.Warning(err)
-		return nil, false, ""
+		return nil, false
 	}
 
-	out, err := schema.FromJSONMap(config.GetSpec())
+	out, err := convertValidObject(schema, config)
 	if err != nil {
 		glog.Warningf("%v for %#v", err, config.GetObjectMeta())
-		return nil, false, ""
+		return nil, false
+	}
+	return out, true
+}
+
+// getByKey lists the resources in the namespace to find a config by its key
+func (cl *Client) getByKey(typ, key, namespace string) (*model.Config, bool) {
+	configs, err := cl.List(typ, cl.namespaceOrDefault(namespace))
+	if err != nil {
+		glog.Warning(err)
+	}
+	for _, config := range configs {
+		if config.Key == key {
+			return &config, true
+		}
 	}
-	return out, true, config.GetObjectMeta().ResourceVersion
+	return nil, false
 }
 
 // Post implements store interface
-func (cl *Client) Post(v proto.Message) (string, error) {
-	messageName := proto.MessageName(v)
+func (cl *Client) Post(config model.Config) (string, error) {
+	messageName := proto.MessageName(config.Content)
 	schema, exists := cl.descriptor.GetByMessageName(messageName)
 	if !exists {
 		return "", fmt.Errorf("unrecognized message name %q", messageName)
 	}
 
-	if err := schema.Validate(v); err != nil {
+	if err := schema.ValidateConfig(config); err != nil {
 		return "", multierror.Prefix(err, "validation error:")
 	}
 
-	out, err := modelToKube(schema, cl.namespace, v, "")
+	config.Namespace = cl.namespaceOrDefault(config.Namespace)
+	config.Revision = ""
+
+	// keys are unique per namespace regardless of the resource names
+	if config.Name != "" {
+		if _, exists := cl.Get(schema.Type, schema.Key(config.Content), config.Namespace); exists {
+			return "", fmt.Errorf("%s %q already exists in namespace %q",
+				schema.Type, schema.Key(config.Content), config.Namespace)
+		}
+	}
+
+	out, err := modelToKube(schema, config)
 	if err != nil {
 		return "", err
 	}
 
-	config := knownTypes[schema.Type].object.DeepCopyObject().(IstioObject)
+	obj := knownTypes[schema.Type].object.DeepCopyObject().(IstioObject)
 	err = cl.dynamic.Post().
 		Namespace(out.GetObjectMeta().Namespace).
 		Resource(schema.Plural).
 		Body(out).
-		Do().Into(config)
+		Do().Into(obj)
 	if err != nil {
 		return "", err
 	}
 
-	return config.GetObjectMeta().ResourceVersion, nil
+	return obj.GetObjectMeta().ResourceVersion, nil
 }
 
 // Put implements store interface
-func (cl *Client) Put(v proto.Message, revision string) (string, error) {
-	messageName := proto.MessageName(v)
+func (cl *Client) Put(config model.Config) (string, error) {
+	messageName := proto.MessageName(config.Content)
 	schema, exists := cl.descriptor.GetByMessageName(messageName)
 	if !exists {
 		return "", fmt.Errorf("unrecognized message name %q", messageName)
 	}
 
-	if err := schema.Validate(v); err != nil {
+	if err := schema.ValidateConfig(config); err != nil {
 		return "", multierror.Prefix(err, "validation error:")
 	}
 
-	if revision == "" {
+	if config.Revision == "" {
 		return "", fmt.Errorf("revision is required")
 	}
 
-	out, err := modelToKube(schema, cl.namespace, v, revision)
+	config.Namespace = cl.namespaceOrDefault(config.Namespace)
+	if config.Name == "" {
+		if existing, exists := cl.Get(schema.Type, schema.Key(config.Content), config.Namespace); exists {
+			config.Name = existing.Name
+		}
+	}
+	out, err := modelToKube(schema, config)
 	if err != nil {
 		return "", err
 	}
 
-	config := knownTypes[schema.Type].object.DeepCopyObject().(IstioObject)
+	obj := knownTypes[schema.Type].object.DeepCopyObject().(IstioObject)
 	err = cl.dynamic.Put().
 		Namespace(out.GetObjectMeta().Namespace).
 		Resource(schema.Plural).
 		Name(out.GetObjectMeta().Name).
 		Body(out).
-		Do().Into(config)
+		Do().Into(obj)
 	if err != nil {
 		return "", err
 	}
 
-	return config.GetObjectMeta().ResourceVersion, nil
+	return obj.GetObjectMeta().ResourceVersion, nil
 }
 
 // Delete implements store interface
-func (cl *Client) Delete(typ, key string) error {
+func (cl *Client) Delete(typ, key, namespace string) error {
 	schema, exists := cl.descriptor.GetByType(typ)
 	if !exists {
 		return fmt.Errorf("missing type %q", typ)
 	}
 
+	name := configKey(typ, key)
+	if config, exists := cl.Get(typ, key, namespace); exists {
+		name = config.Name
+	}
+
 	return cl.dynamic.Delete().
-		Namespace(cl.namespace).
+		Namespace(cl.namespaceOrDefault(namespace)).
 		Resource(schema.Plural).
-		Name(configKey(typ, key)).
+		Name(name).
 		Do().Error()
 }
 
 // List implements store interface
-func (cl *Client) List(typ string) ([]model.Config, error) {
+func (cl *Client) List(typ, namespace string) ([]model.Config, error) {
 	schema, exists := cl.descriptor.GetByType(typ)
 	if !exists {
 		return nil, fmt.Errorf("missing type %q", typ)
@@ -349,22 +398,17 @@ func (cl *Client) List(typ string) ([]model.Config, error) {
 
 	list := knownTypes[schema.Type].collection.DeepCopyObject().(IstioObjectList)
 	errs := cl.dynamic.Get().
-		Namespace(cl.namespace).
+		Namespace(namespace).
 		Resource(schema.Plural).
 		Do().Into(list)
 
 	out := make([]model.Config, 0)
 	for _, item := range list.GetItems() {
-		data, err := schema.FromJSONMap(item.GetSpec())
+		config, err := convertValidObject(schema, item)
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		} else {
-			out = append(out, model.Config{
-				Type:     schema.Type,
-				Key:      schema.Key(data),
-				Revision: item.GetObjectMeta().ResourceVersion,
-				Content:  data,
-			})
+			out = append(out, *config)
 		}
 	}
 	return out, errs
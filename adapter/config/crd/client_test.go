@@ -61,8 +61,8 @@ func makeClient(namespace string, t *testing.T) *Client {
 	return cl
 }
 
-// makeTempClient allocates a namespace and cleans it up on test completion
-func makeTempClient(t *testing.T) (*Client, func()) {
+// makeTempNamespace allocates a namespace and returns a function to clean it up
+func makeTempNamespace(t *testing.T) (string, func()) {
 	client, err := kube.CreateInterface(kubeconfig(t))
 	if err != nil {
 		t.Fatal(err)
@@ -71,21 +71,35 @@ func makeTempClient(t *testing.T) (*Client, func()) {
 	if err != nil {
 		t.Fatal(err.Error())
 	}
+	return ns, func() { util.DeleteNamespace(client, ns) }
+}
+
+// makeTempClient allocates a namespace and cleans it up on test completion
+func makeTempClient(t *testing.T) (*Client, func()) {
+	ns, cleanup := makeTempNamespace(t)
 	cl := makeClient(ns, t)
 
 	// the rest of the test can run in parallel
 	t.Parallel()
-	return cl, func() { util.DeleteNamespace(client, ns) }
+	return cl, cleanup
 }
 
 func TestStoreInvariant(t *testing.T) {
 	client, cleanup := makeTempClient(t)
 	defer cleanup()
-	mock.CheckMapInvariant(client, t, 5)
+	mock.CheckMapInvariant(client, t, client.namespace, 5)
+}
+
+func TestNamespaces(t *testing.T) {
+	client, cleanup := makeTempClient(t)
+	defer cleanup()
+	ns, cleanupNamespace := makeTempNamespace(t)
+	defer cleanupNamespace()
+	mock.CheckNamespaces(client, t, []string{client.namespace, ns})
 }
 
 func TestIstioConfig(t *testing.T) {
 	client, cleanup := makeTempClient(t)
 	defer cleanup()
-	mock.CheckIstioConfigTypes(client, t)
+	mock.CheckIstioConfigTypes(client, client.namespace, t)
 }
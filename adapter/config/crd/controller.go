@@ -21,7 +21,6 @@ import (
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/golang/protobuf/proto"
 	multierror "github.com/hashicorp/go-multierror"
 
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -41,13 +40,17 @@ type controller struct {
 	kinds  map[string]cacheHandler
 }
 
+// cacheHandler holds the informers for a config type, one per watched
+// namespace, sharing the chain of event handlers
 type cacheHandler struct {
-	informer cache.SharedIndexInformer
-	handler  *kube.ChainHandler
+	informers map[string]cache.SharedIndexInformer
+	handler   *kube.ChainHandler
 }
 
-// NewController creates a new Kubernetes controller for CRDs
-func NewController(client *Client, resyncPeriod time.Duration) model.ConfigStoreCache {
+// NewController creates a new Kubernetes controller for CRDs in the given
+// namespaces. If no namespace is supplied, the controller watches the default
+// namespace of the client. Use model.NamespaceAll to watch all namespaces.
+func NewController(client *Client, resyncPeriod time.Duration, namespaces ...string) model.ConfigStoreCache {
 	// Queue requires a time duration for a retry delay after a handler error
 	out := &controller{
 		client: client,
@@ -55,34 +58,54 @@ func NewController(client *Client, resyncPeriod time.Duration) model.ConfigStore
 		kinds:  make(map[string]cacheHandler),
 	}
 
+	if len(namespaces) == 0 {
+		namespaces = []string{client.namespace}
+	}
+	for _, namespace := range namespaces {
+		if namespace == model.NamespaceAll {
+			namespaces = []string{model.NamespaceAll}
+			break
+		}
+	}
+
 	// add stores for CRD kinds
 	for _, schema := range client.ConfigDescriptor() {
-		out.addInformer(schema, resyncPeriod)
+		out.addInformers(schema, namespaces, resyncPeriod)
 	}
 
 	return out
 }
 
-func (c *controller) addInformer(schema model.ProtoSchema, resyncPeriod time.Duration) {
-	c.kinds[schema.Type] = c.createInformer(knownTypes[schema.Type].object.DeepCopyObject(), resyncPeriod,
-		func(opts meta_v1.ListOptions) (result runtime.Object, err error) {
-			result = knownTypes[schema.Type].collection.DeepCopyObject()
-			err = c.client.dynamic.Get().
-				Namespace(c.client.namespace).
-				Resource(schema.Plural).
-				VersionedParams(&opts, meta_v1.ParameterCodec).
-				Do().
-				Into(result)
-			return
-		},
-		func(opts meta_v1.ListOptions) (watch.Interface, error) {
-			return c.client.dynamic.Get().
-				Prefix("watch").
-				Namespace(c.client.namespace).
-				Resource(schema.Plural).
-				VersionedParams(&opts, meta_v1.ParameterCodec).
-				Watch()
-		})
+func (c *controller) addInformers(schema model.ProtoSchema, namespaces []string, resyncPeriod time.Duration) {
+	handler := &kube.ChainHandler{}
+	handler.Append(c.notify)
+	informers := make(map[string]cache.SharedIndexInformer)
+	for _, ns := range namespaces {
+		namespace := ns
+		if _, exists := informers[namespace]; exists {
+			continue
+		}
+		informers[namespace] = c.createInformer(knownTypes[schema.Type].object.DeepCopyObject(), resyncPeriod,
+			func(opts meta_v1.ListOptions) (result runtime.Object, err error) {
+				result = knownTypes[schema.Type].collection.DeepCopyObject()
+				err = c.client.dynamic.Get().
+					Namespace(namespace).
+					Resource(schema.Plural).
+					VersionedParams(&opts, meta_v1.ParameterCodec).
+					Do().
+					Into(result)
+				return
+			},
+			func(opts meta_v1.ListOptions) (watch.Interface, error) {
+				return c.client.dynamic.Get().
+					Prefix("watch").
+					Namespace(namespace).
+					Resource(schema.Plural).
+					VersionedParams(&opts, meta_v1.ParameterCodec).
+					Watch()
+			}, handler)
+	}
+	c.kinds[schema.Type] = cacheHandler{informers: informers, handler: handler}
 }
 
 // notify is the first handler in the handler chain.
@@ -104,10 +127,8 @@ func (c *controller) createInformer(
 	o runtime.Object,
 	resyncPeriod time.Duration,
 	lf cache.ListFunc,
-	wf cache.WatchFunc) cacheHandler {
-	handler := &kube.ChainHandler{}
-	handler.Append(c.notify)
-
+	wf cache.WatchFunc,
+	handler *kube.ChainHandler) cache.SharedIndexInformer {
 	// TODO: finer-grained index (perf)
 	informer := cache.NewSharedIndexInformer(
 		&cache.ListWatch{ListFunc: lf, WatchFunc: wf}, o,
@@ -129,7 +150,7 @@ func (c *controller) createInformer(
 			},
 		})
 
-	return cacheHandler{informer: informer, handler: handler}
+	return informer
 }
 
 func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
@@ -140,16 +161,11 @@ func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model
 	c.kinds[typ].handler.Append(func(object interface{}, ev model.Event) error {
 		item, ok := object.(IstioObject)
 		if ok {
-			data, err := schema.FromJSONMap(item.GetSpec())
+			config, err := convertValidObject(schema, item)
 			if err != nil {
-				glog.Warningf("error translating object %#v", object)
+				glog.Warningf("error translating object %#v: %v", object, err)
 			} else {
-				f(model.Config{
-					Type:     schema.Type,
-					Key:      schema.Key(data),
-					Revision: item.GetObjectMeta().ResourceVersion,
-					Content:  data,
-				}, ev)
+				f(*config, ev)
 			}
 		}
 		return nil
@@ -158,9 +174,11 @@ func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model
 
 func (c *controller) HasSynced() bool {
 	for kind, ctl := range c.kinds {
-		if !ctl.informer.HasSynced() {
-			glog.V(2).Infof("controller %q is syncing...", kind)
-			return false
+		for _, informer := range ctl.informers {
+			if !informer.HasSynced() {
+				glog.V(2).Infof("controller %q is syncing...", kind)
+				return false
+			}
 		}
 	}
 	return true
@@ -170,7 +188,9 @@ func (c *controller) Run(stop <-chan struct{}) {
 	go c.queue.Run(stop)
 
 	for _, ctl := range c.kinds {
-		go ctl.informer.Run(stop)
+		for _, informer := range ctl.informers {
+			go informer.Run(stop)
+		}
 	}
 
 	<-stop
@@ -181,49 +201,79 @@ func (c *controller) ConfigDescriptor() model.ConfigDescriptor {
 	return c.client.ConfigDescriptor()
 }
 
-func (c *controller) Get(typ, key string) (proto.Message, bool, string) {
+func (c *controller) Get(typ, key, namespace string) (*model.Config, bool) {
 	schema, exists := c.client.ConfigDescriptor().GetByType(typ)
 	if !exists {
-		return nil, false, ""
+		return nil, false
 	}
 
-	store := c.kinds[typ].informer.GetStore()
-	data, exists, err := store.GetByKey(kube.KeyFunc(configKey(typ, key), c.client.namespace))
+	namespace = c.client.namespaceOrDefault(namespace)
+	informer, exists := c.kinds[typ].informers[namespace]
 	if !exists {
-		return nil, false, ""
+		informer, exists = c.kinds[typ].informers[model.NamespaceAll]
+	}
+	if !exists {
+		glog.V(2).Infof("namespace %q is not watched", namespace)
+		return nil, false
+	}
+
+	data, exists, err := informer.GetStore().GetByKey(kube.KeyFunc(configKey(typ, key), namespace))
+	if !exists {
+		// resources with a custom name are located by their key
+		return findByKey(schema, informer.GetStore().List(), key, namespace)
 	}
 	if err != nil {
 		glog.Warning(err)
-		return nil, false, ""
+		return nil, false
 	}
 
-	config, ok := data.(IstioObject)
+	obj, ok := data.(IstioObject)
 	if !ok {
 		glog.Warning("Cannot convert to config from store")
-		return nil, false, ""
+		return nil, false
 	}
 
-	out, err := schema.FromJSONMap(config.GetSpec())
+	config, err := convertValidObject(schema, obj)
 	if err != nil {
 		glog.Warning(err)
-		return nil, false, ""
+		return nil, false
+	}
+	return config, true
+}
+
+// findByKey finds a config by its key among the cached resources of a namespace
+func findByKey(schema model.ProtoSchema, objects []interface{},
+	key, namespace string) (*model.Config, bool) {
+	for _, data := range objects {
+		obj, ok := data.(IstioObject)
+		if !ok || obj.GetObjectMeta().Namespace != namespace {
+			continue
+		}
+		config, err := convertValidObject(schema, obj)
+		if err != nil {
+			glog.Warning(err)
+			continue
+		}
+		if config.Key == key {
+			return config, true
+		}
 	}
-	return out, true, config.GetObjectMeta().ResourceVersion
+	return nil, false
 }
 
-func (c *controller) Post(val proto.Message) (string, error) {
-	return c.client.Post(val)
+func (c *controller) Post(config model.Config) (string, error) {
+	return c.client.Post(config)
 }
 
-func (c *controller) Put(val proto.Message, revision string) (string, error) {
-	return c.client.Put(val, revision)
+func (c *controller) Put(config model.Config) (string, error) {
+	return c.client.Put(config)
 }
 
-func (c *controller) Delete(typ, key string) error {
-	return c.client.Delete(typ, key)
+func (c *controller) Delete(typ, key, namespace string) error {
+	return c.client.Delete(typ, key, namespace)
 }
 
-func (c *controller) List(typ string) ([]model.Config, error) {
+func (c *controller) List(typ, namespace string) ([]model.Config, error) {
 	schema, ok := c.client.ConfigDescriptor().GetByType(typ)
 	if !ok {
 		return nil, fmt.Errorf("missing type %q", typ)
@@ -231,19 +281,20 @@ func (c *controller) List(typ string) ([]model.Config, error) {
 
 	var errs error
 	out := make([]model.Config, 0)
-	for _, data := range c.kinds[typ].informer.GetStore().List() {
-		item, ok := data.(IstioObject)
-		if ok {
-			data, err := schema.FromJSONMap(item.GetSpec())
+	for _, informer := range c.kinds[typ].informers {
+		for _, data := range informer.GetStore().List() {
+			item, ok := data.(IstioObject)
+			if !ok {
+				continue
+			}
+			if namespace != model.NamespaceAll && item.GetObjectMeta().Namespace != namespace {
+				continue
+			}
+			config, err := convertValidObject(schema, item)
 			if err != nil {
 				errs = multierror.Append(errs, err)
 			} else {
-				out = append(out, model.Config{
-					Type:     schema.Type,
-					Key:      schema.Key(data),
-					Revision: item.GetObjectMeta().ResourceVersion,
-					Content:  data,
-				})
+				out = append(out, *config)
 			}
 		}
 	}
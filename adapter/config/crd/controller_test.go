@@ -18,7 +18,9 @@ import (
 	"testing"
 	"time"
 
+	"istio.io/pilot/model"
 	"istio.io/pilot/test/mock"
+	"istio.io/pilot/test/util"
 )
 
 const (
@@ -45,3 +47,37 @@ func TestControllerClientSync(t *testing.T) {
 	ctl := NewController(cl, resync)
 	mock.CheckCacheSync(cl, ctl, 5, t)
 }
+
+func TestControllerNamespaces(t *testing.T) {
+	cl, cleanup := makeTempClient(t)
+	defer cleanup()
+	ns, cleanupNamespace := makeTempNamespace(t)
+	defer cleanupNamespace()
+
+	// watch only the two namespaces
+	ctl := NewController(cl, resync, cl.namespace, ns)
+	stop := make(chan struct{})
+	defer close(stop)
+	go ctl.Run(stop)
+
+	for _, namespace := range []string{cl.namespace, ns} {
+		if _, err := cl.Post(model.Config{Namespace: namespace, Content: mock.Make(0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	util.Eventually(func() bool {
+		configs, _ := ctl.List(model.MockConfig.Type, model.NamespaceAll)
+		return len(configs) == 2
+	}, t)
+
+	for _, namespace := range []string{cl.namespace, ns} {
+		config, exists := ctl.Get(model.MockConfig.Type, mock.Make(0).Key, namespace)
+		if !exists || config.Namespace != namespace {
+			t.Errorf("Get(%s) => got %v", namespace, config)
+		}
+		if configs, _ := ctl.List(model.MockConfig.Type, namespace); len(configs) != 1 {
+			t.Errorf("List(%s) => got %d config(s), want 1", namespace, len(configs))
+		}
+	}
+}
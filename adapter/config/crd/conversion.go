@@ -16,12 +16,12 @@ package crd
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 
+	multierror "github.com/hashicorp/go-multierror"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	"github.com/golang/protobuf/proto"
-
 	"istio.io/pilot/model"
 )
 
@@ -39,24 +39,63 @@ func configKey(typ, key string) string {
 	return key
 }
 
-// modelToKube translates Istio config to k8s config JSON
-func modelToKube(schema model.ProtoSchema, namespace string, config proto.Message,
-	revision string) (IstioObject, error) {
-	spec, err := schema.ToJSONMap(config)
+// modelToKube translates Istio config to k8s config JSON. The resource name
+// is derived from the config key unless the config has a name.
+func modelToKube(schema model.ProtoSchema, config model.Config) (IstioObject, error) {
+	spec, err := schema.ToJSONMap(config.Content)
 	if err != nil {
 		return nil, err
 	}
+	name := config.Name
+	if name == "" {
+		name = configKey(schema.Type, schema.Key(config.Content))
+	}
 	out := knownTypes[schema.Type].object.DeepCopyObject().(IstioObject)
 	out.SetObjectMeta(meta_v1.ObjectMeta{
-		Name:            configKey(schema.Type, schema.Key(config)),
-		Namespace:       namespace,
-		ResourceVersion: revision,
+		Name:            name,
+		Namespace:       config.Namespace,
+		ResourceVersion: config.Revision,
+		Labels:          config.Labels,
+		Annotations:     config.Annotations,
 	})
 	out.SetSpec(spec)
 
 	return out, nil
 }
 
+// convertObject translates k8s config object to Istio config
+func convertObject(schema model.ProtoSchema, object IstioObject) (*model.Config, error) {
+	data, err := schema.FromJSONMap(object.GetSpec())
+	if err != nil {
+		return nil, err
+	}
+	meta := object.GetObjectMeta()
+	return &model.Config{
+		Type:        schema.Type,
+		Key:         schema.Key(data),
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+		Revision:    meta.ResourceVersion,
+		Content:     data,
+	}, nil
+}
+
+// convertValidObject translates k8s config object to Istio config and
+// validates its content and annotations. Objects that bypassed the admission
+// webhook are rejected as a whole rather than applied without the invalid parts.
+func convertValidObject(schema model.ProtoSchema, object IstioObject) (*model.Config, error) {
+	config, err := convertObject(schema, object)
+	if err != nil {
+		return nil, err
+	}
+	if err = schema.ValidateConfig(*config); err != nil {
+		return nil, multierror.Prefix(err, fmt.Sprintf("invalid %s %s/%s:", schema.Type, config.Namespace, config.Name))
+	}
+	return config, nil
+}
+
 // camelCaseToKabobCase converts "my-name" to "MyName"
 func kabobCaseToCamelCase(s string) string {
 	words := strings.Split(s, "-")
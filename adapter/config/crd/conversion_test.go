@@ -14,7 +14,12 @@
 
 package crd
 
-import "testing"
+import (
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
 
 var (
 	camelKabobs = []struct{ in, out string }{
@@ -36,3 +41,54 @@ func TestCamelKabob(t *testing.T) {
 		}
 	}
 }
+
+func TestModelToKubeName(t *testing.T) {
+	for _, name := range []string{"", "custom-name"} {
+		obj, err := modelToKube(model.RouteRule, model.Config{
+			Name:      name,
+			Namespace: "default",
+			Content:   mock.ExampleRouteRule,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := name
+		if want == "" {
+			want = configKey(model.RouteRule.Type, model.RouteRule.Key(mock.ExampleRouteRule))
+		}
+		if got := obj.GetObjectMeta().Name; got != want {
+			t.Errorf("modelToKube(name %q) => got resource name %q, want %q", name, got, want)
+		}
+
+		config, err := convertObject(model.RouteRule, obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Name != want || config.Key != model.RouteRule.Key(mock.ExampleRouteRule) {
+			t.Errorf("convertObject => got name %q and key %q", config.Name, config.Key)
+		}
+	}
+}
+
+func TestConvertValidObject(t *testing.T) {
+	for _, c := range []struct {
+		annotations map[string]string
+		valid       bool
+	}{
+		{valid: true},
+		{annotations: map[string]string{model.ConsistentHashAnnotation: "httpHeaderName=x-user"}, valid: true},
+		{annotations: map[string]string{model.ConsistentHashAnnotation: "useSourceIp=true"}},
+	} {
+		obj, err := modelToKube(model.DestinationPolicy, model.Config{
+			Namespace:   "default",
+			Annotations: c.annotations,
+			Content:     mock.ExampleDestinationPolicy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = convertValidObject(model.DestinationPolicy, obj); (err == nil) != c.valid {
+			t.Errorf("convertValidObject(%v) => got error %v, want valid %t", c.annotations, err, c.valid)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file provides a read-only config store cache backed by a directory
+// of YAML or JSON files. Each file holds a stream of documents separated by
+// "---" lines, in the same format as the input to istioctl:
+//
+//	type: route-rule
+//	namespace: default
+//	spec:
+//	  destination: reviews.default.svc.cluster.local
+//	  ...
+//
+// The directory is watched for file changes and the config objects are
+// reloaded, with add, update, and delete events emitted for the differences.
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/howeyc/fsnotify"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+)
+
+var (
+	errUnsupportedOp = errors.New("unsupported operation: the file config store is a read-only view")
+
+	// extensions lists the file extensions of config files
+	extensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+	// separator splits documents in a YAML stream
+	separator = regexp.MustCompile(`(?m)^---\s*$`)
+)
+
+// reloadDelay is the quiet period after a file change before the directory is
+// reloaded, so that the intermediate states of a file write are not observed
+const reloadDelay = 100 * time.Millisecond
+
+// document is the serialized form of a config object
+type document struct {
+	Type        string                 `json:"type"`
+	Namespace   string                 `json:"namespace,omitempty"`
+	Labels      map[string]string      `json:"labels,omitempty"`
+	Annotations map[string]string      `json:"annotations,omitempty"`
+	Spec        map[string]interface{} `json:"spec"`
+}
+
+type controller struct {
+	root       string
+	descriptor model.ConfigDescriptor
+	queue      kube.Queue
+	handlers   map[string]*kube.ChainHandler
+
+	// mutex guards the config objects by type and qualified key, the config
+	// objects by file name, the revision counter, and the sync flag
+	mutex    sync.RWMutex
+	data     map[string]map[string]model.Config
+	files    map[string][]model.Config
+	revision uint64
+	synced   bool
+}
+
+// NewController creates a config store cache for the config files in the
+// root directory. Sub-directories and files without a YAML or JSON extension
+// are ignored.
+func NewController(root string, descriptor model.ConfigDescriptor) model.ConfigStoreCache {
+	out := &controller{
+		root:       root,
+		descriptor: descriptor,
+		// queue requires a time duration for a retry delay after a handler error
+		queue:    kube.NewQueue(1 * time.Second),
+		handlers: make(map[string]*kube.ChainHandler),
+		data:     make(map[string]map[string]model.Config),
+		files:    make(map[string][]model.Config),
+	}
+	for _, typ := range descriptor.Types() {
+		out.handlers[typ] = &kube.ChainHandler{}
+		out.data[typ] = make(map[string]model.Config)
+	}
+	return out
+}
+
+func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
+	handler, exists := c.handlers[typ]
+	if !exists {
+		return
+	}
+	handler.Append(func(obj interface{}, event model.Event) error {
+		f(obj.(model.Config), event)
+		return nil
+	})
+}
+
+func (c *controller) HasSynced() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.synced
+}
+
+func (c *controller) Run(stop <-chan struct{}) {
+	go c.queue.Run(stop)
+
+	// watch the directory prior to the initial load to avoid missing changes
+	var events <-chan *fsnotify.FileEvent
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Warningf("failed to create a watcher for config files: %v", err)
+	} else {
+		defer func() {
+			if err := watcher.Close(); err != nil {
+				glog.Warningf("closing watcher encounters an error %v", err)
+			}
+		}()
+		if err := watcher.Watch(c.root); err != nil {
+			glog.Warningf("watching %s encounters an error %v", c.root, err)
+		} else {
+			events, errs = watcher.Event, watcher.Error
+		}
+	}
+
+	c.reload()
+
+	// reload is deferred until no change is detected for the reload delay
+	var reload <-chan time.Time
+	for {
+		select {
+		case event := <-events:
+			glog.V(2).Infof("Change to %q is detected, reloading config files", event.Name)
+			reload = time.After(reloadDelay)
+		case <-reload:
+			reload = nil
+			c.reload()
+		case err := <-errs:
+			glog.Warningf("watching %s encounters an error %v", c.root, err)
+		case <-stop:
+			glog.V(2).Info("File config store is terminated")
+			return
+		}
+	}
+}
+
+// reload reads the directory and emits events for the changed config objects
+func (c *controller) reload() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	files, err := readDirectory(c.root, c.descriptor, c.files)
+	if files == nil && err != nil {
+		glog.Warningf("failed to read config directory %s: %v", c.root, err)
+		return
+	} else if err != nil {
+		glog.Warningf("skipping invalid config objects: %v", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	configs := make([]model.Config, 0)
+	for _, name := range names {
+		configs = append(configs, files[name]...)
+	}
+
+	next := make(map[string]map[string]model.Config)
+	for _, typ := range c.descriptor.Types() {
+		next[typ] = make(map[string]model.Config)
+	}
+
+	for _, config := range configs {
+		key := config.QualifiedKey()
+		if _, exists := next[config.Type][key]; exists {
+			glog.Warningf("duplicate %s %q, ignoring the later definition", config.Type, key)
+			continue
+		}
+
+		old, exists := c.data[config.Type][key]
+		switch {
+		case !exists:
+			config.Revision = c.nextRevision()
+			c.emit(config, model.EventAdd)
+		case equal(old, config):
+			config.Revision = old.Revision
+		default:
+			config.Revision = c.nextRevision()
+			c.emit(config, model.EventUpdate)
+		}
+		next[config.Type][key] = config
+	}
+
+	for _, typ := range c.descriptor.Types() {
+		keys := make([]string, 0)
+		for key := range c.data[typ] {
+			if _, exists := next[typ][key]; !exists {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			c.emit(c.data[typ][key], model.EventDelete)
+		}
+	}
+
+	c.data = next
+	c.files = files
+	c.synced = true
+}
+
+// nextRevision increments the revision counter, must be called with the lock held
+func (c *controller) nextRevision() string {
+	c.revision++
+	return strconv.FormatUint(c.revision, 10)
+}
+
+// emit queues an event for the handlers, must be called with the lock held
+func (c *controller) emit(config model.Config, event model.Event) {
+	if handler, exists := c.handlers[config.Type]; exists {
+		c.queue.Push(kube.NewTask(handler.Apply, config, event))
+	}
+}
+
+// equal compares config objects ignoring revisions
+func equal(a, b model.Config) bool {
+	return a.Name == b.Name &&
+		reflect.DeepEqual(a.Labels, b.Labels) &&
+		reflect.DeepEqual(a.Annotations, b.Annotations) &&
+		proto.Equal(a.Content, b.Content)
+}
+
+// readDirectory parses config files in the directory and returns the config
+// objects by file name. Invalid documents are reported in the returned error.
+// The previous config objects of the files that fail to read or parse are
+// retained, so that a partially written file does not delete its objects;
+// otherwise the invalid documents are skipped.
+func readDirectory(root string, descriptor model.ConfigDescriptor,
+	previous map[string][]model.Config) (map[string][]model.Config, error) {
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs error
+	out := make(map[string][]model.Config)
+	for _, file := range files {
+		if file.IsDir() || !extensions[strings.ToLower(filepath.Ext(file.Name()))] {
+			continue
+		}
+		name := filepath.Join(root, file.Name())
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			if configs, exists := previous[name]; exists {
+				out[name] = configs
+			}
+			continue
+		}
+		configs, err := parseConfigs(data, descriptor)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, name+":"))
+			if prev, exists := previous[name]; exists {
+				configs = prev
+			}
+		}
+		out[name] = configs
+	}
+	return out, errs
+}
+
+// parseConfigs parses and validates a stream of config documents
+func parseConfigs(data []byte, descriptor model.ConfigDescriptor) ([]model.Config, error) {
+	var errs error
+	out := make([]model.Config, 0)
+	for i, chunk := range separator.Split(string(data), -1) {
+		if strings.TrimSpace(chunk) == "" {
+			continue
+		}
+		config, err := parseConfig(chunk, descriptor)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("document %d: %v", i, err))
+			continue
+		}
+		out = append(out, config)
+	}
+	return out, errs
+}
+
+func parseConfig(chunk string, descriptor model.ConfigDescriptor) (model.Config, error) {
+	var doc document
+	if err := yaml.Unmarshal([]byte(chunk), &doc); err != nil {
+		return model.Config{}, err
+	}
+	schema, exists := descriptor.GetByType(doc.Type)
+	if !exists {
+		return model.Config{}, fmt.Errorf("unrecognized type %q", doc.Type)
+	}
+	content, err := schema.FromJSONMap(doc.Spec)
+	if err != nil {
+		return model.Config{}, err
+	}
+	key := schema.Key(content)
+	config := model.Config{
+		Type:        schema.Type,
+		Key:         key,
+		Name:        key,
+		Namespace:   doc.Namespace,
+		Labels:      doc.Labels,
+		Annotations: doc.Annotations,
+		Content:     content,
+	}
+	if err = schema.ValidateConfig(config); err != nil {
+		return model.Config{}, multierror.Prefix(err, "validation error:")
+	}
+	return config, nil
+}
+
+func (c *controller) ConfigDescriptor() model.ConfigDescriptor {
+	return c.descriptor
+}
+
+func (c *controller) Get(typ, key, namespace string) (*model.Config, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	config, exists := c.data[typ][model.Config{Key: key, Namespace: namespace}.QualifiedKey()]
+	if !exists {
+		return nil, false
+	}
+	return &config, true
+}
+
+func (c *controller) List(typ, namespace string) ([]model.Config, error) {
+	if _, exists := c.descriptor.GetByType(typ); !exists {
+		return nil, fmt.Errorf("missing type %q", typ)
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	out := make([]model.Config, 0, len(c.data[typ]))
+	for _, config := range c.data[typ] {
+		if namespace == model.NamespaceAll || config.Namespace == namespace {
+			out = append(out, config)
+		}
+	}
+	return out, nil
+}
+
+func (c *controller) Post(_ model.Config) (string, error) {
+	return "", errUnsupportedOp
+}
+
+func (c *controller) Put(_ model.Config) (string, error) {
+	return "", errUnsupportedOp
+}
+
+func (c *controller) Delete(_, _, _ string) error {
+	return errUnsupportedOp
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/util"
+)
+
+const (
+	routeRuleYAML = `
+type: route-rule
+namespace: default
+labels:
+  app: reviews
+spec:
+  name: reviews-default
+  destination: reviews.default.svc.cluster.local
+  precedence: 1
+  route:
+  - tags:
+      version: v1
+    weight: 100
+---
+type: destination-policy
+spec:
+  destination: reviews.default.svc.cluster.local
+  policy:
+  - loadBalancing:
+      name: RANDOM
+`
+
+	routeRuleUpdatedYAML = `
+type: route-rule
+namespace: default
+labels:
+  app: reviews
+spec:
+  name: reviews-default
+  destination: reviews.default.svc.cluster.local
+  precedence: 2
+`
+
+	ingressRuleJSON = `{
+  "type": "ingress-rule",
+  "namespace": "istio-system",
+  "spec": {
+    "name": "ingress",
+    "destination": "reviews.default.svc.cluster.local",
+    "port": 80,
+    "destinationPort": 9080
+  }
+}`
+
+	invalidYAML = `
+type: route-rule
+spec:
+  name: missing-destination
+---
+type: missing-type
+spec:
+  name: missing
+`
+)
+
+func TestParseConfigs(t *testing.T) {
+	configs, err := parseConfigs([]byte(routeRuleYAML), model.IstioConfigTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("got %d config(s), want 2", len(configs))
+	}
+	rule, ok := configs[0].Content.(*proxyconfig.RouteRule)
+	if !ok || configs[0].Key != "reviews-default" || configs[0].Namespace != "default" ||
+		configs[0].Labels["app"] != "reviews" || rule.Route[0].Weight != 100 {
+		t.Errorf("unexpected route rule %#v", configs[0])
+	}
+	if configs[1].Type != model.DestinationPolicy.Type || configs[1].Namespace != "" {
+		t.Errorf("unexpected destination policy %#v", configs[1])
+	}
+
+	configs, err = parseConfigs([]byte(ingressRuleJSON), model.IstioConfigTypes)
+	if err != nil || len(configs) != 1 || configs[0].Type != model.IngressRule.Type {
+		t.Errorf("parseConfigs(JSON) => got %v, %v", configs, err)
+	}
+
+	if configs, err = parseConfigs([]byte(invalidYAML), model.IstioConfigTypes); err == nil || len(configs) != 0 {
+		t.Errorf("parseConfigs(invalid) => got %v, want errors", configs)
+	}
+}
+
+func TestReadDirectory(t *testing.T) {
+	root, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	defer func() {
+		if err = os.RemoveAll(root); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	name := filepath.Join(root, "rules.yaml")
+	if err = ioutil.WriteFile(name, []byte(routeRuleYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := readDirectory(root, model.IstioConfigTypes, nil)
+	if err != nil || len(files[name]) != 2 {
+		t.Fatalf("readDirectory() => got %v, %v", files, err)
+	}
+
+	// a file that fails to parse retains its previous config objects
+	if err = ioutil.WriteFile(name, []byte(routeRuleYAML+invalidYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	next, err := readDirectory(root, model.IstioConfigTypes, files)
+	if err == nil || !reflect.DeepEqual(next, files) {
+		t.Errorf("readDirectory(invalid) => got %v, %v", next, err)
+	}
+
+	// without previous objects, the invalid documents are skipped
+	if next, err = readDirectory(root, model.IstioConfigTypes, nil); err == nil || len(next[name]) != 2 {
+		t.Errorf("readDirectory(invalid) => got %v, %v", next, err)
+	}
+}
+
+type recorder struct {
+	mutex  sync.Mutex
+	events map[model.Event]int
+}
+
+func (r *recorder) handle(_ model.Config, ev model.Event) {
+	r.mutex.Lock()
+	r.events[ev]++
+	r.mutex.Unlock()
+}
+
+func (r *recorder) count(ev model.Event) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.events[ev]
+}
+
+func TestControllerEvents(t *testing.T) {
+	root, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	defer func() {
+		if err = os.RemoveAll(root); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rules.yaml", routeRuleYAML)
+	write("ignored.txt", ingressRuleJSON)
+
+	ctl := NewController(root, model.IstioConfigTypes)
+	rules := &recorder{events: make(map[model.Event]int)}
+	ctl.RegisterEventHandler(model.RouteRule.Type, rules.handle)
+	ingress := &recorder{events: make(map[model.Event]int)}
+	ctl.RegisterEventHandler(model.IngressRule.Type, ingress.handle)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go ctl.Run(stop)
+
+	util.Eventually(func() bool { return ctl.HasSynced() && rules.count(model.EventAdd) == 1 }, t)
+	if config, exists := ctl.Get(model.RouteRule.Type, "reviews-default", "default"); !exists || config.Revision == "" {
+		t.Errorf("Get() => got %v, %t", config, exists)
+	}
+	if configs, _ := ctl.List(model.DestinationPolicy.Type, model.NamespaceAll); len(configs) != 1 {
+		t.Errorf("List() => got %d destination policies, want 1", len(configs))
+	}
+	if _, err = ctl.Post(model.Config{}); err == nil {
+		t.Error("Post should not be allowed")
+	}
+
+	write("ingress.json", ingressRuleJSON)
+	util.Eventually(func() bool { return ingress.count(model.EventAdd) == 1 }, t)
+
+	write("rules.yaml", routeRuleUpdatedYAML)
+	util.Eventually(func() bool { return rules.count(model.EventUpdate) == 1 }, t)
+	if configs, _ := ctl.List(model.DestinationPolicy.Type, model.NamespaceAll); len(configs) != 0 {
+		t.Errorf("List() => got %d destination policies, want none", len(configs))
+	}
+
+	if err = os.Remove(filepath.Join(root, "rules.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	util.Eventually(func() bool { return rules.count(model.EventDelete) == 1 }, t)
+
+	if rules.count(model.EventAdd) != 1 || ingress.count(model.EventDelete) != 0 {
+		t.Errorf("unexpected events %v and %v", rules.events, ingress.events)
+	}
+}
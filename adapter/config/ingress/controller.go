@@ -22,7 +22,6 @@ import (
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/golang/protobuf/proto"
 
 	"k8s.io/api/extensions/v1beta1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -119,12 +118,7 @@ func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model
 		// A updated ingress may also trigger an Add or Delete for one of its constituent sub-rules.
 		rules := convertIngress(*ingress, c.domainSuffix)
 		for key, rule := range rules {
-			config := model.Config{
-				Type:    model.IngressRule.Type,
-				Key:     key,
-				Content: rule,
-			}
-			f(config, event)
+			f(convertConfig(ingress, key, rule), event)
 		}
 
 		return nil
@@ -145,38 +139,45 @@ func (c *controller) ConfigDescriptor() model.ConfigDescriptor {
 	return model.ConfigDescriptor{model.IngressRule}
 }
 
-func (c *controller) Get(typ, key string) (proto.Message, bool, string) {
+func (c *controller) Get(typ, key, namespace string) (*model.Config, bool) {
 	if typ != model.IngressRule.Type {
-		return nil, false, ""
+		return nil, false
 	}
 
 	ingressName, ingressNamespace, _, _, err := decodeIngressRuleName(key)
 	if err != nil {
 		glog.V(2).Infof("getIngress(%s) => error %v", key, err)
-		return nil, false, ""
+		return nil, false
+	}
+	if namespace != "" && namespace != ingressNamespace {
+		return nil, false
 	}
 	storeKey := kube.KeyFunc(ingressName, ingressNamespace)
 
 	obj, exists, err := c.informer.GetStore().GetByKey(storeKey)
 	if err != nil {
 		glog.V(2).Infof("getIngress(%s) => error %v", key, err)
-		return nil, false, ""
+		return nil, false
 	}
 	if !exists {
-		return nil, false, ""
+		return nil, false
 	}
 
 	ingress := obj.(*v1beta1.Ingress)
 	if !shouldProcessIngress(c.mesh, ingress) {
-		return nil, false, ""
+		return nil, false
 	}
 
 	rules := convertIngress(*ingress, c.domainSuffix)
 	rule, exists := rules[key]
-	return rule, exists, ingress.GetResourceVersion()
+	if !exists {
+		return nil, false
+	}
+	config := convertConfig(ingress, key, rule)
+	return &config, true
 }
 
-func (c *controller) List(typ string) ([]model.Config, error) {
+func (c *controller) List(typ, namespace string) ([]model.Config, error) {
 	if typ != model.IngressRule.Type {
 		return nil, errUnsupportedOp
 	}
@@ -184,15 +185,13 @@ func (c *controller) List(typ string) ([]model.Config, error) {
 	out := make([]model.Config, 0)
 	for _, obj := range c.informer.GetStore().List() {
 		ingress := obj.(*v1beta1.Ingress)
+		if namespace != model.NamespaceAll && ingress.Namespace != namespace {
+			continue
+		}
 		if shouldProcessIngress(c.mesh, ingress) {
 			ingressRules := convertIngress(*ingress, c.domainSuffix)
 			for key, rule := range ingressRules {
-				out = append(out, model.Config{
-					Type:     model.IngressRule.Type,
-					Key:      key,
-					Revision: ingress.GetResourceVersion(),
-					Content:  rule,
-				})
+				out = append(out, convertConfig(ingress, key, rule))
 			}
 		}
 	}
@@ -200,14 +199,14 @@ func (c *controller) List(typ string) ([]model.Config, error) {
 	return out, nil
 }
 
-func (c *controller) Post(_ proto.Message) (string, error) {
+func (c *controller) Post(_ model.Config) (string, error) {
 	return "", errUnsupportedOp
 }
 
-func (c *controller) Put(_ proto.Message, _ string) (string, error) {
+func (c *controller) Put(_ model.Config) (string, error) {
 	return "", errUnsupportedOp
 }
 
-func (c *controller) Delete(_, _ string) error {
+func (c *controller) Delete(_, _, _ string) error {
 	return errUnsupportedOp
 }
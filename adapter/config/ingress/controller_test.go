@@ -17,6 +17,7 @@ package ingress_test
 import (
 	"os"
 	"os/user"
+	"reflect"
 	"testing"
 	"time"
 
@@ -86,15 +87,15 @@ func TestIngressController(t *testing.T) {
 	}
 
 	// make sure all operations error out
-	if _, err := ctl.Post(mock.ExampleIngressRule); err == nil {
+	if _, err := ctl.Post(model.Config{Content: mock.ExampleIngressRule}); err == nil {
 		t.Errorf("Post should not be allowed")
 	}
 
-	if _, err := ctl.Put(mock.ExampleIngressRule, ""); err == nil {
+	if _, err := ctl.Put(model.Config{Content: mock.ExampleIngressRule}); err == nil {
 		t.Errorf("Put should not be allowed")
 	}
 
-	if err := ctl.Delete(model.IngressRule.Type, "test"); err == nil {
+	if err := ctl.Delete(model.IngressRule.Type, "test", ""); err == nil {
 		t.Errorf("Delete should not be allowed")
 	}
 
@@ -199,10 +200,10 @@ func TestIngressController(t *testing.T) {
 	}
 
 	util.Eventually(func() bool {
-		rules, _ := ctl.List(model.IngressRule.Type)
+		rules, _ := ctl.List(model.IngressRule.Type, ns)
 		return len(rules) == expectedRuleCount
 	}, t)
-	rules, err := ctl.List(model.IngressRule.Type)
+	rules, err := ctl.List(model.IngressRule.Type, ns)
 	if err != nil {
 		t.Errorf("ctl.List(model.IngressRule, %s) => error: %v", ns, err)
 	}
@@ -211,28 +212,33 @@ func TestIngressController(t *testing.T) {
 	}
 
 	for _, listMsg := range rules {
-		getMsg, exists, _ := ctl.Get(model.IngressRule.Type, listMsg.Key)
+		if listMsg.Namespace != ns {
+			t.Errorf("expected IngressRule %v in namespace %s, got %s", listMsg.Key, ns, listMsg.Namespace)
+		}
+
+		getMsg, exists := ctl.Get(model.IngressRule.Type, listMsg.Key, listMsg.Namespace)
 		if !exists {
 			t.Errorf("expected IngressRule with key %v to exist", listMsg.Key)
+			continue
+		}
+
+		listRule, ok := listMsg.Content.(*proxyconfig.IngressRule)
+		if !ok {
+			t.Errorf("expected IngressRule but got %v", listMsg.Content)
+		}
 
-			listRule, ok := listMsg.Content.(*proxyconfig.IngressRule)
-			if !ok {
-				t.Errorf("expected IngressRule but got %v", listMsg.Content)
-			}
-
-			getRule, ok := getMsg.(*proxyconfig.IngressRule)
-			if !ok {
-				t.Errorf("expected IngressRule but got %v", getMsg)
-			}
-
-			// TODO:  Compare listRule and getRule objects
-			if listRule == nil {
-				t.Errorf("expected listRule to be of type *proxyconfig.RouteRule")
-			}
-			if getRule == nil {
-				t.Errorf("expected getRule to be of type *proxyconfig.RouteRule")
-			}
+		getRule, ok := getMsg.Content.(*proxyconfig.IngressRule)
+		if !ok {
+			t.Errorf("expected IngressRule but got %v", getMsg.Content)
 		}
+
+		if !reflect.DeepEqual(listRule, getRule) {
+			t.Errorf("expected Get and List to return the same rule, got %v and %v", getRule, listRule)
+		}
+	}
+
+	if rules, _ := ctl.List(model.IngressRule.Type, "missing"); len(rules) != 0 {
+		t.Errorf("expected no IngressRule objects in a missing namespace, found %d", len(rules))
 	}
 }
 
@@ -61,6 +61,21 @@ func convertIngress(ingress v1beta1.Ingress, domainSuffix string) map[string]*pr
 	return out
 }
 
+// convertConfig wraps an ingress rule into a config object with the metadata
+// of the originating ingress resource
+func convertConfig(ingress *v1beta1.Ingress, key string, rule *proxyconfig.IngressRule) model.Config {
+	return model.Config{
+		Type:        model.IngressRule.Type,
+		Key:         key,
+		Name:        ingress.Name,
+		Namespace:   ingress.Namespace,
+		Labels:      ingress.Labels,
+		Annotations: ingress.Annotations,
+		Revision:    ingress.GetResourceVersion(),
+		Content:     rule,
+	}
+}
+
 func createIngressRule(name, host, path, namespace, domainSuffix string,
 	backend v1beta1.IngressBackend, tlsSecret string) *proxyconfig.IngressRule {
 	rule := &proxyconfig.IngressRule{
@@ -17,7 +17,8 @@ package memory
 
 import (
 	"errors"
-	"time"
+	"strconv"
+	"sync"
 
 	"github.com/golang/protobuf/proto"
 
@@ -26,107 +27,151 @@ import (
 
 // Make creates an in-memory config store from a config descriptor
 func Make(descriptor model.ConfigDescriptor) model.ConfigStore {
+	return newStore(descriptor)
+}
+
+func newStore(descriptor model.ConfigDescriptor) *store {
 	out := store{
 		descriptor: descriptor,
-		data:       make(map[string]map[string]proto.Message),
-		revs:       make(map[string]map[string]string),
+		data:       make(map[string]map[string]model.Config),
 	}
 	for _, typ := range descriptor.Types() {
-		out.data[typ] = make(map[string]proto.Message)
-		out.revs[typ] = make(map[string]string)
+		out.data[typ] = make(map[string]model.Config)
 	}
 	return &out
 }
 
+// store keeps the config objects by type and qualified key
 type store struct {
 	descriptor model.ConfigDescriptor
-	data       map[string]map[string]proto.Message
-	revs       map[string]map[string]string
+
+	// mutex guards the data and the revision counter
+	mutex    sync.RWMutex
+	data     map[string]map[string]model.Config
+	revision uint64
+
+	// notify is invoked for every successful mutation while holding the lock,
+	// so that the order of notifications matches the order of mutations
+	notify func(model.Config, model.Event)
 }
 
 func (cr *store) ConfigDescriptor() model.ConfigDescriptor {
 	return cr.descriptor
 }
 
-func (cr *store) Get(typ, key string) (proto.Message, bool, string) {
+func (cr *store) Get(typ, key, namespace string) (*model.Config, bool) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
 	_, ok := cr.data[typ]
 	if !ok {
-		return nil, false, ""
+		return nil, false
+	}
+	config, exists := cr.data[typ][model.Config{Key: key, Namespace: namespace}.QualifiedKey()]
+	if !exists {
+		return nil, false
 	}
-	val, exists := cr.data[typ][key]
-	return val, exists, cr.revs[typ][key]
+	return &config, true
 }
 
-func (cr *store) List(typ string) ([]model.Config, error) {
+func (cr *store) List(typ, namespace string) ([]model.Config, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
 	_, ok := cr.data[typ]
 	if !ok {
 		return nil, nil
 	}
 	out := make([]model.Config, 0, len(cr.data[typ]))
-	for key, elt := range cr.data[typ] {
-		out = append(out, model.Config{
-			Type:     typ,
-			Key:      key,
-			Revision: cr.revs[typ][key],
-			Content:  elt,
-		})
+	for _, config := range cr.data[typ] {
+		if namespace == model.NamespaceAll || config.Namespace == namespace {
+			out = append(out, config)
+		}
 	}
 	return out, nil
 }
 
-func (cr *store) Delete(typ, key string) error {
+func (cr *store) Delete(typ, key, namespace string) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
 	_, ok := cr.data[typ]
 	if !ok {
 		return errors.New("unknown type")
 	}
-	if _, exists := cr.data[typ][key]; exists {
-		delete(cr.data[typ], key)
-		delete(cr.revs[typ], key)
+	qualified := model.Config{Key: key, Namespace: namespace}.QualifiedKey()
+	if old, exists := cr.data[typ][qualified]; exists {
+		delete(cr.data[typ], qualified)
+		cr.emit(old, model.EventDelete)
 		return nil
 	}
 	return &model.ItemNotFoundError{Key: key}
 }
 
-func (cr *store) Post(config proto.Message) (string, error) {
-	schema, ok := cr.descriptor.GetByMessageName(proto.MessageName(config))
-	if !ok {
-		return "", errors.New("unknown type")
-	}
-	if err := schema.Validate(config); err != nil {
+func (cr *store) Post(config model.Config) (string, error) {
+	config, err := cr.complete(config)
+	if err != nil {
 		return "", err
 	}
-	typ := schema.Type
-	key := schema.Key(config)
-	_, exists := cr.data[typ][key]
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	qualified := config.QualifiedKey()
+	_, exists := cr.data[config.Type][qualified]
 	if !exists {
-		rev := time.Now().String()
-		cr.revs[typ][key] = rev
-		cr.data[typ][key] = config
-		return rev, nil
+		config.Revision = cr.nextRevision()
+		cr.data[config.Type][qualified] = config
+		cr.emit(config, model.EventAdd)
+		return config.Revision, nil
 	}
-	return "", &model.ItemAlreadyExistsError{Key: key}
+	return "", &model.ItemAlreadyExistsError{Key: config.Key}
 }
 
-func (cr *store) Put(config proto.Message, oldRevision string) (string, error) {
-	schema, ok := cr.descriptor.GetByMessageName(proto.MessageName(config))
-	if !ok {
-		return "", errors.New("unknown type")
-	}
-	if err := schema.Validate(config); err != nil {
+func (cr *store) Put(config model.Config) (string, error) {
+	oldRevision := config.Revision
+	config, err := cr.complete(config)
+	if err != nil {
 		return "", err
 	}
-	typ := schema.Type
-	key := schema.Key(config)
-	_, exists := cr.data[typ][key]
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	qualified := config.QualifiedKey()
+	old, exists := cr.data[config.Type][qualified]
 	if !exists {
-		return "", &model.ItemNotFoundError{Key: key}
+		return "", &model.ItemNotFoundError{Key: config.Key}
 	}
-	if oldRevision != cr.revs[typ][key] {
+	if oldRevision != old.Revision {
 		return "", errors.New("old revision")
 	}
 
-	rev := time.Now().String()
-	cr.revs[typ][key] = rev
-	cr.data[typ][key] = config
-	return rev, nil
+	config.Revision = cr.nextRevision()
+	cr.data[config.Type][qualified] = config
+	cr.emit(config, model.EventUpdate)
+	return config.Revision, nil
+}
+
+// nextRevision increments the revision counter, must be called with the lock held
+func (cr *store) nextRevision() string {
+	cr.revision++
+	return strconv.FormatUint(cr.revision, 10)
+}
+
+// emit notifies about a mutation, must be called with the lock held
+func (cr *store) emit(config model.Config, event model.Event) {
+	if cr.notify != nil {
+		cr.notify(config, event)
+	}
+}
+
+// complete validates the config content and fills in the type, the key, and the name
+func (cr *store) complete(config model.Config) (model.Config, error) {
+	schema, ok := cr.descriptor.GetByMessageName(proto.MessageName(config.Content))
+	if !ok {
+		return config, errors.New("unknown type")
+	}
+	if err := schema.ValidateConfig(config); err != nil {
+		return config, err
+	}
+	config.Type = schema.Type
+	config.Key = schema.Key(config.Content)
+	if config.Name == "" {
+		config.Name = config.Key
+	}
+	return config, nil
 }
@@ -15,8 +15,11 @@
 package memory_test
 
 import (
+	"strconv"
+	"sync"
 	"testing"
 
+	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/adapter/config/memory"
 	"istio.io/pilot/model"
 	"istio.io/pilot/test/mock"
@@ -24,10 +27,73 @@ import (
 
 func TestStoreInvariant(t *testing.T) {
 	store := memory.Make(mock.Types)
-	mock.CheckMapInvariant(store, t, 10)
+	mock.CheckMapInvariant(store, t, "", 10)
+}
+
+func TestStoreNamespaceInvariant(t *testing.T) {
+	store := memory.Make(mock.Types)
+	mock.CheckMapInvariant(store, t, "default", 10)
+}
+
+func TestNamespaces(t *testing.T) {
+	store := memory.Make(mock.Types)
+	mock.CheckNamespaces(store, t, []string{"default", "istio-system", ""})
 }
 
 func TestIstioConfig(t *testing.T) {
 	store := memory.Make(model.IstioConfigTypes)
-	mock.CheckIstioConfigTypes(store, t)
+	mock.CheckIstioConfigTypes(store, "istio-system", t)
+}
+
+func TestStoreRevisions(t *testing.T) {
+	store := memory.Make(mock.Types)
+
+	// concurrent mutations yield distinct revisions
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := store.Post(model.Config{Content: mock.Make(i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	revs := make(map[string]bool)
+	configs, _ := store.List(model.MockConfig.Type, model.NamespaceAll)
+	for _, config := range configs {
+		revs[config.Revision] = true
+	}
+	if len(revs) != 10 {
+		t.Errorf("got %d distinct revisions, want 10", len(revs))
+	}
+
+	// updates increase the revision
+	config, _ := store.Get(model.MockConfig.Type, mock.Make(0).Key, "")
+	rev, err := store.Put(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := strconv.ParseUint(config.Revision, 10, 64)
+	if next, _ := strconv.ParseUint(rev, 10, 64); next <= old {
+		t.Errorf("Put() => revision %s, want greater than %s", rev, config.Revision)
+	}
+}
+
+func TestStoreAnnotations(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	config := model.Config{
+		Content:     &proxyconfig.DestinationPolicy{Destination: "hello.default.svc.cluster.local"},
+		Annotations: map[string]string{model.MaxRetriesAnnotation: "0"},
+	}
+	if _, err := store.Post(config); err == nil {
+		t.Error("Post => got no error for an invalid annotation")
+	}
+
+	config.Annotations[model.MaxRetriesAnnotation] = "3"
+	if _, err := store.Post(config); err != nil {
+		t.Errorf("Post => unexpected error %v", err)
+	}
 }
@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"time"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+)
+
+// controller is an in-memory config store that dispatches update events to
+// the registered handlers on a worker queue.
+type controller struct {
+	*store
+	queue    kube.Queue
+	handlers map[string]*kube.ChainHandler
+}
+
+// NewController creates an in-memory config store cache from a config
+// descriptor. Mutations through the store interface trigger notifications
+// for the handlers registered for the type of the config object, in the
+// order of the mutations.
+func NewController(descriptor model.ConfigDescriptor) model.ConfigStoreCache {
+	out := &controller{
+		store: newStore(descriptor),
+		// queue requires a time duration for a retry delay after a handler error
+		queue:    kube.NewQueue(1 * time.Second),
+		handlers: make(map[string]*kube.ChainHandler),
+	}
+	for _, typ := range descriptor.Types() {
+		out.handlers[typ] = &kube.ChainHandler{}
+	}
+	out.store.notify = func(config model.Config, event model.Event) {
+		if handler, exists := out.handlers[config.Type]; exists {
+			out.queue.Push(kube.NewTask(handler.Apply, config, event))
+		}
+	}
+	return out
+}
+
+func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
+	handler, exists := c.handlers[typ]
+	if !exists {
+		return
+	}
+	handler.Append(func(obj interface{}, event model.Event) error {
+		f(obj.(model.Config), event)
+		return nil
+	})
+}
+
+// HasSynced is always true since the store holds the authoritative state
+func (c *controller) HasSynced() bool {
+	return true
+}
+
+func (c *controller) Run(stop <-chan struct{}) {
+	go c.queue.Run(stop)
+	<-stop
+}
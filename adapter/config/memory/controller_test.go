@@ -0,0 +1,37 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory_test
+
+import (
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/test/mock"
+)
+
+func TestControllerEvents(t *testing.T) {
+	ctl := memory.NewController(mock.Types)
+	mock.CheckCacheEvents(ctl, ctl, 5, t)
+}
+
+func TestControllerCacheFreshness(t *testing.T) {
+	ctl := memory.NewController(mock.Types)
+	mock.CheckCacheFreshness(ctl, t)
+}
+
+func TestControllerClientSync(t *testing.T) {
+	ctl := memory.NewController(mock.Types)
+	mock.CheckCacheSync(ctl, ctl, 5, t)
+}
@@ -37,24 +37,63 @@ var (
 	meshConfig      string
 	imagePullPolicy string
 	includeIPRanges string
+	reinject        bool
 
 	inFilename  string
 	outFilename string
 )
 
+// withResourceFiles applies the function to the input and output resource
+// files selected by the flags, defaulting to standard output
+func withResourceFiles(f func(io.Reader, io.Writer) error) (err error) {
+	if inFilename == "" {
+		return errors.New("filename not specified (see --filename or -f)")
+	}
+
+	var reader io.Reader
+	if inFilename == "-" {
+		reader = os.Stdin
+	} else {
+		var file *os.File
+		if file, err = os.Open(inFilename); err != nil {
+			return err
+		}
+		reader = file
+		defer func() { _ = file.Close() }()
+	}
+
+	var writer io.Writer
+	if outFilename == "" {
+		writer = os.Stdout
+	} else {
+		var file *os.File
+		if file, err = os.Create(outFilename); err != nil {
+			return err
+		}
+		writer = file
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	return f(reader, writer)
+}
+
 var (
 	injectCmd = &cobra.Command{
 		Use:   "kube-inject",
 		Short: "Inject Envoy sidecar into Kubernetes pod resources",
 		Long: `
 
-Automatic Envoy sidecar injection via k8s admission controller is not
-ready yet. Instead, use kube-inject to manually inject Envoy sidecar
-into Kubernetes resource files. Unsupported resources are left
-unmodified so it is safe to run kube-inject over a single file that
-contains multiple Service, ConfigMap, Deployment, etc. definitions for
-a complex application. Its best to do this when the resource is
-initially created.
+Automatic Envoy sidecar injection via k8s admission webhook applies to
+pods in namespaces labeled with istio-injection=enabled. Otherwise, use
+kube-inject to manually inject Envoy sidecar into Kubernetes resource
+files. Unsupported resources are left unmodified so it is safe to run
+kube-inject over a single file that contains multiple Service,
+ConfigMap, Deployment, etc. definitions for a complex application. Its
+best to do this when the resource is initially created.
 
 k8s.io/docs/concepts/workloads/pods/pod-overview/#pod-templates is
 updated for Job, DaemonSet, ReplicaSet, and Deployment YAML resource
@@ -63,7 +102,8 @@ added as necessary.
 
 The Istio project is continually evolving so the Istio sidecar
 configuration may change unannounced. When in doubt re-run istioctl
-kube-inject on deployments to get the most up-to-date changes.
+kube-inject --reinject on deployments to get the most up-to-date
+changes.
 `,
 		Example: `
 # Update resources on the fly before applying.
@@ -76,33 +116,11 @@ istioctl kube-inject -f deployment.yaml -o deployment-with-istio.yaml
 
 # Update an existing deployment.
 kubectl get deployment -o yaml | istioctl kube-inject -f - | kubectl apply -f -
-`,
-		RunE: func(_ *cobra.Command, _ []string) (err error) {
-			if inFilename == "" {
-				return errors.New("filename not specified (see --filename or -f)")
-			}
-
-			var reader io.Reader
-			if inFilename == "-" {
-				reader = os.Stdin
-			} else {
-				if reader, err = os.Open(inFilename); err != nil {
-					return err
-				}
-			}
-
-			var writer io.Writer
-			if outFilename == "" {
-				writer = os.Stdout
-			} else {
-				var file *os.File
-				if file, err = os.Create(outFilename); err != nil {
-					return err
-				}
-				writer = file
-				defer func() { err = file.Close() }()
-			}
 
+# Upgrade the sidecar in an existing injected deployment.
+kubectl get deployment -o yaml | istioctl kube-inject --reinject -f - | kubectl apply -f -
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
 			if versionStr == "" {
 				versionStr = version.Line()
 			}
@@ -130,13 +148,46 @@ kubectl get deployment -o yaml | istioctl kube-inject -f - | kubectl apply -f -
 				ImagePullPolicy:   imagePullPolicy,
 				IncludeIPRanges:   includeIPRanges,
 			}
-			return inject.IntoResourceFile(params, reader, writer)
+			return withResourceFiles(func(reader io.Reader, writer io.Writer) error {
+				if reinject {
+					return inject.ReinjectResourceFile(params, reader, writer)
+				}
+				return inject.IntoResourceFile(params, reader, writer)
+			})
+		},
+	}
+
+	uninjectCmd = &cobra.Command{
+		Use:   "kube-uninject",
+		Short: "Remove Envoy sidecar from Kubernetes pod resources",
+		Long: `
+
+kube-uninject reverses kube-inject, removing the Envoy sidecar
+containers, volumes, and annotations from the Kubernetes resource
+files, e.g. when a workload leaves the mesh. Resources with an ignored
+sidecar and unsupported resources are left unmodified.
+`,
+		Example: `
+# Update resources on the fly before applying.
+kubectl apply -f <(istioctl kube-uninject -f <resource.yaml>)
+
+# Remove the sidecar from an existing deployment.
+kubectl get deployment -o yaml | istioctl kube-uninject -f - | kubectl apply -f -
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return withResourceFiles(inject.FromResourceFile)
 		},
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(injectCmd)
+	rootCmd.AddCommand(uninjectCmd)
+
+	uninjectCmd.PersistentFlags().StringVarP(&inFilename, "filename", "f",
+		"", "Input Kubernetes resource filename")
+	uninjectCmd.PersistentFlags().StringVarP(&outFilename, "output", "o",
+		"", "Modified output Kubernetes resource filename")
 
 	injectCmd.PersistentFlags().StringVar(&hub, "hub", "docker.io/istio", "Docker hub")
 	injectCmd.PersistentFlags().StringVar(&tag, "tag", version.Info.Version, "Docker tag")
@@ -167,4 +218,6 @@ func init() {
 	injectCmd.PersistentFlags().StringVar(&includeIPRanges, "includeIPRanges", "",
 		"Comma separated list of IP ranges in CIDR form. If set, only redirect outbound "+
 			"traffic to Envoy for IP ranges. Otherwise all outbound traffic is redirected")
+	injectCmd.PersistentFlags().BoolVar(&reinject, "reinject", false,
+		"Replace the Envoy sidecar in already injected resources with the sidecar for the current settings")
 }
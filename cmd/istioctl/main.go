@@ -22,6 +22,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/ghodss/yaml"
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
@@ -39,6 +40,9 @@ var (
 	kubeconfig  string
 	istioSystem string
 
+	// select config objects across all namespaces
+	allNamespaces bool
+
 	configClient model.ConfigStore
 
 	// input file name
@@ -102,7 +106,7 @@ istioctl mixer command documentation.
 					return err
 				}
 				schema, _ := configClient.ConfigDescriptor().GetByType(config.Type)
-				rev, err := configClient.Post(spec)
+				rev, err := configClient.Post(config.toModel(spec))
 				if err != nil {
 					return err
 				}
@@ -139,11 +143,12 @@ istioctl mixer command documentation.
 				schema, _ := configClient.ConfigDescriptor().GetByType(config.Type)
 				// fill up revision
 				if config.Revision == "" {
-					_, _, rev := configClient.Get(config.Type, schema.Key(spec))
-					config.Revision = rev
+					if old, exists := configClient.Get(config.Type, schema.Key(spec), config.Namespace); exists {
+						config.Revision = old.Revision
+					}
 				}
 
-				newRev, err := configClient.Put(spec, config.Revision)
+				newRev, err := configClient.Put(config.toModel(spec))
 				if err != nil {
 					return err
 				}
@@ -165,6 +170,9 @@ istioctl mixer command documentation.
 		# List all destination policies
 		istioctl get destination-policies
 
+		# List route rules in all namespaces
+		istioctl get route-rules --all-namespaces
+
 		# Get a specific rule named productpage-default
 		istioctl get route-rule productpage-default
 		`,
@@ -183,17 +191,19 @@ istioctl mixer command documentation.
 
 			var configs []model.Config
 			if len(args) > 1 {
-				config, exists, rev := configClient.Get(typ.Type, args[1])
+				if allNamespaces {
+					return errors.New("a resource cannot be retrieved by name across all namespaces")
+				}
+				config, exists := configClient.Get(typ.Type, args[1], istioSystem)
 				if exists {
-					configs = append(configs, model.Config{
-						Type:     typ.Type,
-						Key:      typ.Key(config),
-						Revision: rev,
-						Content:  config,
-					})
+					configs = append(configs, *config)
 				}
 			} else {
-				configs, err = configClient.List(typ.Type)
+				namespace := istioSystem
+				if allNamespaces {
+					namespace = model.NamespaceAll
+				}
+				configs, err = configClient.List(typ.Type, namespace)
 				if err != nil {
 					return err
 				}
@@ -243,7 +253,7 @@ istioctl mixer command documentation.
 				}
 				for i := 1; i < len(args); i++ {
 					key := args[i]
-					if err := configClient.Delete(typ.Type, key); err != nil {
+					if err := configClient.Delete(typ.Type, key, istioSystem); err != nil {
 						errs = multierror.Append(errs,
 							fmt.Errorf("cannot delete %s: %v", args[i], err))
 					} else {
@@ -274,7 +284,7 @@ istioctl mixer command documentation.
 						config.Key = schema.Key(spec)
 					}
 				}
-				if err = configClient.Delete(config.Type, config.Key); err != nil {
+				if err = configClient.Delete(config.Type, config.Key, config.Namespace); err != nil {
 					errs = multierror.Append(errs, fmt.Errorf("cannot delete %s: %v", config.Key, err))
 				} else {
 					fmt.Printf("Deleted config: %v %v\n", config.Type, config.Key)
@@ -302,7 +312,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "c", defaultKubeconfig,
 		"Kubernetes configuration file")
 	rootCmd.PersistentFlags().StringVarP(&istioSystem, "namespace", "n", v1.NamespaceDefault,
-		"Kubernetes Istio system namespace")
+		"Kubernetes namespace of the configuration objects, unless set in the input file")
 
 	postCmd.PersistentFlags().StringVarP(&file, "file", "f", "",
 		"Input file with the content of the configuration objects (if not set, command reads from the standard input)")
@@ -311,6 +321,8 @@ func init() {
 
 	getCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "short",
 		"Output format. One of:yaml|short")
+	getCmd.PersistentFlags().BoolVar(&allNamespaces, "all-namespaces", false,
+		"List the configuration objects across all namespaces")
 
 	cmd.AddFlags(rootCmd)
 
@@ -352,12 +364,35 @@ type Config struct {
 	Type string `json:"type,omitempty"`
 	// Key is the unique key per type
 	Key string `json:"key,omitempty"`
+	// Namespace is optional and overrides the namespace flag
+	Namespace string `json:"namespace,omitempty"`
+	// Labels is optional metadata
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations is optional metadata
+	Annotations map[string]string `json:"annotations,omitempty"`
 	// Revision is optional for updating configs
 	Revision string `json:"revision,omitempty"`
 	// Spec is the content of the config
 	Spec interface{} `json:"spec,omitempty"`
 }
 
+// toModel converts the config with a parsed spec to a config store object
+func (c *Config) toModel(spec proto.Message) model.Config {
+	namespace := c.Namespace
+	if namespace == "" {
+		namespace = istioSystem
+	}
+	return model.Config{
+		Type:        c.Type,
+		Key:         c.Key,
+		Namespace:   namespace,
+		Labels:      c.Labels,
+		Annotations: c.Annotations,
+		Revision:    c.Revision,
+		Content:     spec,
+	}
+}
+
 // ParseSpec takes the field in the config object and parses into a protobuf message
 // Then assigns it to the ParseSpec field
 func (c *Config) ParseSpec() (proto.Message, error) {
@@ -373,7 +408,7 @@ func (c *Config) ParseSpec() (proto.Message, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse proto message: %v", err)
 	}
-	if err = schema.Validate(message); err != nil {
+	if err = schema.ValidateConfig(c.toModel(message)); err != nil {
 		return nil, err
 	}
 	return message, nil
@@ -418,7 +453,11 @@ func readInputs() ([]Config, error) {
 // Print a simple list of names
 func printShortOutput(configList []model.Config) {
 	for _, c := range configList {
-		fmt.Printf("%v\n", c.Key)
+		if allNamespaces {
+			fmt.Printf("%v\n", c.QualifiedKey())
+		} else {
+			fmt.Printf("%v\n", c.Key)
+		}
 	}
 }
 
@@ -432,6 +471,9 @@ func printYamlOutput(configList []model.Config) {
 		}
 		fmt.Printf("type: %s\n", c.Type)
 		fmt.Printf("key: %s\n", c.Key)
+		fmt.Printf("namespace: %s\n", c.Namespace)
+		printYamlMap("labels", c.Labels)
+		printYamlMap("annotations", c.Annotations)
 		fmt.Printf("revision: %s\n", c.Revision)
 		fmt.Println("spec:")
 		lines := strings.Split(out, "\n")
@@ -443,3 +485,16 @@ func printYamlOutput(configList []model.Config) {
 		fmt.Println("---")
 	}
 }
+
+// Print a string map as a YAML field if it is not empty
+func printYamlMap(name string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	out, err := yaml.Marshal(map[string]map[string]string{name: values})
+	if err != nil {
+		glog.Warning(err)
+		return
+	}
+	fmt.Print(string(out))
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -23,30 +24,94 @@ import (
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
-	"istio.io/pilot/adapter/config/aggregate"
+	configaggregate "istio.io/pilot/adapter/config/aggregate"
+	consulconfig "istio.io/pilot/adapter/config/consul"
 	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/file"
 	"istio.io/pilot/adapter/config/ingress"
 	"istio.io/pilot/cmd"
 	"istio.io/pilot/model"
+	"istio.io/pilot/platform"
+	"istio.io/pilot/platform/aggregate"
+	"istio.io/pilot/platform/consul"
+	"istio.io/pilot/platform/eureka"
 	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/platform/kube/inject"
 	"istio.io/pilot/proxy"
 	"istio.io/pilot/proxy/envoy"
 	"istio.io/pilot/tools/version"
 )
 
+// allNamespaces is the flag value to watch config resources in all namespaces
+const allNamespaces = "*"
+
+// consulDefaultNamespace is the default namespace of the config objects in
+// the Consul KV store if the controller namespace is not set
+const consulDefaultNamespace = "default"
+
 type args struct {
 	kubeconfig string
 	meshconfig string
 
+	// service registries, in the order of precedence for colliding hostnames
+	registries []string
+
+	// namespaces to watch for config resources
+	configNamespaces []string
+
+	// directory of config files replacing config resources
+	configDir string
+
 	// ingress sync mode is set to off by default
 	controllerOptions kube.ControllerOptions
 	discoveryOptions  envoy.DiscoveryServiceOptions
+
+	consul consulArgs
+	eureka eurekaArgs
+}
+
+type consulArgs struct {
+	serverURL  string
+	datacenter string
+	interval   time.Duration
+
+	// KV prefix of config objects replacing config resources
+	configPrefix string
+}
+
+type admissionArgs struct {
+	port     int
+	certFile string
+	keyFile  string
+}
+
+type injectorArgs struct {
+	admissionArgs
+
+	kubeconfig      string
+	namespace       string
+	meshConfig      string
+	hub             string
+	tag             string
+	verbosity       int
+	sidecarProxyUID int64
+	enableCoreDump  bool
+	imagePullPolicy string
+	includeIPRanges string
+}
+
+type eurekaArgs struct {
+	serverURLs []string
+	interval   time.Duration
 }
 
 var (
-	flags args
+	flags          args
+	admissionFlags admissionArgs
+	injectorFlags  injectorArgs
 
 	rootCmd = &cobra.Command{
 		Use:   "pilot",
@@ -58,11 +123,6 @@ var (
 		Use:   "discovery",
 		Short: "Start Istio proxy discovery service",
 		RunE: func(c *cobra.Command, args []string) error {
-			client, err := kube.CreateInterface(flags.kubeconfig)
-			if err != nil {
-				return multierror.Prefix(err, "failed to connect to Kubernetes API.")
-			}
-
 			if flags.controllerOptions.Namespace == "" {
 				flags.controllerOptions.Namespace = os.Getenv("POD_NAMESPACE")
 			}
@@ -78,37 +138,32 @@ var (
 
 			glog.V(2).Infof("mesh configuration %s", spew.Sdump(mesh))
 
-			configClient, err := crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
-				model.RouteRule,
-				model.DestinationPolicy,
-			}, flags.controllerOptions.Namespace)
-			if err != nil {
-				return multierror.Prefix(err, "failed to open a config client.")
+			// the Kubernetes API is required only by the Kubernetes registry and
+			// the ingress controller
+			var client kubernetes.Interface
+			var secrets model.SecretRegistry = noSecretRegistry{}
+			if needsKubeClient(mesh) {
+				if client, err = kube.CreateInterface(flags.kubeconfig); err != nil {
+					return multierror.Prefix(err, "failed to connect to Kubernetes API.")
+				}
+				secrets = kube.MakeSecretRegistry(client)
 			}
 
-			if err = configClient.RegisterResources(); err != nil {
-				return multierror.Prefix(err, "failed to register custom resources.")
+			serviceController, err := buildServiceController(client, mesh)
+			if err != nil {
+				return err
 			}
 
-			serviceController := kube.NewController(client, mesh, flags.controllerOptions)
-			var configController model.ConfigStoreCache
-			if mesh.IngressControllerMode == proxyconfig.ProxyMeshConfig_OFF {
-				configController = crd.NewController(configClient, flags.controllerOptions.ResyncPeriod)
-			} else {
-				configController, err = aggregate.MakeCache([]model.ConfigStoreCache{
-					crd.NewController(configClient, flags.controllerOptions.ResyncPeriod),
-					ingress.NewController(client, mesh, flags.controllerOptions),
-				})
-				if err != nil {
-					return err
-				}
+			configController, err := buildConfigController(client, mesh)
+			if err != nil {
+				return err
 			}
 
 			environment := proxy.Environment{
 				ServiceDiscovery: serviceController,
 				ServiceAccounts:  serviceController,
 				IstioConfigStore: model.MakeIstioStore(configController),
-				SecretRegistry:   kube.MakeSecretRegistry(client),
+				SecretRegistry:   secrets,
 				Mesh:             mesh,
 			}
 			discovery, err := envoy.NewDiscoveryService(serviceController, configController, environment, flags.discoveryOptions)
@@ -116,13 +171,78 @@ var (
 				return fmt.Errorf("failed to create discovery service: %v", err)
 			}
 
-			ingressSyncer := ingress.NewStatusSyncer(mesh, client, flags.controllerOptions)
-
 			stop := make(chan struct{})
 			go serviceController.Run(stop)
 			go configController.Run(stop)
 			go discovery.Run()
-			go ingressSyncer.Run(stop)
+			if client != nil {
+				ingressSyncer := ingress.NewStatusSyncer(mesh, client, flags.controllerOptions)
+				go ingressSyncer.Run(stop)
+			}
+			cmd.WaitSignal(stop)
+
+			return nil
+		},
+	}
+
+	admissionCmd = &cobra.Command{
+		Use:   "admission",
+		Short: "Start Istio config validation admission webhook",
+		Long: "Validates Istio config custom resources on create and update in the Kubernetes API server " +
+			"admission chain, rejecting invalid config before it reaches the proxies.",
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("version %s", version.Line())
+
+			admission, err := crd.NewAdmission(model.IstioConfigTypes)
+			if err != nil {
+				return err
+			}
+
+			stop := make(chan struct{})
+			go admission.Run(admissionFlags.port, admissionFlags.certFile, admissionFlags.keyFile, stop)
+			cmd.WaitSignal(stop)
+
+			return nil
+		},
+	}
+
+	injectorCmd = &cobra.Command{
+		Use:   "sidecar-injector",
+		Short: "Start Istio sidecar injection admission webhook",
+		Long: "Injects the Envoy sidecar into pods created in namespaces labeled with " +
+			fmt.Sprintf("%s=%s, ", inject.NamespaceInjectionLabel, inject.NamespaceInjectionEnabled) +
+			"unless the pod template is annotated to ignore the sidecar.",
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("version %s", version.Line())
+
+			client, err := kube.CreateInterface(injectorFlags.kubeconfig)
+			if err != nil {
+				return multierror.Prefix(err, "failed to connect to Kubernetes API.")
+			}
+
+			if injectorFlags.namespace == "" {
+				injectorFlags.namespace = os.Getenv("POD_NAMESPACE")
+			}
+			mesh, err := inject.GetMeshConfig(client, injectorFlags.namespace, injectorFlags.meshConfig)
+			if err != nil {
+				return multierror.Prefix(err, "failed to read mesh configuration.")
+			}
+
+			webhook := inject.NewWebhook(&inject.Params{
+				InitImage:         inject.InitImageName(injectorFlags.hub, injectorFlags.tag),
+				ProxyImage:        inject.ProxyImageName(injectorFlags.hub, injectorFlags.tag),
+				Verbosity:         injectorFlags.verbosity,
+				SidecarProxyUID:   injectorFlags.sidecarProxyUID,
+				Version:           version.Line(),
+				EnableCoreDump:    injectorFlags.enableCoreDump,
+				Mesh:              mesh,
+				MeshConfigMapName: injectorFlags.meshConfig,
+				ImagePullPolicy:   injectorFlags.imagePullPolicy,
+				IncludeIPRanges:   injectorFlags.includeIPRanges,
+			}, client)
+
+			stop := make(chan struct{})
+			go webhook.Run(injectorFlags.port, injectorFlags.certFile, injectorFlags.keyFile, stop)
 			cmd.WaitSignal(stop)
 
 			return nil
@@ -130,6 +250,139 @@ var (
 	}
 )
 
+// noSecretRegistry rejects the secret lookups of ingress proxies when the
+// Kubernetes API is not used
+type noSecretRegistry struct{}
+
+func (noSecretRegistry) GetTLSSecret(uri string) (*model.TLSSecret, error) {
+	return nil, fmt.Errorf("cannot read secret %q without the Kubernetes API", uri)
+}
+
+// needsKubeClient checks whether the service registries or the config sources
+// selected by the flags and the mesh configuration use the Kubernetes API
+func needsKubeClient(mesh *proxyconfig.ProxyMeshConfig) bool {
+	for _, r := range flags.registries {
+		if platform.ServiceRegistry(r) == platform.KubernetesRegistry {
+			return true
+		}
+	}
+	return mesh.IngressControllerMode != proxyconfig.ProxyMeshConfig_OFF
+}
+
+// buildConfigController creates the config store cache selected by the flags
+func buildConfigController(client kubernetes.Interface,
+	mesh *proxyconfig.ProxyMeshConfig) (model.ConfigStoreCache, error) {
+	var configController model.ConfigStoreCache
+	switch {
+	case flags.configDir != "":
+		glog.V(2).Infof("Reading config files from %s", flags.configDir)
+		// ingress rules are provided by the ingress controller below unless it is off
+		descriptor := model.IstioConfigTypes
+		if mesh.IngressControllerMode != proxyconfig.ProxyMeshConfig_OFF {
+			descriptor = model.ConfigDescriptor{model.RouteRule, model.DestinationPolicy}
+		}
+		configController = file.NewController(flags.configDir, descriptor)
+	case flags.consul.configPrefix != "":
+		glog.V(2).Infof("Reading config from Consul KV prefix %s", flags.consul.configPrefix)
+		namespace := flags.controllerOptions.Namespace
+		if namespace == "" {
+			namespace = consulDefaultNamespace
+		}
+		// ingress rules are provided by the ingress controller below
+		configClient, err := consulconfig.NewClient(flags.consul.serverURL, flags.consul.configPrefix,
+			model.ConfigDescriptor{model.RouteRule, model.DestinationPolicy}, namespace)
+		if err != nil {
+			return nil, multierror.Prefix(err, "failed to open a Consul config client.")
+		}
+		configController = consulconfig.NewController(configClient, flags.consul.interval)
+	default:
+		configClient, err := crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
+			model.RouteRule,
+			model.DestinationPolicy,
+		}, flags.controllerOptions.Namespace)
+		if err != nil {
+			return nil, multierror.Prefix(err, "failed to open a config client.")
+		}
+
+		if err = configClient.RegisterResources(); err != nil {
+			return nil, multierror.Prefix(err, "failed to register custom resources.")
+		}
+
+		configNamespaces := make([]string, 0, len(flags.configNamespaces))
+		for _, namespace := range flags.configNamespaces {
+			if namespace == allNamespaces {
+				namespace = model.NamespaceAll
+			}
+			configNamespaces = append(configNamespaces, namespace)
+		}
+
+		configController = crd.NewController(configClient, flags.controllerOptions.ResyncPeriod, configNamespaces...)
+	}
+
+	if mesh.IngressControllerMode == proxyconfig.ProxyMeshConfig_OFF {
+		return configController, nil
+	}
+
+	return configaggregate.MakeCache([]model.ConfigStoreCache{
+		configController,
+		ingress.NewController(client, mesh, flags.controllerOptions),
+	})
+}
+
+// buildServiceController aggregates the service registries selected by the flags
+func buildServiceController(client kubernetes.Interface,
+	mesh *proxyconfig.ProxyMeshConfig) (*aggregate.Controller, error) {
+	serviceControllers := aggregate.NewController()
+	registered := make(map[platform.ServiceRegistry]bool)
+	for _, r := range flags.registries {
+		serviceRegistry := platform.ServiceRegistry(r)
+		if registered[serviceRegistry] {
+			return nil, fmt.Errorf("%s registry specified multiple times", r)
+		}
+		registered[serviceRegistry] = true
+		glog.V(2).Infof("Adding %s registry adapter", serviceRegistry)
+
+		switch serviceRegistry {
+		case platform.KubernetesRegistry:
+			kubectl := kube.NewController(client, mesh, flags.controllerOptions)
+			serviceControllers.AddRegistry(aggregate.Registry{
+				Name:             serviceRegistry,
+				ServiceDiscovery: kubectl,
+				ServiceAccounts:  kubectl,
+				Controller:       kubectl,
+			})
+		case platform.ConsulRegistry:
+			glog.V(2).Infof("Consul url: %v", flags.consul.serverURL)
+			conctl, conerr := consul.NewController(
+				flags.consul.serverURL, flags.consul.datacenter, flags.consul.interval)
+			if conerr != nil {
+				return nil, fmt.Errorf("failed to create Consul controller: %v", conerr)
+			}
+			serviceControllers.AddRegistry(aggregate.Registry{
+				Name:             serviceRegistry,
+				ServiceDiscovery: conctl,
+				Controller:       conctl,
+			})
+		case platform.EurekaRegistry:
+			glog.V(2).Infof("Eureka urls: %v", flags.eureka.serverURLs)
+			eurekaClient := eureka.NewClient(flags.eureka.serverURLs...)
+			serviceControllers.AddRegistry(aggregate.Registry{
+				Name:             serviceRegistry,
+				ServiceDiscovery: eureka.NewServiceDiscovery(eurekaClient),
+				Controller:       eureka.NewController(eurekaClient, flags.eureka.interval),
+			})
+		default:
+			return nil, fmt.Errorf("service registry %s is not supported", r)
+		}
+	}
+
+	if len(registered) == 0 {
+		return nil, errors.New("no service registry specified")
+	}
+
+	return serviceControllers, nil
+}
+
 func init() {
 	discoveryCmd.PersistentFlags().StringVar(&flags.kubeconfig, "kubeconfig", "",
 		"Use a Kubernetes configuration file instead of in-cluster configuration")
@@ -137,21 +390,93 @@ func init() {
 		fmt.Sprintf("File name for Istio mesh configuration"))
 	discoveryCmd.PersistentFlags().StringVarP(&flags.controllerOptions.Namespace, "namespace", "n", "",
 		"Select a namespace for the controller loop. If not set, uses ${POD_NAMESPACE} environment variable")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.configNamespaces, "configNamespaces", nil,
+		fmt.Sprintf("Comma separated list of namespaces to watch for config resources (%q for all namespaces). "+
+			"If not set, uses the namespace of the controller loop", allNamespaces))
+	discoveryCmd.PersistentFlags().StringVar(&flags.configDir, "configDir", "",
+		"Directory to watch for YAML or JSON config files. If set, config files replace the Kubernetes "+
+			"custom resources as the source of config, and provide ingress rules if the ingress controller is off")
 	discoveryCmd.PersistentFlags().DurationVar(&flags.controllerOptions.ResyncPeriod, "resync", time.Second,
 		"Controller resync interval")
 	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.DomainSuffix, "domain", "cluster.local",
 		"DNS domain suffix")
 
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.registries, "registries",
+		[]string{string(platform.KubernetesRegistry)},
+		fmt.Sprintf("Comma separated list of platform service registries to read from, in the order of "+
+			"precedence for colliding service hostnames (choose one or more from {%s, %s, %s})",
+			platform.KubernetesRegistry, platform.ConsulRegistry, platform.EurekaRegistry))
+
+	discoveryCmd.PersistentFlags().StringVar(&flags.consul.serverURL, "consulserverURL", "",
+		"URL for the Consul server")
+	discoveryCmd.PersistentFlags().StringVar(&flags.consul.datacenter, "consulDatacenter", "",
+		"Consul datacenter to read services from (if not set, uses the agent datacenter)")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.consul.interval, "consulserverInterval", 2*time.Second,
+		"Interval (in seconds) for polling the Consul service registry")
+	discoveryCmd.PersistentFlags().StringVar(&flags.consul.configPrefix, "consulConfigPrefix", "",
+		"Consul KV prefix to watch for config objects. If set, the Consul KV store replaces the Kubernetes "+
+			"custom resources as the source of route rules and destination policies. Objects without a "+
+			"namespace use the controller namespace, or \"default\" if it is not set")
+
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.eureka.serverURLs, "eurekaserverURL", nil,
+		"Comma separated list of URLs for the Eureka servers, in the order of failover")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.eureka.interval, "eurekaserverInterval", 2*time.Second,
+		"Interval (in seconds) for polling the Eureka service registry")
+
 	discoveryCmd.PersistentFlags().IntVar(&flags.discoveryOptions.Port, "port", 8080,
 		"Discovery service port")
 	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.EnableProfiling, "profile", true,
 		"Enable profiling via web interface host:port/debug/pprof")
 	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.EnableCaching, "discovery_cache", true,
 		"Enable caching discovery service responses")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.discoveryOptions.DebounceAfter, "discovery_debounce_after",
+		100*time.Millisecond, "Quiet period after a registry or config event before evicting cached responses")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.discoveryOptions.DebounceMax, "discovery_debounce_max",
+		time.Second, "Maximum delay of evicting cached responses after the first event of a burst")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.IncludeUnhealthyHosts, "sds_include_unhealthy",
+		false, "Include the instances reported unhealthy by the service registries in the SDS responses "+
+			"with the minimal load balancing weight")
+
+	admissionCmd.PersistentFlags().IntVar(&admissionFlags.port, "port", 443,
+		"Admission webhook HTTPS port")
+	admissionCmd.PersistentFlags().StringVar(&admissionFlags.certFile, "tlsCertFile", "/etc/istio/certs/cert-chain.pem",
+		"File containing the x509 certificate for HTTPS")
+	admissionCmd.PersistentFlags().StringVar(&admissionFlags.keyFile, "tlsKeyFile", "/etc/istio/certs/key.pem",
+		"File containing the x509 private key matching the certificate")
+
+	injectorCmd.PersistentFlags().IntVar(&injectorFlags.port, "port", 443,
+		"Injection webhook HTTPS port")
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.certFile, "tlsCertFile", "/etc/istio/certs/cert-chain.pem",
+		"File containing the x509 certificate for HTTPS")
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.keyFile, "tlsKeyFile", "/etc/istio/certs/key.pem",
+		"File containing the x509 private key matching the certificate")
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.kubeconfig, "kubeconfig", "",
+		"Use a Kubernetes configuration file instead of in-cluster configuration")
+	injectorCmd.PersistentFlags().StringVarP(&injectorFlags.namespace, "namespace", "n", "",
+		"Namespace of the mesh configuration map. If not set, uses ${POD_NAMESPACE} environment variable")
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.meshConfig, "meshConfig", "istio",
+		fmt.Sprintf("ConfigMap name for Istio mesh configuration, key should be %q", inject.ConfigMapKey))
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.hub, "hub", "docker.io/istio", "Docker hub")
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.tag, "tag", version.Info.Version, "Docker tag")
+	injectorCmd.PersistentFlags().IntVar(&injectorFlags.verbosity, "verbosity",
+		inject.DefaultVerbosity, "Runtime verbosity")
+	injectorCmd.PersistentFlags().Int64Var(&injectorFlags.sidecarProxyUID, "sidecarProxyUID",
+		inject.DefaultSidecarProxyUID, "Envoy sidecar UID")
+	injectorCmd.PersistentFlags().BoolVar(&injectorFlags.enableCoreDump, "coreDump", false,
+		"Enable/Disable core dumps in injected Envoy sidecar (--coreDump=true affects "+
+			"all pods in a node and should only be used the cluster admin)")
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.imagePullPolicy, "imagePullPolicy", "IfNotPresent",
+		"Sets the container image pull policy. Valid options are Always,IfNotPresent,Never."+
+			"The default policy is IfNotPresent.")
+	injectorCmd.PersistentFlags().StringVar(&injectorFlags.includeIPRanges, "includeIPRanges", "",
+		"Comma separated list of IP ranges in CIDR form. If set, only redirect outbound "+
+			"traffic to Envoy for IP ranges. Otherwise all outbound traffic is redirected")
 
 	cmd.AddFlags(rootCmd)
 
 	rootCmd.AddCommand(discoveryCmd)
+	rootCmd.AddCommand(admissionCmd)
+	rootCmd.AddCommand(injectorCmd)
 	rootCmd.AddCommand(cmd.VersionCmd)
 }
 
@@ -12,12 +12,16 @@ usage() {
   echo '  -u: Specify the UID of the user for which the redirection is not'
   echo '      applied. Typically, this is the UID of the proxy container'
   echo '  -i: Comma separated list of IP ranges in CIDR form to redirect to envoy (optional)'
+  echo '  -x: Comma separated list of IP ranges in CIDR form to exclude from redirection (optional)'
+  echo '  -d: Comma separated list of inbound ports to exclude from redirection (optional)'
   echo ''
 }
 
 IP_RANGES_INCLUDE=""
+IP_RANGES_EXCLUDE=""
+INBOUND_PORTS_EXCLUDE=""
 
-while getopts ":p:u:e:i:h" opt; do
+while getopts ":p:u:e:i:x:d:h" opt; do
   case ${opt} in
     p)
       ENVOY_PORT=${OPTARG}
@@ -28,6 +32,12 @@ while getopts ":p:u:e:i:h" opt; do
     i)
       IP_RANGES_INCLUDE=${OPTARG}
       ;;
+    x)
+      IP_RANGES_EXCLUDE=${OPTARG}
+      ;;
+    d)
+      INBOUND_PORTS_EXCLUDE=${OPTARG}
+      ;;
     h)
       usage
       exit 0
@@ -51,6 +61,13 @@ fi
 iptables -t nat -N ISTIO_REDIRECT                                             -m comment --comment "istio/redirect-common-chain"
 iptables -t nat -A ISTIO_REDIRECT -p tcp -j REDIRECT --to-port ${ENVOY_PORT}  -m comment --comment "istio/redirect-to-envoy-port"
 
+IFS=,
+
+# Skip redirection of inbound traffic to the excluded ports.
+for port in ${INBOUND_PORTS_EXCLUDE}; do
+    iptables -t nat -A PREROUTING -p tcp --dport ${port} -j RETURN            -m comment --comment "istio/bypass-inbound-port-${port}"
+done
+
 # Redirect all inbound traffic to Envoy.
 iptables -t nat -A PREROUTING -j ISTIO_REDIRECT                               -m comment --comment "istio/install-istio-prerouting"
 
@@ -76,10 +93,14 @@ iptables -t nat -A ISTIO_OUTPUT -m owner --uid-owner ${ENVOY_UID} -j RETURN   -m
 # localhost.
 iptables -t nat -A ISTIO_OUTPUT -d 127.0.0.1/32 -j RETURN                     -m comment --comment "istio/bypass-explicit-loopback"
 
+# Skip redirection of outbound traffic to the excluded destinations.
+for cidr in ${IP_RANGES_EXCLUDE}; do
+    iptables -t nat -A ISTIO_OUTPUT -d ${cidr} -j RETURN                      -m comment --comment "istio/bypass-ip-range-${cidr}"
+done
+
 # All outbound traffic will be redirected to Envoy by default. If
 # IP_RANGES_INCLUDE is non-empty, only traffic bound for the
 # destinations specified in this list will be captured.
-IFS=,
 if [ "${IP_RANGES_INCLUDE}" != "" ]; then
     for cidr in ${IP_RANGES_INCLUDE}; do
         iptables -t nat -A ISTIO_OUTPUT -d ${cidr} -j ISTIO_REDIRECT          -m comment --comment "istio/redirect-ip-range-${cidr}"
@@ -17,6 +17,7 @@ package model
 import (
 	"errors"
 	"sort"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
@@ -26,17 +27,34 @@ import (
 )
 
 // Config is a configuration unit consisting of the type of configuration, the
-// key identifier that is unique per type, and the content represented as a
-// protobuf message.  The revision is optional, and if provided, identifies the
-// last update operation on the object.
+// key identifier that is unique per type and namespace, the object metadata,
+// and the content represented as a protobuf message.  The revision is
+// optional, and if provided, identifies the last update operation on the
+// object.
 type Config struct {
 	// Type is a short configuration name that matches the content message type
 	Type string
 
-	// Key is the type-dependent unique identifier for this config object, derived
-	// from its content
+	// Key is the type-dependent unique identifier for this config object within
+	// a namespace, derived from its content
 	Key string
 
+	// Name is the platform-specific name of the object, e.g. the Kubernetes
+	// resource name. The store assigns the name from the key if it is empty.
+	Name string
+
+	// Namespace scopes the key of the config object. Objects with the same
+	// key may co-exist in distinct namespaces.
+	Namespace string
+
+	// Labels is a map of string keys and values that can be used to organize
+	// and categorize configuration objects
+	Labels map[string]string
+
+	// Annotations is a map of string keys and values that may be set by
+	// external tools to store and retrieve arbitrary metadata
+	Annotations map[string]string
+
 	// Revision is an opaque identifier for tracking updates to the config registry.
 	// The implementation may use a change index or a commit log for the revision.
 	// The config client should not make any assumptions about revisions and rely only on
@@ -53,6 +71,15 @@ type Config struct {
 	Content proto.Message
 }
 
+// QualifiedKey returns the key of the object prefixed by its namespace, so
+// that it is unique per type across namespaces
+func (config Config) QualifiedKey() string {
+	if config.Namespace == "" {
+		return config.Key
+	}
+	return config.Namespace + "/" + config.Key
+}
+
 // ConfigStore describes a set of platform agnostic APIs that must be supported
 // by the underlying platform to store and retrieve Istio configuration.
 //
@@ -67,6 +94,12 @@ type Config struct {
 // configuration key may be defined as _namespace/name_.  Key definition is
 // provided as part of the config type definition.
 //
+// Configuration objects are scoped by namespaces, and keys are unique per
+// type within a namespace. An empty namespace argument to _GET_ and _DELETE_
+// (or an empty namespace in the object metadata for _POST_ and _PUT_) refers
+// to the default namespace of the store. An empty namespace argument to _LIST_
+// selects objects across all namespaces.
+//
 // _PUT_, _POST_, and _DELETE_ are mutator operations. These operations are
 // asynchronous, and you might not see the effect immediately (e.g. _GET_ might
 // not return the object by key immediately after you mutate the store.)
@@ -89,24 +122,28 @@ type ConfigStore interface {
 	// types and the protobuf encoding schema.
 	ConfigDescriptor() ConfigDescriptor
 
-	// Get retrieves a configuration element by a type and a key
-	Get(typ, key string) (config proto.Message, exists bool, revision string)
+	// Get retrieves a configuration element by a type, a key, and a namespace
+	Get(typ, key, namespace string) (config *Config, exists bool)
 
-	// List returns objects by type
-	List(typ string) ([]Config, error)
+	// List returns objects by type in a namespace. Use NamespaceAll to list
+	// objects across all namespaces.
+	List(typ, namespace string) ([]Config, error)
 
-	// Post creates a configuration object. If an object with the same key for
-	// the type already exists, the operation fails with no side effects.
-	Post(config proto.Message) (revision string, err error)
+	// Post creates a configuration object. The type is derived from the
+	// content message and the key is derived from the content. If an object
+	// with the same key for the type already exists in the namespace, the
+	// operation fails with no side effects.
+	Post(config Config) (revision string, err error)
 
 	// Put updates a configuration object in the store.  Put requires that the
-	// object has been created.  Revision prevents overriding a value that has
-	// been changed between prior _Get_ and _Put_ operation to achieve optimistic
-	// concurrency. This method returns a new revision if the operation succeeds.
-	Put(config proto.Message, oldRevision string) (newRevision string, err error)
-
-	// Delete removes an object from the store by key
-	Delete(typ, key string) error
+	// object has been created.  The revision of the config object prevents
+	// overriding a value that has been changed between prior _Get_ and _Put_
+	// operation to achieve optimistic concurrency. This method returns a new
+	// revision if the operation succeeds.
+	Put(config Config) (newRevision string, err error)
+
+	// Delete removes an object from the store by a key and a namespace
+	Delete(typ, key, namespace string) error
 }
 
 // ConfigStoreCache is a local fully-replicated cache of the config store.  The
@@ -155,6 +192,10 @@ type ProtoSchema struct {
 	// instance of the expected message type
 	Validate func(config proto.Message) error
 
+	// ValidateAnnotations checks the extensions that configuration objects of
+	// the type carry in annotations; it is optional for types without extensions
+	ValidateAnnotations func(annotations map[string]string) error
+
 	// Key function derives the unique key from the configuration object metadata
 	// properties. The object is not required to be complete or even valid to
 	// derive its key, but must be an instance of the expected message type. For
@@ -195,10 +236,10 @@ func (descriptor ConfigDescriptor) GetByType(name string) (ProtoSchema, bool) {
 // IstioConfigStore is a specialized interface to access config store using
 // Istio configuration types
 type IstioConfigStore interface {
-	// RouteRules lists all routing rules
+	// RouteRules lists all routing rules across namespaces by qualified keys
 	RouteRules() map[string]*proxyconfig.RouteRule
 
-	// IngressRules lists all ingress rules
+	// IngressRules lists all ingress rules across namespaces by qualified keys
 	IngressRules() map[string]*proxyconfig.IngressRule
 
 	// DestinationPolicies lists all destination rules
@@ -208,13 +249,217 @@ type IstioConfigStore interface {
 	// A rule must match at least one of the input service instances since the proxy
 	// does not distinguish between source instances in the request.
 	// The rules are sorted by precedence (high first) in a stable manner.
-	RouteRulesBySource(instances []*ServiceInstance) []*proxyconfig.RouteRule
+	// The rules are returned as config objects to preserve their annotations.
+	RouteRulesBySource(instances []*ServiceInstance) []Config
 
-	// DestinationPolicy returns a policy for a service version.
+	// DestinationPolicy returns a policy for a service version. Destination
+	// policies apply across namespaces, and a conflict between namespaces is
+	// resolved as described in DestinationPolicyIndex.
 	DestinationPolicy(destination string, tags Tags) *proxyconfig.DestinationVersionPolicy
+
+	// DestinationAnnotations returns the settings of a destination set by the
+	// annotations on its destination policy. Invalid annotations are ignored.
+	DestinationAnnotations(destination string) *DestinationAnnotations
+
+	// DestinationPolicyIndex lists the destination policies once and indexes
+	// them by destination, for looking up the policies of many destinations.
+	DestinationPolicyIndex() *DestinationPolicyIndex
+}
+
+// DestinationAnnotations holds the settings of a destination set by the
+// annotations on its destination policy
+type DestinationAnnotations struct {
+	// Locality is the locality-aware load balancing mode
+	Locality LocalityLoadBalancing
+
+	// ConsistentHash is the consistent hash load balancing configuration, or
+	// nil if the destination does not use consistent hashing
+	ConsistentHash *ConsistentHash
+
+	// HealthChecks are the active health checks of the service versions
+	HealthChecks []*HealthCheck
+
+	// MaxRetries is the maximum number of outstanding retries to the
+	// destination, or zero if the limit is not set
+	MaxRetries int
+}
+
+// HealthCheck returns the active health check for a service version, or nil
+// if the version is not health checked
+func (a *DestinationAnnotations) HealthCheck(tags Tags) *HealthCheck {
+	for _, check := range a.HealthChecks {
+		if tags.Equals(check.Tags) {
+			return check
+		}
+	}
+	return nil
+}
+
+// LocalityLoadBalancing selects how requests to a destination are spread across
+// the availability zones of its instances relative to the zone of the proxy
+type LocalityLoadBalancing string
+
+const (
+	// LocalityDisabled ignores availability zones of the instances
+	LocalityDisabled LocalityLoadBalancing = ""
+
+	// LocalityFailover sends requests only to the instances in the zone of the
+	// proxy, and fails over to the instances in other zones when there are
+	// no available instances in the proxy zone. If the proxy zone holds only
+	// a small share of the healthy instances, the instances are weighted as
+	// with LocalityWeighted instead.
+	LocalityFailover LocalityLoadBalancing = "failover"
+
+	// LocalityWeighted sends requests to the instances in all zones but
+	// weighs the instances in the zone of the proxy higher
+	LocalityWeighted LocalityLoadBalancing = "weighted"
+
+	// LocalityAnnotation is the destination policy annotation that enables
+	// locality-aware load balancing for the destination
+	LocalityAnnotation = "alpha.istio.io/localityLoadBalancing"
+)
+
+// ConsistentHashAnnotation is the destination policy annotation that enables
+// consistent hash load balancing (session affinity) for the destination. The
+// proxy hashes only on the value of an HTTP header:
+//
+//	httpHeaderName=<header>
+//
+// The Envoy v1 route hash policy has no cookie or source IP hashing, so the
+// httpCookie and useSourceIp keys are rejected. Routes that split the traffic
+// to destinations with different hash policies are not hashed.
+const ConsistentHashAnnotation = "alpha.istio.io/consistentHash"
+
+// ConsistentHash configures consistent hash load balancing on one of the
+// request properties
+type ConsistentHash struct {
+	// HTTPHeaderName hashes on the value of the HTTP header
+	HTTPHeaderName string
+}
+
+// MaxRetriesAnnotation is the destination policy annotation that limits the
+// number of outstanding retries to all instances of the destination
+const MaxRetriesAnnotation = "alpha.istio.io/maxRetries"
+
+// HealthCheckAnnotation is the destination policy annotation that declares
+// active health checks for the versions of the destination as a JSON list, e.g.
+//
+//	[{"tags": {"version": "v1"}, "type": "http", "path": "/healthz",
+//	  "interval": "10s", "timeout": "1s", "healthyThreshold": 2, "unhealthyThreshold": 3},
+//	 {"tags": {"version": "v2"}, "type": "tcp", "send": "50494e47", "receive": "504f4e47",
+//	  "interval": "10s", "timeout": "1s", "healthyThreshold": 2, "unhealthyThreshold": 3}]
+//
+// The destination policy API has no health check field yet, so the health
+// checks are declared in an alpha annotation validated by the config stores.
+// Envoy v1 supports only HTTP and TCP health checks, so the "grpc" type is
+// rejected until the proxy supports gRPC health checking.
+const HealthCheckAnnotation = "alpha.istio.io/healthChecks"
+
+// HealthCheckType is the protocol of an active health check
+type HealthCheckType string
+
+const (
+	// HealthCheckHTTP expects a 200 response to an HTTP GET request for the path
+	HealthCheckHTTP HealthCheckType = "http"
+
+	// HealthCheckTCP expects the response to contain the receive payload after
+	// writing the send payload to the TCP connection
+	HealthCheckTCP HealthCheckType = "tcp"
+)
+
+// HealthCheck declares an active health check for the instances of a service version
+type HealthCheck struct {
+	// Tags select the service version the same way as the destination policy
+	Tags Tags
+
+	// Type is the health check protocol
+	Type HealthCheckType
+
+	// Path is the request path for HTTP health checks
+	Path string
+
+	// Send is the hex encoded payload written by TCP health checks
+	Send string
+
+	// Receive is the hex encoded payload expected by TCP health checks
+	Receive string
+
+	// Interval is the time between health checks
+	Interval time.Duration
+
+	// Timeout is the time to wait for a health check response
+	Timeout time.Duration
+
+	// HealthyThreshold is the number of successful health checks to mark an
+	// unhealthy instance as healthy
+	HealthyThreshold int
+
+	// UnhealthyThreshold is the number of failed health checks to mark a
+	// healthy instance as unhealthy
+	UnhealthyThreshold int
+}
+
+// HeadersAnnotation is the route rule annotation that adds request headers on
+// the routes of the rule as a JSON object, e.g.
+//
+//	{"request": {"add": {"x-env": "prod"}}}
+//
+// Only adding request headers is supported. Envoy v1 routes can not set or
+// remove request headers, and response headers can only be manipulated for a
+// whole route table, so the "set" and "remove" request operations and all
+// "response" operations are rejected.
+//
+// The headers are added by the sidecar proxy of the source. The egress proxy
+// does not apply route rules, so it adds no headers to requests to external services.
+const HeadersAnnotation = "alpha.istio.io/headers"
+
+// RouteHeaders manipulates the request headers on the routes of a route rule
+type RouteHeaders struct {
+	// RequestAdd appends the values to the existing values of the headers of
+	// the requests forwarded to the destination
+	RequestAdd map[string]string
+}
+
+// MirrorAnnotation is the route rule annotation that mirrors all requests on
+// the routes of the rule to a service version as a JSON object, e.g.
+//
+//	{"destination": "reviews.default.svc.cluster.local", "tags": {"version": "v3"}}
+//
+// The responses of the mirror destination are discarded. The proxy shadow
+// policy samples requests only through a runtime key, so a "percent" other
+// than 100 is rejected and sampled mirroring is not supported.
+const MirrorAnnotation = "alpha.istio.io/mirror"
+
+// Mirror selects the service version of mirrored requests
+type Mirror struct {
+	// Destination is the hostname of the mirror service
+	Destination string
+
+	// Tags select the version of the mirror service
+	Tags Tags
+}
+
+// RetryPolicyAnnotation is the route rule annotation that extends the simple
+// retry policy of the rule with the retry conditions as a JSON object, e.g.
+//
+//	{"retryOn": ["5xx", "retriable-4xx"]}
+//
+// The retry conditions are the ones of the Envoy v1 retry policy: 5xx,
+// connect-failure, retriable-4xx and refused-stream. Retries on specific
+// status codes or methods and custom backoff intervals are not supported by
+// the proxy, so retriableStatusCodes, retriableMethods and backoff are rejected.
+const RetryPolicyAnnotation = "alpha.istio.io/retryPolicy"
+
+// RetryPolicy extends the simple retry policy of a route rule
+type RetryPolicy struct {
+	// RetryOn lists the HTTP conditions for retrying a request
+	RetryOn []string
 }
 
 const (
+	// NamespaceAll selects objects across all namespaces
+	NamespaceAll = ""
+
 	// IstioAPIGroup defines API group name for Istio configuration resources
 	IstioAPIGroup = "config.istio.io"
 
@@ -247,10 +492,11 @@ var (
 
 	// RouteRule describes route rules
 	RouteRule = ProtoSchema{
-		Type:        "route-rule",
-		Plural:      "route-rules",
-		MessageName: "istio.proxy.v1.config.RouteRule",
-		Validate:    ValidateRouteRule,
+		Type:                "route-rule",
+		Plural:              "route-rules",
+		MessageName:         "istio.proxy.v1.config.RouteRule",
+		Validate:            ValidateRouteRule,
+		ValidateAnnotations: ValidateRouteRuleAnnotations,
 		Key: func(config proto.Message) string {
 			rule := config.(*proxyconfig.RouteRule)
 			return rule.Name
@@ -271,10 +517,11 @@ var (
 
 	// DestinationPolicy describes destination rules
 	DestinationPolicy = ProtoSchema{
-		Type:        "destination-policy",
-		Plural:      "destination-policies",
-		MessageName: "istio.proxy.v1.config.DestinationPolicy",
-		Validate:    ValidateDestinationPolicy,
+		Type:                "destination-policy",
+		Plural:              "destination-policies",
+		MessageName:         "istio.proxy.v1.config.DestinationPolicy",
+		Validate:            ValidateDestinationPolicy,
+		ValidateAnnotations: ValidateDestinationPolicyAnnotations,
 		Key: func(config proto.Message) string {
 			return config.(*proxyconfig.DestinationPolicy).Destination
 		},
@@ -301,21 +548,29 @@ func MakeIstioStore(store ConfigStore) IstioConfigStore {
 
 func (i istioConfigStore) RouteRules() map[string]*proxyconfig.RouteRule {
 	out := make(map[string]*proxyconfig.RouteRule)
-	rs, err := i.List(RouteRule.Type)
+	rs, err := i.List(RouteRule.Type, NamespaceAll)
 	if err != nil {
 		glog.V(2).Infof("RouteRules => %v", err)
 	}
 	for _, r := range rs {
 		if rule, ok := r.Content.(*proxyconfig.RouteRule); ok {
-			out[r.Key] = rule
+			out[r.QualifiedKey()] = rule
 		}
 	}
 	return out
 }
 
-func (i *istioConfigStore) RouteRulesBySource(instances []*ServiceInstance) []*proxyconfig.RouteRule {
-	rules := make([]Config, 0)
-	for key, rule := range i.RouteRules() {
+func (i *istioConfigStore) RouteRulesBySource(instances []*ServiceInstance) []Config {
+	rs, err := i.List(RouteRule.Type, NamespaceAll)
+	if err != nil {
+		glog.V(2).Infof("RouteRulesBySource => %v", err)
+	}
+	rules := make([]Config, 0, len(rs))
+	for _, config := range rs {
+		rule, ok := config.Content.(*proxyconfig.RouteRule)
+		if !ok {
+			continue
+		}
 		// validate that rule match predicate applies to source service instances
 		if rule.Match != nil && rule.Match.Source != "" {
 			found := false
@@ -335,32 +590,26 @@ func (i *istioConfigStore) RouteRulesBySource(instances []*ServiceInstance) []*p
 				continue
 			}
 		}
-		rules = append(rules, Config{Key: key, Content: rule})
+		rules = append(rules, config)
 	}
 	// sort by high precedence first, key string second (keys are unique)
 	sort.Slice(rules, func(i, j int) bool {
 		return rules[i].Content.(*proxyconfig.RouteRule).Precedence > rules[j].Content.(*proxyconfig.RouteRule).Precedence ||
 			(rules[i].Content.(*proxyconfig.RouteRule).Precedence == rules[j].Content.(*proxyconfig.RouteRule).Precedence &&
-				rules[i].Key < rules[j].Key)
+				rules[i].QualifiedKey() < rules[j].QualifiedKey())
 	})
-
-	// project to rules
-	out := make([]*proxyconfig.RouteRule, len(rules))
-	for i, rule := range rules {
-		out[i] = rule.Content.(*proxyconfig.RouteRule)
-	}
-	return out
+	return rules
 }
 
 func (i *istioConfigStore) IngressRules() map[string]*proxyconfig.IngressRule {
 	out := make(map[string]*proxyconfig.IngressRule)
-	rs, err := i.List(IngressRule.Type)
+	rs, err := i.List(IngressRule.Type, NamespaceAll)
 	if err != nil {
 		glog.V(2).Infof("IngressRules => %v", err)
 	}
 	for _, r := range rs {
 		if rule, ok := r.Content.(*proxyconfig.IngressRule); ok {
-			out[r.Key] = rule
+			out[r.QualifiedKey()] = rule
 		}
 	}
 	return out
@@ -368,7 +617,7 @@ func (i *istioConfigStore) IngressRules() map[string]*proxyconfig.IngressRule {
 
 func (i *istioConfigStore) DestinationPolicies() []*proxyconfig.DestinationPolicy {
 	out := make([]*proxyconfig.DestinationPolicy, 0)
-	rs, err := i.List(DestinationPolicy.Type)
+	rs, err := i.List(DestinationPolicy.Type, NamespaceAll)
 	if err != nil {
 		glog.V(2).Infof("DestinationPolicies => %v", err)
 	}
@@ -381,13 +630,98 @@ func (i *istioConfigStore) DestinationPolicies() []*proxyconfig.DestinationPolic
 }
 
 func (i *istioConfigStore) DestinationPolicy(destination string, tags Tags) *proxyconfig.DestinationVersionPolicy {
-	value, exists, _ := i.Get(DestinationPolicy.Type, destination)
-	if exists {
-		for _, policy := range value.(*proxyconfig.DestinationPolicy).Policy {
-			if tags.Equals(policy.Tags) {
-				return policy
-			}
+	return i.DestinationPolicyIndex().Policy(destination, tags)
+}
+
+func (i *istioConfigStore) DestinationAnnotations(destination string) *DestinationAnnotations {
+	return i.DestinationPolicyIndex().Annotations(destination)
+}
+
+func (i *istioConfigStore) DestinationPolicyIndex() *DestinationPolicyIndex {
+	rs, err := i.List(DestinationPolicy.Type, NamespaceAll)
+	if err != nil {
+		glog.V(2).Infof("DestinationPolicyIndex => %v", err)
+	}
+
+	// policies for the same destination in several namespaces are resolved
+	// by the lexicographic order of the namespaces
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Namespace < rs[j].Namespace })
+	index := &DestinationPolicyIndex{
+		configs:     make(map[string]Config, len(rs)),
+		annotations: make(map[string]*DestinationAnnotations, len(rs)),
+	}
+	for _, r := range rs {
+		if _, ok := r.Content.(*proxyconfig.DestinationPolicy); !ok {
+			continue
+		}
+		if selected, exists := index.configs[r.Key]; exists {
+			glog.V(2).Infof("Ignoring destination policy %q in favor of %q", r.QualifiedKey(), selected.QualifiedKey())
+			continue
+		}
+		index.configs[r.Key] = r
+		index.annotations[r.Key] = parseDestinationAnnotations(r.Key, r.Annotations)
+	}
+	return index
+}
+
+// DestinationPolicyIndex is a snapshot of the destination policies indexed by
+// destination. Only one policy applies to a destination: if several namespaces
+// declare a policy for the same destination, the policy in the first
+// namespace in lexicographic order is selected.
+type DestinationPolicyIndex struct {
+	configs     map[string]Config
+	annotations map[string]*DestinationAnnotations
+}
+
+// Policy returns the policy for a service version, or nil if the version has no policy
+func (index *DestinationPolicyIndex) Policy(destination string, tags Tags) *proxyconfig.DestinationVersionPolicy {
+	config, exists := index.configs[destination]
+	if !exists {
+		return nil
+	}
+	for _, policy := range config.Content.(*proxyconfig.DestinationPolicy).Policy {
+		if tags.Equals(policy.Tags) {
+			return policy
 		}
 	}
 	return nil
 }
+
+// Annotations returns the settings of a destination set by the annotations
+// on its destination policy
+func (index *DestinationPolicyIndex) Annotations(destination string) *DestinationAnnotations {
+	if out, exists := index.annotations[destination]; exists {
+		return out
+	}
+	return &DestinationAnnotations{}
+}
+
+// parseDestinationAnnotations parses the annotations of a destination policy,
+// ignoring the invalid annotations
+func parseDestinationAnnotations(destination string, annotations map[string]string) *DestinationAnnotations {
+	out := &DestinationAnnotations{}
+	if len(annotations) == 0 {
+		return out
+	}
+
+	var err error
+	if out.Locality, err = ParseLocalityLoadBalancing(annotations[LocalityAnnotation]); err != nil {
+		glog.Warningf("Ignoring %s for destination %q: %v", LocalityAnnotation, destination, err)
+	}
+	if value := annotations[ConsistentHashAnnotation]; value != "" {
+		if out.ConsistentHash, err = ParseConsistentHash(value); err != nil {
+			glog.Warningf("Ignoring %s for destination %q: %v", ConsistentHashAnnotation, destination, err)
+		}
+	}
+	if value := annotations[HealthCheckAnnotation]; value != "" {
+		if out.HealthChecks, err = ParseHealthChecks(value); err != nil {
+			glog.Warningf("Ignoring %s for destination %q: %v", HealthCheckAnnotation, destination, err)
+		}
+	}
+	if value := annotations[MaxRetriesAnnotation]; value != "" {
+		if out.MaxRetries, err = ParseMaxRetries(value); err != nil {
+			glog.Warningf("Ignoring %s for destination %q: %v", MaxRetriesAnnotation, destination, err)
+		}
+	}
+	return out
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/mock/gomock"
@@ -205,9 +206,20 @@ func TestIstioRegistryRouteRules(t *testing.T) {
 				"baz": routeRule2SourceEmpty,
 			},
 		},
+		{
+			name: "RouteRules with the same key in distinct namespaces",
+			mockObjs: []Config{
+				{Key: "foo", Namespace: "default", Content: routeRule1MatchNil},
+				{Key: "foo", Namespace: "istio-system", Content: routeRule2SourceEmpty},
+			},
+			want: map[string]*proxyconfig.RouteRule{
+				"default/foo":      routeRule1MatchNil,
+				"istio-system/foo": routeRule2SourceEmpty,
+			},
+		},
 	}
 	for _, c := range cases {
-		r.mock.EXPECT().List(RouteRule.Type).Return(c.mockObjs, c.mockError)
+		r.mock.EXPECT().List(RouteRule.Type, NamespaceAll).Return(c.mockObjs, c.mockError)
 		if got := r.registry.RouteRules(); !reflect.DeepEqual(got, c.want) {
 			t.Errorf("%v with RouteRule failed: \ngot %+vwant %+v", c.name, spew.Sdump(got), spew.Sdump(c.want))
 		}
@@ -227,7 +239,7 @@ func TestIstioRegistryIngressRules(t *testing.T) {
 		t.Errorf("unexpected ingress rule key not equal to name")
 	}
 
-	r.mock.EXPECT().List(IngressRule.Type).Return([]Config{{
+	r.mock.EXPECT().List(IngressRule.Type, NamespaceAll).Return([]Config{{
 		Key:     rule.Name,
 		Content: rule,
 	}}, nil)
@@ -238,7 +250,7 @@ func TestIstioRegistryIngressRules(t *testing.T) {
 		t.Errorf("IngressRules failed: \ngot %+vwant %+v", spew.Sdump(got), spew.Sdump(rule))
 	}
 
-	r.mock.EXPECT().List(IngressRule.Type).Return(nil, errors.New("cannot list"))
+	r.mock.EXPECT().List(IngressRule.Type, NamespaceAll).Return(nil, errors.New("cannot list"))
 	if got := r.registry.IngressRules(); len(got) > 0 {
 		t.Errorf("IngressRules failed: \ngot %+vwant empty", spew.Sdump(got))
 	}
@@ -259,14 +271,9 @@ func TestIstioRegistryRouteRulesBySource(t *testing.T) {
 		{Key: "tag-subset-of-mismatch", Content: routeRule5TagSubsetOfMismatch},
 		{Key: "tag-subset-of-match", Content: routeRule6TagSubsetOfMatch},
 	}
-	want := []*proxyconfig.RouteRule{
-		routeRule6TagSubsetOfMatch,
-		routeRule4SourceMatch,
-		routeRule1MatchNil,
-		routeRule2SourceEmpty,
-	}
+	want := []Config{mockObjs[5], mockObjs[3], mockObjs[0], mockObjs[1]}
 
-	r.mock.EXPECT().List(RouteRule.Type).Return(mockObjs, nil)
+	r.mock.EXPECT().List(RouteRule.Type, NamespaceAll).Return(mockObjs, nil)
 	got := r.registry.RouteRulesBySource(instances)
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Failed \ngot %+vwant %+v", spew.Sdump(got), spew.Sdump(want))
@@ -310,7 +317,7 @@ func TestIstioRegistryPolicies(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		r.mock.EXPECT().List(DestinationPolicy.Type).Return(c.mockObjs, c.mockError)
+		r.mock.EXPECT().List(DestinationPolicy.Type, NamespaceAll).Return(c.mockObjs, c.mockError)
 		if got := r.registry.DestinationPolicies(); !reflect.DeepEqual(makeSet(got), makeSet(c.want)) {
 			t.Errorf("%v failed: \ngot %+vwant %+v", c.name, spew.Sdump(got), spew.Sdump(c.want))
 		}
@@ -325,16 +332,133 @@ func TestIstioRegistryDestinationPolicies(t *testing.T) {
 		t.Error("expect destination policy key to be hostname")
 	}
 
-	r.mock.EXPECT().Get(DestinationPolicy.Type, dstPolicy1.Destination).Return(dstPolicy1, true, "rev")
+	// dstPolicy1 and dstPolicy2 are policies for the same destination, and the
+	// policy in the first namespace by lexicographic order is selected
+	mockObjs := []Config{
+		{Key: dstPolicy2.Destination, Namespace: "istio-system", Content: dstPolicy2},
+		{Key: dstPolicy1.Destination, Namespace: "default", Content: dstPolicy1},
+	}
+
+	r.mock.EXPECT().List(DestinationPolicy.Type, NamespaceAll).Return(mockObjs, nil)
 	want := dstPolicy1.Policy[0]
 	if got := r.registry.DestinationPolicy(dstPolicy1.Destination, want.Tags); !reflect.DeepEqual(got, want) {
 		t.Errorf("Failed: \ngot %+vwant %+v", spew.Sdump(got), spew.Sdump(want))
 	}
 
-	r.mock.EXPECT().Get(DestinationPolicy.Type, dstPolicy3.Destination).Return(nil, false, "")
+	r.mock.EXPECT().List(DestinationPolicy.Type, NamespaceAll).Return(mockObjs, nil)
 	if got := r.registry.DestinationPolicy(dstPolicy3.Destination, nil); got != nil {
 		t.Errorf("Failed: \ngot %+vwant nil", spew.Sdump(got))
 	}
+
+	// the policy in the other namespace does not apply even if it has no
+	// policy for the service version
+	swapped := []Config{
+		{Key: dstPolicy2.Destination, Namespace: "default", Content: dstPolicy2},
+		{Key: dstPolicy1.Destination, Namespace: "istio-system", Content: dstPolicy1},
+	}
+	r.mock.EXPECT().List(DestinationPolicy.Type, NamespaceAll).Return(swapped, nil)
+	if got := r.registry.DestinationPolicy(dstPolicy1.Destination, want.Tags); got != nil {
+		t.Errorf("Failed: \ngot %+vwant nil", spew.Sdump(got))
+	}
+}
+
+func TestIstioRegistryDestinationPolicyIndex(t *testing.T) {
+	r := initTestRegistry(t)
+	defer r.shutdown()
+
+	mockObjs := []Config{
+		{Key: dstPolicy3.Destination, Namespace: "default", Content: dstPolicy3,
+			Annotations: map[string]string{MaxRetriesAnnotation: "5"}},
+		{Key: dstPolicy1.Destination, Namespace: "default", Content: dstPolicy1},
+	}
+
+	// the store is listed once for all the lookups
+	r.mock.EXPECT().List(DestinationPolicy.Type, NamespaceAll).Return(mockObjs, nil).Times(1)
+	index := r.registry.DestinationPolicyIndex()
+	for i := 0; i < 2; i++ {
+		if got := index.Policy(dstPolicy1.Destination, dstTags0); got != dstPolicy1.Policy[0] {
+			t.Errorf("Policy(%q) => got %#v, want %#v", dstPolicy1.Destination, got, dstPolicy1.Policy[0])
+		}
+		if got := index.Policy(dstPolicy3.Destination, dstTags0); got != nil {
+			t.Errorf("Policy(%q) => got %#v, want nil", dstPolicy3.Destination, got)
+		}
+		if got := index.Annotations(dstPolicy3.Destination); got.MaxRetries != 5 {
+			t.Errorf("Annotations(%q) => got %#v, want max retries 5", dstPolicy3.Destination, got)
+		}
+		if got := index.Annotations("unknown"); !reflect.DeepEqual(got, &DestinationAnnotations{}) {
+			t.Errorf("Annotations(unknown) => got %#v, want no settings", got)
+		}
+	}
+}
+
+func TestIstioRegistryDestinationAnnotations(t *testing.T) {
+	r := initTestRegistry(t)
+	defer r.shutdown()
+
+	healthChecks := `[{"tags": {"a": "b"}, "type": "http", "path": "/healthz", "interval": "1s", "timeout": "1s",
+		"healthyThreshold": 1, "unhealthyThreshold": 1}]`
+	check := &HealthCheck{Tags: dstTags0, Type: HealthCheckHTTP, Path: "/healthz", Interval: time.Second,
+		Timeout: time.Second, HealthyThreshold: 1, UnhealthyThreshold: 1}
+
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        *DestinationAnnotations
+	}{
+		{name: "no annotations", want: &DestinationAnnotations{}},
+		{
+			name: "all annotations",
+			annotations: map[string]string{
+				LocalityAnnotation:       "failover",
+				ConsistentHashAnnotation: "httpHeaderName=x-user",
+				HealthCheckAnnotation:    healthChecks,
+				MaxRetriesAnnotation:     "10",
+			},
+			want: &DestinationAnnotations{
+				Locality:       LocalityFailover,
+				ConsistentHash: &ConsistentHash{HTTPHeaderName: "x-user"},
+				HealthChecks:   []*HealthCheck{check},
+				MaxRetries:     10,
+			},
+		},
+		{
+			name: "invalid annotations",
+			annotations: map[string]string{
+				LocalityAnnotation:       "nearest",
+				ConsistentHashAnnotation: "httpHeaderName",
+				HealthCheckAnnotation:    "tcp",
+				MaxRetriesAnnotation:     "-1",
+			},
+			want: &DestinationAnnotations{},
+		},
+	}
+
+	for _, c := range cases {
+		// the policy in the first namespace by lexicographic order is selected
+		mockObjs := []Config{
+			{Key: dstPolicy3.Destination, Namespace: "istio-system", Content: dstPolicy3},
+			{Key: dstPolicy1.Destination, Namespace: "istio-system", Content: dstPolicy1},
+			{Key: dstPolicy1.Destination, Namespace: "default", Content: dstPolicy1, Annotations: c.annotations},
+		}
+		r.mock.EXPECT().List(DestinationPolicy.Type, NamespaceAll).Return(mockObjs, nil)
+		if got := r.registry.DestinationAnnotations(dstPolicy1.Destination); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: DestinationAnnotations => got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+
+	r.mock.EXPECT().List(DestinationPolicy.Type, NamespaceAll).Return(nil, errors.New("foobar"))
+	if got := r.registry.DestinationAnnotations(dstPolicy1.Destination); !reflect.DeepEqual(got,
+		&DestinationAnnotations{}) {
+		t.Errorf("DestinationAnnotations => got %#v, want no settings on error", got)
+	}
+
+	annotations := &DestinationAnnotations{HealthChecks: []*HealthCheck{check}}
+	if got := annotations.HealthCheck(dstTags0); got != check {
+		t.Errorf("HealthCheck(%v) => got %#v, want %#v", dstTags0, got, check)
+	}
+	if got := annotations.HealthCheck(nil); got != nil {
+		t.Errorf("HealthCheck(nil) => got %#v for default version, want nil", got)
+	}
 }
 
 func TestEventString(t *testing.T) {
@@ -46,6 +46,11 @@ type Service struct {
 	// Address specifies the service IPv4 address of the load balancer
 	Address string `json:"address,omitempty"`
 
+	// Headless is set for services without a load balancer address that are
+	// reached through the network endpoints of the service instances, e.g.
+	// Kubernetes services with the cluster IP "None".
+	Headless bool `json:"headless,omitempty"`
+
 	// Ports is the set of network ports where the service is listening for
 	// connections
 	Ports PortList `json:"ports,omitempty"`
@@ -174,8 +179,22 @@ type ServiceInstance struct {
 	Tags             Tags            `json:"tags,omitempty"`
 	AvailabilityZone string          `json:"az,omitempty"`
 	ServiceAccount   string          `json:"serviceaccount,omitempty"`
+	Health           HealthStatus    `json:"health,omitempty"`
 }
 
+// HealthStatus describes the readiness of a service instance to receive traffic
+// as reported by the service registry. Registries list the instances in all
+// health states, and the consumers decide whether to use the unhealthy ones.
+type HealthStatus int
+
+const (
+	// Healthy instances are ready to receive traffic
+	Healthy HealthStatus = iota
+
+	// Unhealthy instances are not ready or failing their health checks
+	Unhealthy
+)
+
 // ServiceDiscovery enumerates Istio service instances.
 type ServiceDiscovery interface {
 	// Services list declarations of all services in the system
@@ -201,6 +220,9 @@ type ServiceDiscovery interface {
 	//
 	// Similar concepts apply for calling this function with a specific
 	// port, hostname and tags.
+	//
+	// The list includes the unhealthy instances, which are marked by their
+	// health status.
 	Instances(hostname string, ports []string, tags TagsList) []*ServiceInstance
 
 	// HostInstances lists service instances for a given set of IPv4 addresses.
@@ -15,6 +15,8 @@
 package model
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
@@ -100,6 +102,21 @@ func (descriptor ConfigDescriptor) Validate() error {
 	return errs
 }
 
+// ValidateConfig checks the content of the config object and the extensions
+// in its annotations, assuming the content is an instance of the schema message type
+func (ps ProtoSchema) ValidateConfig(config Config) error {
+	var errs error
+	if err := ps.Validate(config.Content); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if ps.ValidateAnnotations != nil {
+		if err := ps.ValidateAnnotations(config.Annotations); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
 // ValidateConfig ensures that the config object is well-defined
 func (descriptor ConfigDescriptor) ValidateConfig(typ string, obj interface{}) error {
 	if obj == nil {
@@ -510,6 +527,351 @@ func ValidateAbortHTTPStatus(httpStatus *proxyconfig.HTTPFaultInjection_Abort_Ht
 	return
 }
 
+// ParseLocalityLoadBalancing parses the value of the locality annotation on
+// destination policies
+func ParseLocalityLoadBalancing(value string) (LocalityLoadBalancing, error) {
+	switch mode := LocalityLoadBalancing(value); mode {
+	case LocalityDisabled, LocalityFailover, LocalityWeighted:
+		return mode, nil
+	default:
+		return LocalityDisabled, fmt.Errorf("unknown locality load balancing %q", value)
+	}
+}
+
+// ParseConsistentHash parses the value of the consistent hash annotation on
+// destination policies
+func ParseConsistentHash(value string) (*ConsistentHash, error) {
+	fields := strings.Split(value, ";")
+	kv := strings.SplitN(fields[0], "=", 2)
+	hash := &ConsistentHash{}
+	switch {
+	case kv[0] == "httpHeaderName" && len(kv) == 2:
+		if kv[1] == "" {
+			return nil, errors.New("consistent hash header name must not be empty")
+		}
+		if err := ValidateHTTPHeaderName(kv[1]); err != nil {
+			return nil, err
+		}
+		hash.HTTPHeaderName = kv[1]
+	case kv[0] == "httpCookie" || kv[0] == "useSourceIp":
+		return nil, fmt.Errorf("Istio does not support consistent hash on %s yet", kv[0])
+	default:
+		return nil, fmt.Errorf("invalid consistent hash %q", value)
+	}
+
+	if len(fields) > 1 {
+		return nil, fmt.Errorf("unexpected options in consistent hash %q", value)
+	}
+	return hash, nil
+}
+
+// healthCheckJSON is the JSON representation of a health check in the
+// health check annotation on destination policies
+type healthCheckJSON struct {
+	Tags               Tags            `json:"tags,omitempty"`
+	Type               HealthCheckType `json:"type"`
+	Path               string          `json:"path,omitempty"`
+	Send               string          `json:"send,omitempty"`
+	Receive            string          `json:"receive,omitempty"`
+	Interval           string          `json:"interval"`
+	Timeout            string          `json:"timeout"`
+	HealthyThreshold   int             `json:"healthyThreshold"`
+	UnhealthyThreshold int             `json:"unhealthyThreshold"`
+}
+
+// ParseHealthChecks parses and validates the value of the health check
+// annotation on destination policies
+func ParseHealthChecks(value string) ([]*HealthCheck, error) {
+	var in []healthCheckJSON
+	if err := json.Unmarshal([]byte(value), &in); err != nil {
+		return nil, multierror.Prefix(err, "invalid health checks:")
+	}
+
+	var errs error
+	out := make([]*HealthCheck, 0, len(in))
+	for i, check := range in {
+		if len(check.Tags) == 0 {
+			check.Tags = nil
+		}
+		for _, other := range out {
+			if check.Tags.Equals(other.Tags) {
+				errs = multierror.Append(errs, fmt.Errorf("duplicate health check for tags %v", check.Tags))
+			}
+		}
+		if err := check.Tags.Validate(); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+
+		switch check.Type {
+		case HealthCheckHTTP:
+			if !strings.HasPrefix(check.Path, "/") {
+				errs = multierror.Append(errs, fmt.Errorf("http health check path %q must be absolute", check.Path))
+			}
+			if check.Send != "" || check.Receive != "" {
+				errs = multierror.Append(errs, errors.New("http health check does not support payloads"))
+			}
+		case HealthCheckTCP:
+			if check.Path != "" {
+				errs = multierror.Append(errs, errors.New("tcp health check does not support a path"))
+			}
+			if err := validateHealthCheckPayload("send", check.Send); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			if err := validateHealthCheckPayload("receive", check.Receive); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		case "grpc":
+			errs = multierror.Append(errs, errors.New("Istio does not support grpc health checks yet"))
+		default:
+			errs = multierror.Append(errs, fmt.Errorf("unknown health check type %q", check.Type))
+		}
+
+		interval, err := time.ParseDuration(check.Interval)
+		if err == nil {
+			err = ValidateDurationRange(interval, time.Millisecond, time.Hour)
+		}
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "invalid health check interval:"))
+		}
+		timeout, err := time.ParseDuration(check.Timeout)
+		if err == nil {
+			err = ValidateDurationRange(timeout, time.Millisecond, time.Hour)
+		}
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "invalid health check timeout:"))
+		}
+
+		if check.HealthyThreshold < 1 {
+			errs = multierror.Append(errs, fmt.Errorf("health check %d healthy threshold must be positive", i))
+		}
+		if check.UnhealthyThreshold < 1 {
+			errs = multierror.Append(errs, fmt.Errorf("health check %d unhealthy threshold must be positive", i))
+		}
+
+		out = append(out, &HealthCheck{
+			Tags:               check.Tags,
+			Type:               check.Type,
+			Path:               check.Path,
+			Send:               check.Send,
+			Receive:            check.Receive,
+			Interval:           interval,
+			Timeout:            timeout,
+			HealthyThreshold:   check.HealthyThreshold,
+			UnhealthyThreshold: check.UnhealthyThreshold,
+		})
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// validateHealthCheckPayload checks that the TCP health check payload is
+// present and hex encoded
+func validateHealthCheckPayload(name, payload string) error {
+	if payload == "" {
+		return fmt.Errorf("tcp health check requires a %s payload", name)
+	}
+	if _, err := hex.DecodeString(payload); err != nil {
+		return fmt.Errorf("tcp health check %s payload %q must be hex encoded", name, payload)
+	}
+	return nil
+}
+
+// ParseMaxRetries parses the value of the max retries annotation on
+// destination policies
+func ParseMaxRetries(value string) (int, error) {
+	maxRetries, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max retries %q", value)
+	}
+	if maxRetries < 1 {
+		return 0, fmt.Errorf("max retries %d must be positive", maxRetries)
+	}
+	return maxRetries, nil
+}
+
+// retryConditions lists the retry conditions supported by the proxy
+var retryConditions = map[string]bool{
+	"5xx":             true,
+	"connect-failure": true,
+	"retriable-4xx":   true,
+	"refused-stream":  true,
+}
+
+// retryPolicyJSON is the JSON representation of the retry policy annotation
+// on route rules
+type retryPolicyJSON struct {
+	RetryOn []string `json:"retryOn,omitempty"`
+
+	// the proxy does not support retriable status codes, methods and backoff,
+	// they are decoded only to reject them
+	RetriableStatusCodes []int            `json:"retriableStatusCodes,omitempty"`
+	RetriableMethods     []string         `json:"retriableMethods,omitempty"`
+	Backoff              *json.RawMessage `json:"backoff,omitempty"`
+}
+
+// ParseRetryPolicy parses and validates the value of the retry policy
+// annotation on route rules
+func ParseRetryPolicy(value string) (*RetryPolicy, error) {
+	var in retryPolicyJSON
+	if err := json.Unmarshal([]byte(value), &in); err != nil {
+		return nil, multierror.Prefix(err, "invalid retry policy:")
+	}
+
+	var errs error
+	for _, condition := range in.RetryOn {
+		if !retryConditions[condition] {
+			errs = multierror.Append(errs, fmt.Errorf("unknown retry condition %q", condition))
+		}
+	}
+	if len(in.RetriableStatusCodes) > 0 {
+		errs = multierror.Append(errs, errors.New("Istio does not support retriable status codes yet"))
+	}
+	if len(in.RetriableMethods) > 0 {
+		errs = multierror.Append(errs, errors.New("Istio does not support retriable methods yet"))
+	}
+	if in.Backoff != nil {
+		errs = multierror.Append(errs, errors.New("Istio does not support retry backoff yet"))
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+	return &RetryPolicy{RetryOn: in.RetryOn}, nil
+}
+
+// headerOperationsJSON is the JSON representation of the header operations
+// in the headers annotation on route rules
+type headerOperationsJSON struct {
+	Add    map[string]string `json:"add,omitempty"`
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// ParseRouteHeaders parses and validates the value of the headers annotation
+// on route rules
+func ParseRouteHeaders(value string) (*RouteHeaders, error) {
+	var in struct {
+		Request  headerOperationsJSON `json:"request"`
+		Response headerOperationsJSON `json:"response"`
+	}
+	if err := json.Unmarshal([]byte(value), &in); err != nil {
+		return nil, multierror.Prefix(err, "invalid headers:")
+	}
+
+	var errs error
+	for name := range in.Request.Add {
+		switch {
+		case name == "":
+			errs = multierror.Append(errs, errors.New("header name must not be empty"))
+		case strings.HasPrefix(name, ":") || name == "host":
+			errs = multierror.Append(errs, fmt.Errorf("header %q can not be manipulated", name))
+		default:
+			if err := ValidateHTTPHeaderName(name); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("header %q:", name)))
+			}
+		}
+	}
+	if len(in.Request.Set) > 0 || len(in.Request.Remove) > 0 {
+		errs = multierror.Append(errs, errors.New("Istio does not support setting or removing request headers yet"))
+	}
+	if len(in.Response.Add) > 0 || len(in.Response.Set) > 0 || len(in.Response.Remove) > 0 {
+		errs = multierror.Append(errs, errors.New("Istio does not support response header operations yet"))
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+	return &RouteHeaders{RequestAdd: in.Request.Add}, nil
+}
+
+// ParseMirror parses and validates the value of the mirror annotation on route
+// rules
+func ParseMirror(value string) (*Mirror, error) {
+	var in struct {
+		Destination string   `json:"destination"`
+		Tags        Tags     `json:"tags,omitempty"`
+		Percent     *float32 `json:"percent,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(value), &in); err != nil {
+		return nil, multierror.Prefix(err, "invalid mirror:")
+	}
+
+	var errs error
+	if err := ValidateFQDN(in.Destination); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := in.Tags.Validate(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	// the Envoy v1 shadow policy mirrors a percentage of requests only through
+	// a runtime key, which the proxy agent does not manage
+	if in.Percent != nil && *in.Percent != 100 {
+		errs = multierror.Append(errs, errors.New("Istio does not support mirroring a percentage of requests yet"))
+	}
+
+	out := &Mirror{Destination: in.Destination, Tags: in.Tags}
+	if len(out.Tags) == 0 {
+		out.Tags = nil
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// ValidateRouteRuleAnnotations checks the route rule annotations for the
+// retry policy, the header operations and the mirror
+func ValidateRouteRuleAnnotations(annotations map[string]string) (errs error) {
+	if value, exists := annotations[RetryPolicyAnnotation]; exists {
+		if _, err := ParseRetryPolicy(value); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if value, exists := annotations[HeadersAnnotation]; exists {
+		if _, err := ParseRouteHeaders(value); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if value, exists := annotations[MirrorAnnotation]; exists {
+		if _, err := ParseMirror(value); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return
+}
+
+// ValidateDestinationPolicyAnnotations checks the destination policy
+// annotations for locality, consistent hash load balancing, health checks and
+// the max retries circuit breaker
+func ValidateDestinationPolicyAnnotations(annotations map[string]string) (errs error) {
+	if value, exists := annotations[LocalityAnnotation]; exists {
+		if _, err := ParseLocalityLoadBalancing(value); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if value, exists := annotations[ConsistentHashAnnotation]; exists {
+		if _, err := ParseConsistentHash(value); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if value, exists := annotations[HealthCheckAnnotation]; exists {
+		if _, err := ParseHealthChecks(value); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if value, exists := annotations[MaxRetriesAnnotation]; exists {
+		if _, err := ParseMaxRetries(value); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return
+}
+
 // ValidateAbort checks that fault injection abort is well-formed
 func ValidateAbort(abort *proxyconfig.HTTPFaultInjection_Abort) (errs error) {
 	if err := ValidateFloatPercent(abort.Percent); err != nil {
@@ -641,6 +1003,17 @@ func ValidateWeights(routes []*proxyconfig.DestinationWeight, defaultDestination
 	return
 }
 
+// weightedRoutes counts the destinations that receive a share of the traffic
+func weightedRoutes(routes []*proxyconfig.DestinationWeight) int {
+	count := 0
+	for _, route := range routes {
+		if route.Weight > 0 {
+			count++
+		}
+	}
+	return count
+}
+
 // ValidateRouteRule checks routing rules
 func ValidateRouteRule(msg proto.Message) error {
 	value, ok := msg.(*proxyconfig.RouteRule)
@@ -703,6 +1076,11 @@ func ValidateRouteRule(msg proto.Message) error {
 		if err := ValidateWeights(value.Route, value.Destination); err != nil {
 			errs = multierror.Append(errs, err)
 		}
+		// Envoy TCP routes select a single cluster
+		if value.GetMatch().GetTcp() != nil && weightedRoutes(value.Route) > 1 {
+			errs = multierror.Append(errs,
+				errors.New("Istio does not support weighted routes with L4 match attributes yet"))
+		}
 	}
 
 	if value.HttpReqTimeout != nil {
@@ -15,6 +15,7 @@
 package model
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -132,6 +133,26 @@ func TestConfigDescriptorValidateConfig(t *testing.T) {
 	}
 }
 
+func TestProtoSchemaValidateConfig(t *testing.T) {
+	rule := &proxyconfig.RouteRule{Name: "rule", Destination: "foo.bar"}
+	if err := RouteRule.ValidateConfig(Config{Content: rule}); err != nil {
+		t.Errorf("ValidateConfig => unexpected error %v", err)
+	}
+	if err := RouteRule.ValidateConfig(Config{Content: rule,
+		Annotations: map[string]string{RetryPolicyAnnotation: "5xx"}}); err == nil {
+		t.Error("ValidateConfig => got no error for an invalid annotation")
+	}
+	if err := RouteRule.ValidateConfig(Config{Content: &proxyconfig.RouteRule{}}); err == nil {
+		t.Error("ValidateConfig => got no error for an invalid rule")
+	}
+
+	ingress := &proxyconfig.IngressRule{Name: "ingress", Destination: "foo.bar"}
+	if err := IngressRule.ValidateConfig(Config{Content: ingress,
+		Annotations: map[string]string{RetryPolicyAnnotation: "5xx"}}); err != nil {
+		t.Errorf("ValidateConfig => unexpected error %v for a type without annotations", err)
+	}
+}
+
 func TestServiceInstanceValidate(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -504,6 +525,30 @@ func TestValidateRouteAndIngressRule(t *testing.T) {
 			},
 		},
 			valid: false},
+		{name: "route rule match subnets with weighted routes", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			Match: &proxyconfig.MatchCondition{
+				Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"1.2.3.4"}},
+			},
+			Route: []*proxyconfig.DestinationWeight{
+				{Tags: map[string]string{"version": "v1"}, Weight: 75},
+				{Tags: map[string]string{"version": "v2"}, Weight: 25},
+			},
+		},
+			valid: false},
+		{name: "route rule match subnets with single weighted route", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			Match: &proxyconfig.MatchCondition{
+				Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"1.2.3.4"}},
+			},
+			Route: []*proxyconfig.DestinationWeight{
+				{Tags: map[string]string{"version": "v1"}, Weight: 100},
+				{Tags: map[string]string{"version": "v2"}, Weight: 0},
+			},
+		},
+			valid: true},
 		{name: "route rule match invalid redirect", in: &proxyconfig.RouteRule{
 			Destination: "host.default.svc.cluster.local",
 			Name:        "test",
@@ -666,6 +711,224 @@ func TestValidateDestinationPolicy(t *testing.T) {
 	}
 }
 
+func TestParseConsistentHash(t *testing.T) {
+	cases := []struct {
+		in   string
+		want *ConsistentHash
+	}{
+		{in: "httpHeaderName=x-user-id", want: &ConsistentHash{HTTPHeaderName: "x-user-id"}},
+		{in: ""},
+		{in: "httpHeaderName="},
+		{in: "httpHeaderName=X-User-Id"},
+		{in: "httpHeaderName=x-user-id;ttl=1h"},
+		{in: "httpCookie=session"},
+		{in: "httpCookie=session;ttl=1h"},
+		{in: "useSourceIp"},
+		{in: "roundRobin"},
+	}
+	for _, c := range cases {
+		got, err := ParseConsistentHash(c.in)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("ParseConsistentHash(%q) => got %#v, want error", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseConsistentHash(%q) => unexpected error %v", c.in, err)
+		} else if *got != *c.want {
+			t.Errorf("ParseConsistentHash(%q) => got %#v, want %#v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseHealthChecks(t *testing.T) {
+	valid := `[
+		{"type": "http", "path": "/healthz", "interval": "10s", "timeout": "1s",
+		 "healthyThreshold": 2, "unhealthyThreshold": 3},
+		{"tags": {"version": "v2"}, "type": "tcp", "send": "50494e47", "receive": "504f4e47",
+		 "interval": "5s", "timeout": "1s", "healthyThreshold": 1, "unhealthyThreshold": 1}
+	]`
+	checks, err := ParseHealthChecks(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*HealthCheck{
+		{
+			Type:               HealthCheckHTTP,
+			Path:               "/healthz",
+			Interval:           10 * time.Second,
+			Timeout:            time.Second,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		},
+		{
+			Tags:               Tags{"version": "v2"},
+			Type:               HealthCheckTCP,
+			Send:               "50494e47",
+			Receive:            "504f4e47",
+			Interval:           5 * time.Second,
+			Timeout:            time.Second,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		},
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("ParseHealthChecks => got %#v, want %#v", checks, want)
+	}
+
+	invalid := []string{
+		`{"type": "tcp"}`,
+		`[{"type": "udp", "interval": "1s", "timeout": "1s", "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "grpc", "interval": "1s", "timeout": "1s", "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "http", "path": "healthz", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "http", "path": "/", "send": "00", "receive": "00", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "tcp", "interval": "1s", "timeout": "1s", "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "tcp", "send": "PING", "receive": "PONG", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "tcp", "path": "/", "send": "00", "receive": "00", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "tcp", "send": "00", "receive": "00", "interval": "0s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "tcp", "send": "00", "receive": "00", "interval": "1s", "timeout": "never",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "tcp", "send": "00", "receive": "00", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 0, "unhealthyThreshold": 1}]`,
+		`[{"type": "tcp", "send": "00", "receive": "00", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 0}]`,
+		`[{"tags": {"version": ""}, "type": "http", "path": "/", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+		`[{"type": "http", "path": "/", "interval": "1s", "timeout": "1s", "healthyThreshold": 1, "unhealthyThreshold": 1},
+		  {"tags": {}, "type": "http", "path": "/", "interval": "1s", "timeout": "1s",
+		   "healthyThreshold": 1, "unhealthyThreshold": 1}]`,
+	}
+	for _, value := range invalid {
+		if _, err := ParseHealthChecks(value); err == nil {
+			t.Errorf("ParseHealthChecks(%s) => got no error", value)
+		}
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	value := `{"retryOn": ["5xx", "connect-failure", "retriable-4xx", "refused-stream"]}`
+	want := &RetryPolicy{RetryOn: []string{"5xx", "connect-failure", "retriable-4xx", "refused-stream"}}
+	if got, err := ParseRetryPolicy(value); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRetryPolicy => got %#v (%v), want %#v", got, err, want)
+	}
+
+	invalid := []string{
+		`["5xx"]`,
+		`{"retryOn": ["sometimes"]}`,
+		`{"retryOn": ["deadline-exceeded"]}`,
+		`{"retryOn": ["retriable-status-codes"]}`,
+		`{"retriableStatusCodes": [409]}`,
+		`{"retriableMethods": ["GET"]}`,
+		`{"backoff": {"baseInterval": "25ms"}}`,
+	}
+	for _, value := range invalid {
+		if _, err := ParseRetryPolicy(value); err == nil {
+			t.Errorf("ParseRetryPolicy(%s) => got no error", value)
+		}
+	}
+
+	if err := ValidateRouteRuleAnnotations(map[string]string{RetryPolicyAnnotation: `{"retryOn": []}`}); err != nil {
+		t.Errorf("ValidateRouteRuleAnnotations => unexpected error %v", err)
+	}
+	if err := ValidateRouteRuleAnnotations(map[string]string{RetryPolicyAnnotation: "5xx"}); err == nil {
+		t.Error("ValidateRouteRuleAnnotations => got no error")
+	}
+}
+
+func TestParseRouteHeaders(t *testing.T) {
+	value := `{"request": {"add": {"x-env": "prod"}}}`
+	want := &RouteHeaders{RequestAdd: map[string]string{"x-env": "prod"}}
+	if got, err := ParseRouteHeaders(value); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRouteHeaders => got %#v (%v), want %#v", got, err, want)
+	}
+
+	invalid := []string{
+		`["x-env"]`,
+		`{"request": {"add": {"X-Env": "prod"}}}`,
+		`{"request": {"add": {"": "prod"}}}`,
+		`{"request": {"add": {":authority": "example.com"}}}`,
+		`{"request": {"add": {"host": "example.com"}}}`,
+		`{"request": {"set": {"x-env": "test"}}}`,
+		`{"request": {"remove": ["x-debug"]}}`,
+		`{"response": {"add": {"x-env": "prod"}}}`,
+		`{"response": {"remove": ["x-internal"]}}`,
+	}
+	for _, value := range invalid {
+		if _, err := ParseRouteHeaders(value); err == nil {
+			t.Errorf("ParseRouteHeaders(%s) => got no error", value)
+		}
+	}
+
+	if err := ValidateRouteRuleAnnotations(map[string]string{HeadersAnnotation: `{"request": {}}`}); err != nil {
+		t.Errorf("ValidateRouteRuleAnnotations => unexpected error %v", err)
+	}
+	if err := ValidateRouteRuleAnnotations(map[string]string{HeadersAnnotation: "x-env"}); err == nil {
+		t.Error("ValidateRouteRuleAnnotations => got no error")
+	}
+}
+
+func TestParseMirror(t *testing.T) {
+	value := `{"destination": "reviews.default.svc.cluster.local", "tags": {"version": "v3"}, "percent": 100}`
+	want := &Mirror{Destination: "reviews.default.svc.cluster.local", Tags: Tags{"version": "v3"}}
+	if got, err := ParseMirror(value); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMirror => got %#v (%v), want %#v", got, err, want)
+	}
+
+	want = &Mirror{Destination: "reviews.default.svc.cluster.local"}
+	if got, err := ParseMirror(`{"destination": "reviews.default.svc.cluster.local"}`); err != nil ||
+		!reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMirror => got %#v (%v), want %#v", got, err, want)
+	}
+
+	invalid := []string{
+		`reviews`,
+		`{}`,
+		`{"destination": "_reviews"}`,
+		`{"destination": "reviews", "tags": {"version": "$v3"}}`,
+		`{"destination": "reviews", "percent": 10}`,
+		`{"destination": "reviews", "percent": 101}`,
+	}
+	for _, value := range invalid {
+		if _, err := ParseMirror(value); err == nil {
+			t.Errorf("ParseMirror(%s) => got no error", value)
+		}
+	}
+
+	if err := ValidateRouteRuleAnnotations(map[string]string{MirrorAnnotation: `{"destination": ""}`}); err == nil {
+		t.Error("ValidateRouteRuleAnnotations => got no error")
+	}
+}
+
+func TestValidateDestinationPolicyAnnotations(t *testing.T) {
+	cases := []struct {
+		in    map[string]string
+		valid bool
+	}{
+		{in: nil, valid: true},
+		{in: map[string]string{"other": "value"}, valid: true},
+		{in: map[string]string{LocalityAnnotation: "failover", ConsistentHashAnnotation: "httpHeaderName=x-user"},
+			valid: true},
+		{in: map[string]string{LocalityAnnotation: "nearest"}, valid: false},
+		{in: map[string]string{ConsistentHashAnnotation: "useSourceIp"}, valid: false},
+		{in: map[string]string{HealthCheckAnnotation: "[]"}, valid: true},
+		{in: map[string]string{HealthCheckAnnotation: "healthz"}, valid: false},
+		{in: map[string]string{MaxRetriesAnnotation: "3"}, valid: true},
+		{in: map[string]string{MaxRetriesAnnotation: "0"}, valid: false},
+		{in: map[string]string{MaxRetriesAnnotation: "many"}, valid: false},
+	}
+	for _, c := range cases {
+		if got := ValidateDestinationPolicyAnnotations(c.in); (got == nil) != c.valid {
+			t.Errorf("Failed: got valid=%t but wanted valid=%v: %v for %v", got == nil, c.valid, got, c.in)
+		}
+	}
+}
+
 func TestValidatePort(t *testing.T) {
 	ports := map[int]bool{
 		0:     false,
@@ -0,0 +1,191 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aggregate implements an aggregator for service registries. The
+// aggregate controller merges the service catalogs of several registries
+// (e.g. Kubernetes, Consul, Eureka) and fans out the event handler
+// registrations to every underlying registry controller.
+//
+// Hostname collisions are resolved by registry order: a service hostname
+// belongs to the first registry that declares it, and the instances of the
+// service are retrieved from that registry only.
+package aggregate
+
+import (
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform"
+)
+
+// Registry specifies the collection of service registry related interfaces
+type Registry struct {
+	// Name is the platform of the service registry
+	Name platform.ServiceRegistry
+
+	model.Controller
+	model.ServiceDiscovery
+
+	// ServiceAccounts is optional; registries without Istio service
+	// accounts leave it nil
+	model.ServiceAccounts
+}
+
+// Controller aggregates data across different registries and monitors for changes
+type Controller struct {
+	registries []Registry
+}
+
+// NewController creates a new aggregate controller
+func NewController() *Controller {
+	return &Controller{
+		registries: make([]Registry, 0),
+	}
+}
+
+// AddRegistry adds registries into the aggregated controller. The order in
+// which the registries are added determines the precedence for colliding
+// service hostnames.
+func (c *Controller) AddRegistry(registry Registry) {
+	c.registries = append(c.registries, registry)
+}
+
+// Services lists services from all platforms. A hostname declared by more
+// than one registry is taken from the first registry declaring it.
+func (c *Controller) Services() []*model.Service {
+	hosts := make(map[string]platform.ServiceRegistry)
+	services := make([]*model.Service, 0)
+	for _, r := range c.registries {
+		for _, service := range r.Services() {
+			if owner, exists := hosts[service.Hostname]; exists {
+				glog.V(2).Infof("service %q from registry %s is shadowed by registry %s",
+					service.Hostname, r.Name, owner)
+				continue
+			}
+			hosts[service.Hostname] = r.Name
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// GetService retrieves a service by hostname if exists
+func (c *Controller) GetService(hostname string) (*model.Service, bool) {
+	for _, r := range c.registries {
+		if service, exists := r.GetService(hostname); exists {
+			return service, true
+		}
+	}
+	return nil, false
+}
+
+// owner returns the first registry declaring the service hostname
+func (c *Controller) owner(hostname string) (*Registry, bool) {
+	for i := range c.registries {
+		if _, exists := c.registries[i].GetService(hostname); exists {
+			return &c.registries[i], true
+		}
+	}
+	return nil, false
+}
+
+// ManagementPorts retrieves set of health check ports by instance IP.
+// Return on the first hit.
+func (c *Controller) ManagementPorts(addr string) model.PortList {
+	for _, r := range c.registries {
+		if portList := r.ManagementPorts(addr); portList != nil {
+			return portList
+		}
+	}
+	return nil
+}
+
+// Instances retrieves instances for a service and its ports that match
+// any of the supplied tags. The instances are taken from the registry that
+// owns the service hostname.
+func (c *Controller) Instances(hostname string, ports []string,
+	tags model.TagsList) []*model.ServiceInstance {
+	if r, ok := c.owner(hostname); ok {
+		return r.Instances(hostname, ports, tags)
+	}
+	return nil
+}
+
+// HostInstances lists service instances for a given set of IPv4 addresses
+// across all registries. Instances of a service hostname that is owned by an
+// earlier registry are dropped.
+func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	out := make([]*model.ServiceInstance, 0)
+	owners := make(map[string]platform.ServiceRegistry)
+	for _, r := range c.registries {
+		for _, instance := range r.HostInstances(addrs) {
+			hostname := instance.Service.Hostname
+			if _, exists := owners[hostname]; !exists {
+				if owner, ok := c.owner(hostname); ok {
+					owners[hostname] = owner.Name
+				} else {
+					owners[hostname] = r.Name
+				}
+			}
+			if owners[hostname] != r.Name {
+				continue
+			}
+			out = append(out, instance)
+		}
+	}
+	return out
+}
+
+// GetIstioServiceAccounts implements model.ServiceAccounts operation. The
+// service accounts are taken from the registry that owns the service
+// hostname.
+func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	if r, ok := c.owner(hostname); ok && r.ServiceAccounts != nil {
+		return r.GetIstioServiceAccounts(hostname, ports)
+	}
+	return nil
+}
+
+// Run starts all the controllers
+func (c *Controller) Run(stop <-chan struct{}) {
+	for _, r := range c.registries {
+		go r.Run(stop)
+	}
+
+	<-stop
+	glog.V(2).Info("Registry Aggregator terminated")
+}
+
+// AppendServiceHandler implements a service catalog operation
+func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) error {
+	var errs error
+	for _, r := range c.registries {
+		if err := r.AppendServiceHandler(f); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, string(r.Name)+" registry:"))
+		}
+	}
+	return errs
+}
+
+// AppendInstanceHandler implements a service instance catalog operation
+func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	var errs error
+	for _, r := range c.registries {
+		if err := r.AppendInstanceHandler(f); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, string(r.Name)+" registry:"))
+		}
+	}
+	return errs
+}
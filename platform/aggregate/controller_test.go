@@ -0,0 +1,174 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"errors"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform"
+	"istio.io/pilot/test/mock"
+)
+
+var (
+	consulHello = mock.MakeService(mock.HelloService.Hostname, "10.4.0.0")
+	consulOther = mock.MakeService("other.service.consul", "10.5.0.0")
+)
+
+type fakeController struct {
+	serviceHandlers  int
+	instanceHandlers int
+	err              error
+}
+
+func (fc *fakeController) AppendServiceHandler(func(*model.Service, model.Event)) error {
+	fc.serviceHandlers++
+	return fc.err
+}
+
+func (fc *fakeController) AppendInstanceHandler(func(*model.ServiceInstance, model.Event)) error {
+	fc.instanceHandlers++
+	return fc.err
+}
+
+func (fc *fakeController) Run(<-chan struct{}) {}
+
+func buildController(kube, consul *fakeController) *Controller {
+	kubeDiscovery := mock.NewDiscovery(map[string]*model.Service{
+		mock.HelloService.Hostname: mock.HelloService,
+		mock.WorldService.Hostname: mock.WorldService,
+	}, 2)
+	consulDiscovery := mock.NewDiscovery(map[string]*model.Service{
+		consulHello.Hostname: consulHello,
+		consulOther.Hostname: consulOther,
+	}, 1)
+
+	ctl := NewController()
+	ctl.AddRegistry(Registry{
+		Name:             platform.KubernetesRegistry,
+		Controller:       kube,
+		ServiceDiscovery: kubeDiscovery,
+		ServiceAccounts:  kubeDiscovery,
+	})
+	ctl.AddRegistry(Registry{
+		Name:             platform.ConsulRegistry,
+		Controller:       consul,
+		ServiceDiscovery: consulDiscovery,
+	})
+	return ctl
+}
+
+func TestServices(t *testing.T) {
+	ctl := buildController(&fakeController{}, &fakeController{})
+
+	services := ctl.Services()
+	if len(services) != 3 {
+		t.Fatalf("Services() => got %d services, want 3", len(services))
+	}
+	for _, service := range services {
+		if service.Hostname == mock.HelloService.Hostname && service != mock.HelloService {
+			t.Errorf("Services() => got %v, want the service from the first registry", service)
+		}
+	}
+
+	if service, exists := ctl.GetService(mock.HelloService.Hostname); !exists || service != mock.HelloService {
+		t.Errorf("GetService(%q) => got %v, want %v", mock.HelloService.Hostname, service, mock.HelloService)
+	}
+	if service, exists := ctl.GetService(consulOther.Hostname); !exists || service != consulOther {
+		t.Errorf("GetService(%q) => got %v, want %v", consulOther.Hostname, service, consulOther)
+	}
+	if _, exists := ctl.GetService("missing.service.consul"); exists {
+		t.Error("GetService(missing) => got a service")
+	}
+}
+
+func TestInstances(t *testing.T) {
+	ctl := buildController(&fakeController{}, &fakeController{})
+
+	instances := ctl.Instances(mock.HelloService.Hostname, []string{"http"}, nil)
+	if len(instances) != 2 {
+		t.Fatalf("Instances(hello) => got %d instances, want 2", len(instances))
+	}
+	for _, instance := range instances {
+		if instance.Service != mock.HelloService {
+			t.Errorf("Instances(hello) => got instance of %v, want the service from the first registry",
+				instance.Service)
+		}
+	}
+
+	if instances = ctl.Instances(consulOther.Hostname, []string{"http"}, nil); len(instances) != 1 {
+		t.Errorf("Instances(other) => got %d instances, want 1", len(instances))
+	}
+	if instances = ctl.Instances("missing.service.consul", []string{"http"}, nil); len(instances) != 0 {
+		t.Errorf("Instances(missing) => got %d instances, want 0", len(instances))
+	}
+}
+
+func TestHostInstances(t *testing.T) {
+	ctl := buildController(&fakeController{}, &fakeController{})
+
+	shadowed := mock.MakeIP(consulHello, 0)
+	instances := ctl.HostInstances(map[string]bool{
+		mock.MakeIP(mock.HelloService, 0): true,
+		mock.MakeIP(consulOther, 0):       true,
+		shadowed:                          true,
+	})
+	if len(instances) != 6 {
+		t.Errorf("HostInstances() => got %d instances, want 6", len(instances))
+	}
+	for _, instance := range instances {
+		if instance.Endpoint.Address == shadowed {
+			t.Errorf("HostInstances() => got shadowed instance %v", instance)
+		}
+	}
+}
+
+func TestServiceAccounts(t *testing.T) {
+	ctl := buildController(&fakeController{}, &fakeController{})
+
+	if accounts := ctl.GetIstioServiceAccounts(mock.WorldService.Hostname, []string{"http"}); len(accounts) != 2 {
+		t.Errorf("GetIstioServiceAccounts(world) => got %v, want 2 accounts", accounts)
+	}
+	if accounts := ctl.GetIstioServiceAccounts(consulOther.Hostname, []string{"http"}); accounts != nil {
+		t.Errorf("GetIstioServiceAccounts(other) => got %v, want none", accounts)
+	}
+}
+
+func TestManagementPorts(t *testing.T) {
+	ctl := buildController(&fakeController{}, &fakeController{})
+	if ports := ctl.ManagementPorts(mock.MakeIP(mock.HelloService, 0)); len(ports) != 2 {
+		t.Errorf("ManagementPorts() => got %v, want 2 ports", ports)
+	}
+}
+
+func TestAppendHandlers(t *testing.T) {
+	kube := &fakeController{}
+	consul := &fakeController{err: errors.New("unsupported")}
+	ctl := buildController(kube, consul)
+
+	if err := ctl.AppendServiceHandler(func(*model.Service, model.Event) {}); err == nil {
+		t.Error("AppendServiceHandler() => got no error")
+	}
+	if err := ctl.AppendInstanceHandler(func(*model.ServiceInstance, model.Event) {}); err == nil {
+		t.Error("AppendInstanceHandler() => got no error")
+	}
+	for _, fc := range []*fakeController{kube, consul} {
+		if fc.serviceHandlers != 1 || fc.instanceHandlers != 1 {
+			t.Errorf("handlers => got %d service and %d instance handlers, want 1 each",
+				fc.serviceHandlers, fc.instanceHandlers)
+		}
+	}
+}
@@ -93,6 +93,28 @@ func (c *Controller) getCatalogService(name string, q *api.QueryOptions) []*api.
 	return endpoints
 }
 
+// getHealthStatus returns the health status of the service instances keyed by
+// the node and the service ID. Instances with a critical health check are unhealthy.
+func (c *Controller) getHealthStatus(name string) map[string]model.HealthStatus {
+	out := make(map[string]model.HealthStatus)
+	checks, _, err := c.client.Health().Checks(name, nil)
+	if err != nil {
+		glog.Warningf("Could not retrieve health checks from consul: %v", err)
+		return out
+	}
+
+	for _, check := range checks {
+		if check.Status == api.HealthCritical {
+			out[healthKey(check.Node, check.ServiceID)] = model.Unhealthy
+		}
+	}
+	return out
+}
+
+func healthKey(node, serviceID string) string {
+	return node + "/" + serviceID
+}
+
 // ManagementPorts retries set of health check ports by instance IP.
 // This does not apply to Consul service registry, as Consul does not
 // manage the service instances. In future, when we integrate Nomad, we
@@ -117,11 +139,13 @@ func (c *Controller) Instances(hostname string, ports []string, tags model.TagsL
 	}
 
 	endpoints := c.getCatalogService(name, nil)
+	health := c.getHealthStatus(name)
 
 	instances := []*model.ServiceInstance{}
 	for _, endpoint := range endpoints {
 		instance := convertInstance(endpoint)
 		if tags.HasSubsetOf(instance.Tags) && portMatch(instance, portMap) {
+			instance.Health = health[healthKey(endpoint.Node, endpoint.ServiceID)]
 			instances = append(instances, instance)
 		}
 	}
@@ -148,9 +172,15 @@ func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstan
 	out := make([]*model.ServiceInstance, 0)
 	for svcName := range data {
 		endpoints := c.getCatalogService(svcName, nil)
+		var health map[string]model.HealthStatus
 		for _, endpoint := range endpoints {
 			if addrs[endpoint.ServiceAddress] {
-				out = append(out, convertInstance(endpoint))
+				if health == nil {
+					health = c.getHealthStatus(svcName)
+				}
+				instance := convertInstance(endpoint)
+				instance.Health = health[healthKey(endpoint.Node, endpoint.ServiceID)]
+				out = append(out, instance)
 			}
 		}
 	}
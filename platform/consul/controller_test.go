@@ -73,6 +73,20 @@ var (
 			NodeMeta:       map[string]string{protocolTagName: "tcp"},
 		},
 	}
+	reviewsChecks = api.HealthChecks{
+		{
+			Node:      "istio",
+			CheckID:   "service:333-333-333",
+			Status:    api.HealthWarning,
+			ServiceID: "333-333-333",
+		},
+		{
+			Node:      "istio",
+			CheckID:   "service:444-444-444",
+			Status:    api.HealthCritical,
+			ServiceID: "444-444-444",
+		},
+	}
 )
 
 func newServer() *httptest.Server {
@@ -85,6 +99,10 @@ func newServer() *httptest.Server {
 			data, _ := json.Marshal(&reviews)
 			w.Header().Set("Content-Type", "application/json")
 			fmt.Fprintln(w, string(data))
+		} else if r.URL.Path == "/v1/health/checks/reviews" {
+			data, _ := json.Marshal(&reviewsChecks)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintln(w, string(data))
 		} else if r.URL.Path == "/v1/catalog/service/productpage" {
 			data, _ := json.Marshal(&productpage)
 			w.Header().Set("Content-Type", "application/json")
@@ -115,6 +133,16 @@ func TestInstances(t *testing.T) {
 		}
 	}
 
+	for _, inst := range instances {
+		want := model.Healthy
+		if inst.Endpoint.Address == "172.19.0.8" {
+			want = model.Unhealthy
+		}
+		if inst.Health != want {
+			t.Errorf("Instances() returned wrong health for %s => %v, want %v", inst.Endpoint.Address, inst.Health, want)
+		}
+	}
+
 	filterTagKey := "version"
 	filterTagVal := "v3"
 	instances = controller.Instances(hostname, []string{}, model.TagsList{
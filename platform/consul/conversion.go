@@ -16,6 +16,7 @@ package consul
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/golang/glog"
@@ -82,6 +83,7 @@ func convertService(endpoints []*api.CatalogService) *model.Service {
 	for _, port := range ports {
 		svcPorts = append(svcPorts, port)
 	}
+	sort.Slice(svcPorts, func(i, j int) bool { return svcPorts[i].Port < svcPorts[j].Port })
 
 	out := &model.Service{
 		Hostname:     serviceHostname(name),
@@ -25,7 +25,8 @@ import (
 	"istio.io/pilot/model"
 )
 
-type consulServices map[string][]string
+// consulCatalog maps a service name to its catalog records sorted by ID
+type consulCatalog map[string]consulServiceInstances
 type consulServiceInstances []*api.CatalogService
 
 // Monitor handles service and instance changes
@@ -42,23 +43,24 @@ type InstanceHandler func(instance *api.CatalogService, event model.Event) error
 type ServiceHandler func(instances []*api.CatalogService, event model.Event) error
 
 type consulMonitor struct {
-	discovery            *api.Client
-	instanceCachedRecord consulServiceInstances
-	serviceCachedRecord  consulServices
-	instanceHandlers     []InstanceHandler
-	serviceHandlers      []ServiceHandler
-	period               time.Duration
+	discovery        *api.Client
+	cachedRecord     consulCatalog
+	lastIndex        uint64
+	instanceHandlers []InstanceHandler
+	serviceHandlers  []ServiceHandler
+	period           time.Duration
 }
 
-// NewConsulMonitor polls for changes in Consul Services and CatalogServices
+// NewConsulMonitor watches for changes in Consul Services and CatalogServices.
+// The monitor uses Consul blocking queries that return as soon as the catalog
+// changes or after the period elapses.
 func NewConsulMonitor(client *api.Client, period time.Duration) Monitor {
 	return &consulMonitor{
-		discovery:            client,
-		period:               period,
-		instanceCachedRecord: make(consulServiceInstances, 0),
-		serviceCachedRecord:  make(consulServices),
-		instanceHandlers:     make([]InstanceHandler, 0),
-		serviceHandlers:      make([]ServiceHandler, 0),
+		discovery:        client,
+		period:           period,
+		cachedRecord:     make(consulCatalog),
+		instanceHandlers: make([]InstanceHandler, 0),
+		serviceHandlers:  make([]ServiceHandler, 0),
 	}
 }
 
@@ -67,86 +69,144 @@ func (m *consulMonitor) Start(stop <-chan struct{}) {
 }
 
 func (m *consulMonitor) run(stop <-chan struct{}) {
-	ticker := time.NewTicker(m.period)
 	for {
 		select {
 		case <-stop:
-			ticker.Stop()
 			return
-		case <-ticker.C:
-			m.updateServiceRecord()
-			m.updateInstanceRecord()
+		default:
 		}
-	}
-}
 
-func (m *consulMonitor) updateServiceRecord() {
-	svcs, _, err := m.discovery.Catalog().Services(nil)
-	if err != nil {
-		glog.Warningf("Could not fetch services: %v", err)
-		return
-	}
-	newRecord := consulServices(svcs)
-	if !reflect.DeepEqual(newRecord, m.serviceCachedRecord) {
-		// This is only a work-around solution currently
-		// Since Handler functions generally act as a refresher
-		// regardless of the input, thus passing in meaningless
-		// input should make functionalities work
-		//TODO
-		obj := []*api.CatalogService{}
-		var event model.Event
-		for _, f := range m.serviceHandlers {
-			go func(handler ServiceHandler) {
-				if err := handler(obj, event); err != nil {
-					glog.Warningf("Error executing service handler function: %v", err)
-				}
-			}(f)
+		if err := m.updateRecord(); err != nil {
+			glog.Warningf("Could not fetch services: %v", err)
+			// back off before retrying a failing Consul agent
+			select {
+			case <-stop:
+				return
+			case <-time.After(m.period):
+			}
 		}
-		m.serviceCachedRecord = newRecord
 	}
 }
 
-func (m *consulMonitor) updateInstanceRecord() {
-	svcs, _, err := m.discovery.Catalog().Services(nil)
+// updateRecord waits for a change of the Consul catalog index and emits the
+// difference between the cached and the new catalog records
+func (m *consulMonitor) updateRecord() error {
+	svcs, meta, err := m.discovery.Catalog().Services(&api.QueryOptions{
+		WaitIndex: m.lastIndex,
+		WaitTime:  m.period,
+	})
 	if err != nil {
-		glog.Warningf("Could not fetch instances: %v", err)
-		return
+		return err
 	}
-	for _, tags := range svcs {
-		sort.Strings(tags)
+
+	// the blocking query timed out without changes
+	if meta.LastIndex == m.lastIndex {
+		return nil
 	}
 
-	instances := make([]*api.CatalogService, 0)
+	// reset the index if it goes backwards (e.g. Consul state restore)
+	if meta.LastIndex < m.lastIndex {
+		m.lastIndex = 0
+	} else {
+		m.lastIndex = meta.LastIndex
+	}
+
+	newRecord := make(consulCatalog, len(svcs))
 	for name := range svcs {
 		endpoints, _, err := m.discovery.Catalog().Service(name, "", nil)
 		if err != nil {
-			glog.Warningf("Could not retrieve service catalogue from consul: %v", err)
+			// retry on the next change with a fresh index
+			m.lastIndex = 0
+			return err
+		}
+		if len(endpoints) == 0 {
+			continue
+		}
+		instances := consulServiceInstances(endpoints)
+		sort.Sort(instances)
+		newRecord[name] = instances
+	}
+
+	m.notify(m.cachedRecord, newRecord)
+	m.cachedRecord = newRecord
+	return nil
+}
+
+// notify computes the difference between two catalog records and invokes the
+// handlers for every added, updated or deleted service and instance
+func (m *consulMonitor) notify(oldRecord, newRecord consulCatalog) {
+	for name, instances := range newRecord {
+		oldInstances, exists := oldRecord[name]
+		if !exists {
+			m.notifyService(instances, model.EventAdd)
+			for _, instance := range instances {
+				m.notifyInstance(instance, model.EventAdd)
+			}
 			continue
 		}
-		instances = append(instances, endpoints...)
+
+		if !reflect.DeepEqual(convertService(oldInstances), convertService(instances)) {
+			m.notifyService(instances, model.EventUpdate)
+		}
+		m.notifyInstances(oldInstances, instances)
 	}
 
-	newRecord := consulServiceInstances(instances)
-	sort.Sort(newRecord)
-	if !reflect.DeepEqual(newRecord, m.instanceCachedRecord) {
-		// This is only a work-around solution currently
-		// Since Handler functions generally act as a refresher
-		// regardless of the input, thus passing in meaningless
-		// input should make functionalities work
-		// TODO
-		obj := &api.CatalogService{}
-		var event model.Event
-		for _, f := range m.instanceHandlers {
-			go func(handler InstanceHandler) {
-				if err := handler(obj, event); err != nil {
-					glog.Warningf("Error executing instance handler function: %v", err)
-				}
-			}(f)
+	for name, instances := range oldRecord {
+		if _, exists := newRecord[name]; !exists {
+			for _, instance := range instances {
+				m.notifyInstance(instance, model.EventDelete)
+			}
+			m.notifyService(instances, model.EventDelete)
 		}
-		m.instanceCachedRecord = newRecord
 	}
 }
 
+// notifyInstances emits instance events for two catalog records of a service
+func (m *consulMonitor) notifyInstances(oldInstances, newInstances consulServiceInstances) {
+	oldByKey := make(map[string]*api.CatalogService, len(oldInstances))
+	for _, instance := range oldInstances {
+		oldByKey[instanceKey(instance)] = instance
+	}
+
+	newByKey := make(map[string]bool, len(newInstances))
+	for _, instance := range newInstances {
+		key := instanceKey(instance)
+		newByKey[key] = true
+		if old, exists := oldByKey[key]; !exists {
+			m.notifyInstance(instance, model.EventAdd)
+		} else if !reflect.DeepEqual(convertInstance(old), convertInstance(instance)) {
+			m.notifyInstance(instance, model.EventUpdate)
+		}
+	}
+
+	for _, instance := range oldInstances {
+		if !newByKey[instanceKey(instance)] {
+			m.notifyInstance(instance, model.EventDelete)
+		}
+	}
+}
+
+func (m *consulMonitor) notifyService(instances []*api.CatalogService, event model.Event) {
+	for _, handler := range m.serviceHandlers {
+		if err := handler(instances, event); err != nil {
+			glog.Warningf("Error executing service handler function: %v", err)
+		}
+	}
+}
+
+func (m *consulMonitor) notifyInstance(instance *api.CatalogService, event model.Event) {
+	for _, handler := range m.instanceHandlers {
+		if err := handler(instance, event); err != nil {
+			glog.Warningf("Error executing instance handler function: %v", err)
+		}
+	}
+}
+
+// instanceKey identifies a catalog record; service IDs are unique per node
+func instanceKey(instance *api.CatalogService) string {
+	return instance.Node + "/" + instance.ServiceID
+}
+
 func (m *consulMonitor) AppendServiceHandler(h ServiceHandler) {
 	m.serviceHandlers = append(m.serviceHandlers, h)
 }
@@ -167,5 +227,5 @@ func (a consulServiceInstances) Swap(i, j int) {
 
 // Less i and j
 func (a consulServiceInstances) Less(i, j int) bool {
-	return a[i].ID < a[j].ID
+	return instanceKey(a[i]) < instanceKey(a[j])
 }
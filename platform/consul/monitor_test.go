@@ -0,0 +1,204 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/util"
+)
+
+type event struct {
+	name  string
+	event model.Event
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	events []event
+}
+
+func (r *recorder) services(instances []*api.CatalogService, ev model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event{name: "service/" + instances[0].ServiceName, event: ev})
+	return nil
+}
+
+func (r *recorder) instances(instance *api.CatalogService, ev model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event{name: "instance/" + instance.ServiceID, event: ev})
+	return nil
+}
+
+func (r *recorder) count(ev event) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e == ev {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *recorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+func newRecordingMonitor(client *api.Client) (*consulMonitor, *recorder) {
+	m := NewConsulMonitor(client, 100*time.Millisecond).(*consulMonitor)
+	r := &recorder{}
+	m.AppendServiceHandler(r.services)
+	m.AppendInstanceHandler(r.instances)
+	return m, r
+}
+
+func TestMonitorNotify(t *testing.T) {
+	m, r := newRecordingMonitor(nil)
+
+	m.notify(consulCatalog{}, consulCatalog{"reviews": reviews})
+	if len(r.events) != 4 ||
+		r.count(event{"service/reviews", model.EventAdd}) != 1 ||
+		r.count(event{"instance/222-222-222", model.EventAdd}) != 1 {
+		t.Errorf("notify(add) => got %v", r.events)
+	}
+
+	// drop v1, change the address of v2 and add a new port to the service
+	v2 := *reviews[1]
+	v2.ServiceAddress = "172.19.0.17"
+	v4 := *reviews[2]
+	v4.ServiceID = "555-555-555"
+	v4.ServicePort = 9081
+	updated := consulServiceInstances{&v2, reviews[2], &v4}
+
+	r.reset()
+	m.notify(consulCatalog{"reviews": reviews}, consulCatalog{"reviews": updated})
+	expected := []event{
+		{"service/reviews", model.EventUpdate},
+		{"instance/222-222-222", model.EventDelete},
+		{"instance/333-333-333", model.EventUpdate},
+		{"instance/555-555-555", model.EventAdd},
+	}
+	if len(r.events) != len(expected) {
+		t.Errorf("notify(update) => got %v, want %v", r.events, expected)
+	}
+	for _, ev := range expected {
+		if r.count(ev) != 1 {
+			t.Errorf("notify(update) => missing %v in %v", ev, r.events)
+		}
+	}
+
+	r.reset()
+	m.notify(consulCatalog{"reviews": updated}, consulCatalog{"reviews": updated})
+	if len(r.events) != 0 {
+		t.Errorf("notify(unchanged) => got %v, want no events", r.events)
+	}
+
+	r.reset()
+	m.notify(consulCatalog{"reviews": updated}, consulCatalog{})
+	if len(r.events) != 4 || r.count(event{"service/reviews", model.EventDelete}) != 1 ||
+		r.count(event{"instance/555-555-555", model.EventDelete}) != 1 {
+		t.Errorf("notify(delete) => got %v", r.events)
+	}
+}
+
+// indexedServer mimics Consul blocking queries on the catalog
+type indexedServer struct {
+	mu       sync.Mutex
+	index    uint64
+	services map[string][]string
+	catalog  map[string][]*api.CatalogService
+}
+
+func (s *indexedServer) set(index uint64, services map[string][]string, catalog map[string][]*api.CatalogService) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index, s.services, s.catalog = index, services, catalog
+}
+
+func (s *indexedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	index := s.index
+	s.mu.Unlock()
+	if wait := r.URL.Query().Get("index"); wait == fmt.Sprint(index) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []byte
+	switch {
+	case r.URL.Path == "/v1/catalog/services":
+		data, _ = json.Marshal(&s.services)
+	case strings.HasPrefix(r.URL.Path, "/v1/catalog/service/"):
+		name := strings.TrimPrefix(r.URL.Path, "/v1/catalog/service/")
+		data, _ = json.Marshal(s.catalog[name])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", fmt.Sprint(s.index))
+	fmt.Fprintln(w, string(data))
+}
+
+func TestMonitorBlockingQueries(t *testing.T) {
+	server := &indexedServer{}
+	server.set(1, services, map[string][]*api.CatalogService{
+		"productpage": productpage,
+		"reviews":     reviews,
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	conf := api.DefaultConfig()
+	conf.Address = ts.URL
+	client, err := api.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, r := newRecordingMonitor(client)
+	stop := make(chan struct{})
+	defer close(stop)
+	go m.Start(stop)
+
+	util.Eventually(func() bool { return r.count(event{"instance/444-444-444", model.EventAdd}) == 1 }, t)
+	util.Eventually(func() bool { return r.count(event{"service/productpage", model.EventAdd}) == 1 }, t)
+
+	server.set(2, map[string][]string{"reviews": services["reviews"]}, map[string][]*api.CatalogService{
+		"reviews": reviews,
+	})
+	util.Eventually(func() bool { return r.count(event{"service/productpage", model.EventDelete}) == 1 }, t)
+	util.Eventually(func() bool { return r.count(event{"instance/111-111-111", model.EventDelete}) == 1 }, t)
+	if n := r.count(event{"service/reviews", model.EventAdd}); n != 1 {
+		t.Errorf("got %d add events for reviews, want 1", n)
+	}
+}
@@ -15,12 +15,18 @@
 package eureka
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"sync"
 	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
 )
 
 type application struct {
@@ -51,28 +57,43 @@ type Client interface {
 	Applications() ([]*application, error)
 }
 
-// Minimal client for Eureka server's REST APIs.
-// TODO: support multiple Eureka servers
-// TODO: caching
+// Minimal client for Eureka server's REST APIs. The client fails over across
+// the Eureka servers in order and keeps a local copy of the registry, which is
+// updated with the recently changed instances after the initial full fetch.
 // TODO: Eureka v3 support
 type client struct {
 	client http.Client
-	url    string
+	urls   []string
+
+	// mutex protects the fields below
+	mutex sync.Mutex
+	// current is the index of the last Eureka server that responded
+	current int
+	// cache maps application names to instance IDs to instances
+	cache map[string]map[string]*instance
 }
 
-// NewClient instantiates a new Eureka client
-func NewClient(url string) Client {
+// NewClient instantiates a new Eureka client for a list of Eureka server URLs
+func NewClient(urls ...string) Client {
 	return &client{
 		client: http.Client{Timeout: 30 * time.Second},
-		url:    url,
+		urls:   urls,
 	}
 }
 
 const statusUp = "UP"
 
 const (
-	basePath = "/eureka/v2"
-	appsPath = basePath + "/apps"
+	basePath  = "/eureka/v2"
+	appsPath  = basePath + "/apps"
+	deltaPath = appsPath + "/delta"
+)
+
+// delta action types
+const (
+	actionAdded    = "ADDED"
+	actionModified = "MODIFIED"
+	actionDeleted  = "DELETED"
 )
 
 type getApplications struct {
@@ -80,36 +101,189 @@ type getApplications struct {
 }
 
 type applications struct {
-	Applications []*application `json:"application"`
+	Hashcode     string               `json:"apps__hashcode"`
+	Applications []*applicationRecord `json:"application"`
+}
+
+type applicationRecord struct {
+	Name      string            `json:"name"`
+	Instances []*instanceRecord `json:"instance"`
+}
+
+// instanceRecord is an instance in the Eureka registry or delta responses
+type instanceRecord struct {
+	instance
+	ID         string `json:"instanceId"`
+	ActionType string `json:"actionType"`
+}
+
+// key identifies an instance within an application
+func (r *instanceRecord) key() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return fmt.Sprintf("%s-%s-%d", r.Hostname, r.IPAddress, r.Port.Port)
 }
 
 func (c *client) Applications() ([]*application, error) {
-	req, err := http.NewRequest("GET", c.url+appsPath, nil)
-	if err != nil {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cache != nil {
+		err := c.fetchDelta()
+		if err == nil {
+			return c.applications(), nil
+		}
+		glog.V(2).Infof("Eureka delta fetch failed, fetching all applications: %v", err)
+	}
+
+	if err := c.fetchAll(); err != nil {
 		return nil, err
 	}
+	return c.applications(), nil
+}
+
+// fetchAll replaces the local copy of the registry with all applications
+func (c *client) fetchAll() error {
+	var apps getApplications
+	if err := c.get(appsPath, &apps); err != nil {
+		c.cache = nil
+		return err
+	}
+
+	c.cache = make(map[string]map[string]*instance)
+	for _, app := range apps.Applications.Applications {
+		for _, record := range app.Instances {
+			c.put(app.Name, record)
+		}
+	}
+	return nil
+}
+
+// fetchDelta applies the recently changed instances to the local copy of the
+// registry and reconciles it against the registry hash code
+func (c *client) fetchDelta() error {
+	var delta getApplications
+	if err := c.get(deltaPath, &delta); err != nil {
+		return err
+	}
+
+	for _, app := range delta.Applications.Applications {
+		for _, record := range app.Instances {
+			switch record.ActionType {
+			case actionAdded, actionModified:
+				c.put(app.Name, record)
+			case actionDeleted:
+				if instances, exists := c.cache[app.Name]; exists {
+					delete(instances, record.key())
+					if len(instances) == 0 {
+						delete(c.cache, app.Name)
+					}
+				}
+			default:
+				glog.Warningf("unknown Eureka delta action type %q", record.ActionType)
+			}
+		}
+	}
+
+	if hashcode := c.hashcode(); delta.Applications.Hashcode != "" && delta.Applications.Hashcode != hashcode {
+		return fmt.Errorf("registry hash code mismatch: got %q, want %q", hashcode, delta.Applications.Hashcode)
+	}
+	return nil
+}
+
+func (c *client) put(app string, record *instanceRecord) {
+	instances, exists := c.cache[app]
+	if !exists {
+		instances = make(map[string]*instance)
+		c.cache[app] = instances
+	}
+	inst := record.instance
+	instances[record.key()] = &inst
+}
+
+// applications returns a sorted copy of the local registry
+func (c *client) applications() []*application {
+	out := make([]*application, 0, len(c.cache))
+	for name, instances := range c.cache {
+		app := &application{
+			Name:      name,
+			Instances: make([]*instance, 0, len(instances)),
+		}
+		for _, inst := range instances {
+			app.Instances = append(app.Instances, inst)
+		}
+		out = append(out, app)
+	}
+	sortApplications(out)
+	return out
+}
+
+// hashcode computes the Eureka registry hash code of the local registry: the
+// instance count per status, ordered by status, e.g. "DOWN_1_UP_3_"
+func (c *client) hashcode() string {
+	counts := make(map[string]int)
+	for _, instances := range c.cache {
+		for _, inst := range instances {
+			counts[inst.Status]++
+		}
+	}
+
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	var buf bytes.Buffer
+	for _, status := range statuses {
+		fmt.Fprintf(&buf, "%s_%d_", status, counts[status])
+	}
+	return buf.String()
+}
+
+// get fetches a path from the Eureka servers, starting with the server that
+// responded last and failing over to the next server on an error
+func (c *client) get(path string, out interface{}) error {
+	if len(c.urls) == 0 {
+		return errors.New("no Eureka server URLs")
+	}
+
+	var errs error
+	for i := 0; i < len(c.urls); i++ {
+		current := (c.current + i) % len(c.urls)
+		err := c.getURL(c.urls[current]+path, out)
+		if err == nil {
+			c.current = current
+			return nil
+		}
+		errs = multierror.Append(errs, err)
+	}
+	return errs
+}
+
+func (c *client) getURL(url string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Accept", "application/json")
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close() // nolint: errcheck
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code from Eureka server: %v", resp.Status)
+		return fmt.Errorf("unexpected status code from Eureka server: %v", resp.Status)
 	}
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
-	}
-
-	var apps getApplications
-	if err = json.Unmarshal(data, &apps); err != nil {
-		return nil, err
+		return err
 	}
 
-	return apps.Applications.Applications, nil
+	return json.Unmarshal(data, out)
 }
 
 func sortApplications(apps []*application) {
@@ -15,9 +15,11 @@
 package eureka
 
 import (
+	"bytes"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 )
 
@@ -94,3 +96,104 @@ func TestClient(t *testing.T) {
 		ts.Close()
 	}
 }
+
+func TestClientFailover(t *testing.T) {
+	data := readFile(t, "testdata/eureka-apps.json")
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data) // nolint: errcheck
+	}))
+	defer up.Close()
+
+	cl := NewClient(down.URL, up.URL)
+	apps, err := cl.Applications()
+	if err != nil {
+		t.Fatalf("unexpected error retrieving Eureka applications: %v", err)
+	}
+	if len(apps) != 2 {
+		t.Errorf("got %d applications, want 2", len(apps))
+	}
+
+	if _, err = NewClient(down.URL).Applications(); err == nil {
+		t.Error("expected error, got nil when all Eureka servers are down")
+	}
+}
+
+func TestClientDelta(t *testing.T) {
+	full := readFile(t, "testdata/eureka-apps.json")
+	delta := readFile(t, "testdata/eureka-delta.json")
+	var fullFetches int32
+	var mismatch atomic.Value
+	mismatch.Store(false)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case appsPath:
+			atomic.AddInt32(&fullFetches, 1)
+			w.Write(full) // nolint: errcheck
+		case deltaPath:
+			if mismatch.Load().(bool) {
+				w.Write(bytes.Replace(delta, []byte("DOWN_1_UP_2_"), []byte("UP_5_"), 1)) // nolint: errcheck
+			} else {
+				w.Write(delta) // nolint: errcheck
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	cl := NewClient(ts.URL)
+	if _, err := cl.Applications(); err != nil {
+		t.Fatalf("unexpected error retrieving Eureka applications: %v", err)
+	}
+
+	apps, err := cl.Applications()
+	if err != nil {
+		t.Fatalf("unexpected error retrieving Eureka delta: %v", err)
+	}
+	if n := atomic.LoadInt32(&fullFetches); n != 1 {
+		t.Errorf("got %d full fetches, want 1", n)
+	}
+	down := makeInstance("foo.biz.local", "10.0.0.4", 8080, -1, metadata{protocolMetadata: "HTTP2"})
+	down.Status = "DOWN"
+	expected := []*application{
+		{
+			Name: appName("foo.bar.local"),
+			Instances: []*instance{
+				makeInstance("foo.bar.local", "10.0.0.1", 5000, 5443,
+					metadata{protocolMetadata: "HTTP"}),
+			},
+		},
+		{
+			Name: appName("foo.biz.local"),
+			Instances: []*instance{
+				makeInstance("foo.biz.local", "10.0.0.3", 8080, -1,
+					metadata{protocolMetadata: "HTTP2"}),
+				down,
+			},
+		},
+	}
+	if err = compare(t, apps, expected); err != nil {
+		t.Errorf("Eureka applications after delta do not match expected:\n%v", err)
+	}
+
+	// the delta is idempotent
+	if _, err = cl.Applications(); err != nil {
+		t.Fatalf("unexpected error retrieving Eureka delta: %v", err)
+	}
+	if n := atomic.LoadInt32(&fullFetches); n != 1 {
+		t.Errorf("got %d full fetches, want 1", n)
+	}
+
+	// the delta does not reconcile with the local registry
+	mismatch.Store(true)
+	if _, err = cl.Applications(); err != nil {
+		t.Fatalf("unexpected error retrieving Eureka applications: %v", err)
+	}
+	if n := atomic.LoadInt32(&fullFetches); n != 2 {
+		t.Errorf("got %d full fetches, want 2 after a hash code mismatch", n)
+	}
+}
@@ -15,6 +15,7 @@
 package eureka
 
 import (
+	"fmt"
 	"reflect"
 	"time"
 
@@ -53,7 +54,8 @@ func (c *controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.
 }
 
 func (c *controller) Run(stop <-chan struct{}) {
-	cachedApps := make([]*application, 0)
+	cachedServices := make(map[string]*model.Service)
+	cachedInstances := make(map[string]*model.ServiceInstance)
 	ticker := time.NewTicker(c.interval)
 	for {
 		select {
@@ -65,22 +67,66 @@ func (c *controller) Run(stop <-chan struct{}) {
 			}
 			sortApplications(apps)
 
-			if !reflect.DeepEqual(apps, cachedApps) {
-				cachedApps = apps
-				// TODO: feed with real events.
-				// The handlers are being fed dummy events. This is sufficient with simplistic
-				// handlers that invalidate the cache on any event but will not work with smarter
-				// handlers.
-				for _, h := range c.serviceHandlers {
-					go h(&model.Service{}, model.EventAdd)
-				}
-				for _, h := range c.instanceHandlers {
-					go h(&model.ServiceInstance{}, model.EventAdd)
-				}
+			services := convertServices(apps, nil)
+			instances := make(map[string]*model.ServiceInstance)
+			for _, instance := range convertServiceInstances(services, apps) {
+				instances[instanceKey(instance)] = instance
 			}
+
+			c.notify(cachedServices, services, cachedInstances, instances)
+			cachedServices, cachedInstances = services, instances
 		case <-stop:
 			ticker.Stop()
 			return
 		}
 	}
 }
+
+// notify emits the events for the difference between two snapshots of the
+// Eureka registry. Services are added before and deleted after their instances.
+func (c *controller) notify(oldServices, newServices map[string]*model.Service,
+	oldInstances, newInstances map[string]*model.ServiceInstance) {
+	for hostname, service := range newServices {
+		if old, exists := oldServices[hostname]; !exists {
+			c.notifyService(service, model.EventAdd)
+		} else if !reflect.DeepEqual(old, service) {
+			c.notifyService(service, model.EventUpdate)
+		}
+	}
+
+	for key, instance := range oldInstances {
+		if _, exists := newInstances[key]; !exists {
+			c.notifyInstance(instance, model.EventDelete)
+		}
+	}
+	for key, instance := range newInstances {
+		if old, exists := oldInstances[key]; !exists {
+			c.notifyInstance(instance, model.EventAdd)
+		} else if !reflect.DeepEqual(old, instance) {
+			c.notifyInstance(instance, model.EventUpdate)
+		}
+	}
+
+	for hostname, service := range oldServices {
+		if _, exists := newServices[hostname]; !exists {
+			c.notifyService(service, model.EventDelete)
+		}
+	}
+}
+
+func (c *controller) notifyService(service *model.Service, event model.Event) {
+	for _, h := range c.serviceHandlers {
+		h(service, event)
+	}
+}
+
+func (c *controller) notifyInstance(instance *model.ServiceInstance, event model.Event) {
+	for _, h := range c.instanceHandlers {
+		h(instance, event)
+	}
+}
+
+// instanceKey identifies a service instance by its service and endpoint
+func instanceKey(instance *model.ServiceInstance) string {
+	return fmt.Sprintf("%s|%s:%d", instance.Service.Hostname, instance.Endpoint.Address, instance.Endpoint.Port)
+}
@@ -98,7 +98,86 @@ func TestController(t *testing.T) {
 		},
 	})
 	time.Sleep(notifyThreshold)
+	if c := getCountAndReset(); c != 3 {
+		t.Errorf("got %d notifications from controller, want %d", c, 3)
+	}
+
+	cl.SetApplications([]*application{
+		{
+			Name: "APP",
+			Instances: []*instance{
+				makeInstance("hello.world.local", "10.0.0.1", 8080, -1, nil),
+			},
+		},
+	})
+	time.Sleep(notifyThreshold)
+	if c := getCountAndReset(); c != 1 {
+		t.Errorf("got %d notifications from controller, want %d", c, 1)
+	}
+
+	cl.SetApplications(nil)
+	time.Sleep(notifyThreshold)
 	if c := getCountAndReset(); c != 2 {
-		t.Errorf("got %d notifications from controller, want %d", count, 2)
+		t.Errorf("got %d notifications from controller, want %d", c, 2)
+	}
+}
+
+func TestControllerEvents(t *testing.T) {
+	type event struct {
+		key   string
+		event model.Event
+	}
+	var events []event
+	ctl := NewController(nil, resync).(*controller)
+	_ = ctl.AppendServiceHandler(func(service *model.Service, e model.Event) {
+		events = append(events, event{service.Hostname, e})
+	})
+	_ = ctl.AppendInstanceHandler(func(instance *model.ServiceInstance, e model.Event) {
+		events = append(events, event{instanceKey(instance), e})
+	})
+
+	snapshot := func(apps []*application) (map[string]*model.Service, map[string]*model.ServiceInstance) {
+		services := convertServices(apps, nil)
+		instances := make(map[string]*model.ServiceInstance)
+		for _, instance := range convertServiceInstances(services, apps) {
+			instances[instanceKey(instance)] = instance
+		}
+		return services, instances
+	}
+
+	oldServices, oldInstances := snapshot([]*application{{
+		Name: "APP",
+		Instances: []*instance{
+			makeInstance("hello.world.local", "10.0.0.1", 8080, -1, nil),
+			makeInstance("hello.world.local", "10.0.0.2", 8080, -1, nil),
+		},
+	}})
+	newServices, newInstances := snapshot([]*application{{
+		Name: "APP",
+		Instances: []*instance{
+			makeInstance("hello.world.local", "10.0.0.1", 8080, -1, metadata{"version": "v2"}),
+			makeInstance("hello.world.local", "10.0.0.3", 9090, -1, nil),
+		},
+	}})
+
+	ctl.notify(oldServices, newServices, oldInstances, newInstances)
+	expected := []event{
+		{"hello.world.local", model.EventUpdate},
+		{"hello.world.local|10.0.0.2:8080", model.EventDelete},
+		{"hello.world.local|10.0.0.1:8080", model.EventUpdate},
+		{"hello.world.local|10.0.0.3:9090", model.EventAdd},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("got events %v, want %v", events, expected)
+	}
+	for i, e := range expected[:2] {
+		if events[i] != e {
+			t.Errorf("got event %v, want %v", events[i], e)
+		}
+	}
+	for _, e := range expected[2:] {
+		if events[2] != e && events[3] != e {
+			t.Errorf("missing event %v in %v", e, events)
+		}
 	}
 }
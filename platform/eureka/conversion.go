@@ -82,8 +82,10 @@ func convertServiceInstances(services map[string]*model.Service, apps []*applica
 				continue
 			}
 
+			// instances that are not up (e.g. down or starting) are unhealthy
+			health := model.Healthy
 			if instance.Status != statusUp {
-				continue
+				health = model.Unhealthy
 			}
 
 			for _, port := range convertPorts(instance) {
@@ -95,6 +97,7 @@ func convertServiceInstances(services map[string]*model.Service, apps []*applica
 					},
 					Service: services[instance.Hostname],
 					Tags:    convertTags(instance.Metadata),
+					Health:  health,
 				})
 			}
 		}
@@ -167,6 +167,24 @@ func TestConvertServiceInstances(t *testing.T) {
 				makeServiceInstance(foobarService, "10.0.0.2", 5000, nil),
 			},
 		},
+		{
+			services: map[string]*model.Service{
+				"foo.bar.local": foobarService,
+			},
+			apps: []*application{
+				{
+					Name: "foo_bar_local",
+					Instances: []*instance{
+						makeInstance("foo.bar.local", "10.0.0.1", 5000, -1, nil),
+						makeDownInstance("foo.bar.local", "10.0.0.2", 5000, -1, nil),
+					},
+				},
+			},
+			out: []*model.ServiceInstance{
+				makeServiceInstance(foobarService, "10.0.0.1", 5000, nil),
+				makeUnhealthyServiceInstance(foobarService, "10.0.0.2", 5000, nil),
+			},
+		},
 	}
 
 	for _, tt := range serviceInstanceTests {
@@ -302,6 +320,18 @@ func makeServiceInstance(service *model.Service, ip string, port int, tags model
 	}
 }
 
+func makeDownInstance(hostname, ip string, portNum, securePort int, md metadata) *instance {
+	inst := makeInstance(hostname, ip, portNum, securePort, md)
+	inst.Status = "DOWN"
+	return inst
+}
+
+func makeUnhealthyServiceInstance(service *model.Service, ip string, port int, tags model.Tags) *model.ServiceInstance {
+	inst := makeServiceInstance(service, ip, port, tags)
+	inst.Health = model.Unhealthy
+	return inst
+}
+
 func compare(t *testing.T, actual, expected interface{}) error {
 	return util.Compare(jsonBytes(t, actual), jsonBytes(t, expected))
 }
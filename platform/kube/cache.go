@@ -15,6 +15,8 @@
 package kube
 
 import (
+	"sync"
+
 	"k8s.io/api/core/v1"
 
 	"istio.io/pilot/model"
@@ -72,3 +74,55 @@ func (pc *PodCache) tagsByIP(addr string) (model.Tags, bool) {
 	}
 	return convertTags(pod.ObjectMeta), true
 }
+
+// serviceCache memoizes the conversion of Kubernetes services to model
+// services. An entry remains valid as long as the informer store holds the
+// same service object, since the store replaces the objects on updates.
+type serviceCache struct {
+	domainSuffix string
+
+	mu      sync.RWMutex
+	entries map[string]serviceCacheEntry
+}
+
+type serviceCacheEntry struct {
+	obj *v1.Service
+	svc *model.Service
+}
+
+func newServiceCache(ch cacheHandler, domainSuffix string) *serviceCache {
+	out := &serviceCache{
+		domainSuffix: domainSuffix,
+		entries:      make(map[string]serviceCacheEntry),
+	}
+
+	ch.handler.Append(func(obj interface{}, ev model.Event) error {
+		svc := *obj.(*v1.Service)
+		key := KeyFunc(svc.Name, svc.Namespace)
+		out.mu.Lock()
+		delete(out.entries, key)
+		out.mu.Unlock()
+		return nil
+	})
+	return out
+}
+
+// convert returns the model service for the Kubernetes service or nil if the
+// service can not be represented in the model. The returned service is shared
+// and must not be modified.
+func (sc *serviceCache) convert(obj *v1.Service) *model.Service {
+	key := KeyFunc(obj.Name, obj.Namespace)
+
+	sc.mu.RLock()
+	entry, exists := sc.entries[key]
+	sc.mu.RUnlock()
+	if exists && entry.obj == obj {
+		return entry.svc
+	}
+
+	svc := convertService(*obj, sc.domainSuffix)
+	sc.mu.Lock()
+	sc.entries[key] = serviceCacheEntry{obj: obj, svc: svc}
+	sc.mu.Unlock()
+	return svc
+}
@@ -38,6 +38,9 @@ const (
 	NodeRegionLabel = "failure-domain.beta.kubernetes.io/region"
 	// NodeZoneLabel is the well-known label for kubernetes node zone
 	NodeZoneLabel = "failure-domain.beta.kubernetes.io/zone"
+
+	// endpointIPIndex is the name of the endpoints index by the endpoint addresses
+	endpointIPIndex = "ip"
 )
 
 // ControllerOptions stores the configurable attributes of a Controller.
@@ -61,6 +64,9 @@ type Controller struct {
 	nodes     cacheHandler
 
 	pods *PodCache
+
+	// serviceCache memoizes the converted services
+	serviceCache *serviceCache
 }
 
 type cacheHandler struct {
@@ -85,7 +91,8 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 		},
 		func(opts meta_v1.ListOptions) (watch.Interface, error) {
 			return client.CoreV1().Services(options.Namespace).Watch(opts)
-		})
+		}, cache.Indexers{})
+	out.serviceCache = newServiceCache(out.services, options.DomainSuffix)
 
 	out.endpoints = out.createInformer(&v1.Endpoints{}, options.ResyncPeriod,
 		func(opts meta_v1.ListOptions) (runtime.Object, error) {
@@ -93,7 +100,7 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 		},
 		func(opts meta_v1.ListOptions) (watch.Interface, error) {
 			return client.CoreV1().Endpoints(options.Namespace).Watch(opts)
-		})
+		}, cache.Indexers{endpointIPIndex: endpointIPIndexFunc})
 
 	out.nodes = out.createInformer(&v1.Node{}, options.ResyncPeriod,
 		func(opts meta_v1.ListOptions) (runtime.Object, error) {
@@ -101,7 +108,7 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 		},
 		func(opts meta_v1.ListOptions) (watch.Interface, error) {
 			return client.CoreV1().Nodes().Watch(opts)
-		})
+		}, cache.Indexers{})
 
 	out.pods = newPodCache(out.createInformer(&v1.Pod{}, options.ResyncPeriod,
 		func(opts meta_v1.ListOptions) (runtime.Object, error) {
@@ -109,7 +116,7 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 		},
 		func(opts meta_v1.ListOptions) (watch.Interface, error) {
 			return client.CoreV1().Pods(options.Namespace).Watch(opts)
-		}))
+		}, cache.Indexers{}))
 
 	return out
 }
@@ -133,13 +140,13 @@ func (c *Controller) createInformer(
 	o runtime.Object,
 	resyncPeriod time.Duration,
 	lf cache.ListFunc,
-	wf cache.WatchFunc) cacheHandler {
+	wf cache.WatchFunc,
+	indexers cache.Indexers) cacheHandler {
 	handler := &ChainHandler{funcs: []Handler{c.notify}}
 
-	// TODO: finer-grained index (perf)
 	informer := cache.NewSharedIndexInformer(
 		&cache.ListWatch{ListFunc: lf, WatchFunc: wf}, o,
-		resyncPeriod, cache.Indexers{})
+		resyncPeriod, indexers)
 
 	informer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
@@ -160,6 +167,40 @@ func (c *Controller) createInformer(
 	return cacheHandler{informer: informer, handler: handler}
 }
 
+// endpointIPIndexFunc indexes endpoints by the addresses of the endpoint subsets
+func endpointIPIndexFunc(obj interface{}) ([]string, error) {
+	ep, ok := obj.(*v1.Endpoints)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T in the endpoint index", obj)
+	}
+	var ips []string
+	for _, ss := range ep.Subsets {
+		for _, ea := range subsetAddresses(ss) {
+			ips = append(ips, ea.IP)
+		}
+	}
+	return ips, nil
+}
+
+// endpointAddress is an endpoint address with the health status derived from
+// the endpoint readiness
+type endpointAddress struct {
+	v1.EndpointAddress
+	health model.HealthStatus
+}
+
+// subsetAddresses lists the ready and the not ready addresses of an endpoint subset
+func subsetAddresses(ss v1.EndpointSubset) []endpointAddress {
+	out := make([]endpointAddress, 0, len(ss.Addresses)+len(ss.NotReadyAddresses))
+	for _, ea := range ss.Addresses {
+		out = append(out, endpointAddress{EndpointAddress: ea, health: model.Healthy})
+	}
+	for _, ea := range ss.NotReadyAddresses {
+		out = append(out, endpointAddress{EndpointAddress: ea, health: model.Unhealthy})
+	}
+	return out
+}
+
 // HasSynced returns true after the initial state synchronization
 func (c *Controller) HasSynced() bool {
 	if !c.services.informer.HasSynced() ||
@@ -188,7 +229,7 @@ func (c *Controller) Services() []*model.Service {
 	out := make([]*model.Service, 0, len(list))
 
 	for _, item := range list {
-		if svc := convertService(*item.(*v1.Service), c.domainSuffix); svc != nil {
+		if svc := c.serviceCache.convert(item.(*v1.Service)); svc != nil {
 			out = append(out, svc)
 		}
 	}
@@ -207,7 +248,7 @@ func (c *Controller) GetService(hostname string) (*model.Service, bool) {
 		return nil, false
 	}
 
-	svc := convertService(*item, c.domainSuffix)
+	svc := c.serviceCache.convert(item)
 	return svc, svc != nil
 }
 
@@ -224,6 +265,19 @@ func (c *Controller) serviceByKey(name, namespace string) (*v1.Service, bool) {
 	return item.(*v1.Service), true
 }
 
+// endpointsByKey retrieves endpoints by name and namespace
+func (c *Controller) endpointsByKey(name, namespace string) (*v1.Endpoints, bool) {
+	item, exists, err := c.endpoints.informer.GetStore().GetByKey(KeyFunc(name, namespace))
+	if err != nil {
+		glog.V(2).Infof("endpointsByKey(%s, %s) => error %v", name, namespace, err)
+		return nil, false
+	}
+	if !exists {
+		return nil, false
+	}
+	return item.(*v1.Endpoints), true
+}
+
 // GetPodAZ retrieves the AZ for a pod.
 func (c *Controller) GetPodAZ(pod *v1.Pod) (string, bool) {
 	// NodeName is set by the scheduler after the pod is created
@@ -277,7 +331,7 @@ func (c *Controller) Instances(hostname string, ports []string, tagsList model.T
 	}
 
 	// Locate all ports in the actual service
-	svc := convertService(*item, c.domainSuffix)
+	svc := c.serviceCache.convert(item)
 	if svc == nil {
 		return nil
 	}
@@ -289,62 +343,70 @@ func (c *Controller) Instances(hostname string, ports []string, tagsList model.T
 	}
 
 	// TODO: single port service missing name
-	for _, item := range c.endpoints.informer.GetStore().List() {
-		ep := *item.(*v1.Endpoints)
-		if ep.Name == name && ep.Namespace == namespace {
-			var out []*model.ServiceInstance
-			for _, ss := range ep.Subsets {
-				for _, ea := range ss.Addresses {
-					tags, _ := c.pods.tagsByIP(ea.IP)
-					// check that one of the input tags is a subset of the tags
-					if !tagsList.HasSubsetOf(tags) {
-						continue
-					}
+	ep, exists := c.endpointsByKey(name, namespace)
+	if !exists {
+		return nil
+	}
 
-					pod, exists := c.pods.getPodByIP(ea.IP)
-					az, sa := "", ""
-					if exists {
-						az, _ = c.GetPodAZ(pod)
-						sa = kubeToIstioServiceAccount(pod.Spec.ServiceAccountName, pod.GetNamespace(), c.domainSuffix)
-					}
+	var out []*model.ServiceInstance
+	for _, ss := range ep.Subsets {
+		for _, ea := range subsetAddresses(ss) {
+			tags, _ := c.pods.tagsByIP(ea.IP)
+			// check that one of the input tags is a subset of the tags
+			if !tagsList.HasSubsetOf(tags) {
+				continue
+			}
 
-					// identify the port by name
-					for _, port := range ss.Ports {
-						if svcPort, exists := svcPorts[port.Name]; exists {
-							out = append(out, &model.ServiceInstance{
-								Endpoint: model.NetworkEndpoint{
-									Address:     ea.IP,
-									Port:        int(port.Port),
-									ServicePort: svcPort,
-								},
-								Service:          svc,
-								Tags:             tags,
-								AvailabilityZone: az,
-								ServiceAccount:   sa,
-							})
-						}
-					}
+			pod, exists := c.pods.getPodByIP(ea.IP)
+			az, sa := "", ""
+			if exists {
+				az, _ = c.GetPodAZ(pod)
+				sa = kubeToIstioServiceAccount(pod.Spec.ServiceAccountName, pod.GetNamespace(), c.domainSuffix)
+			}
+
+			// identify the port by name
+			for _, port := range ss.Ports {
+				if svcPort, exists := svcPorts[port.Name]; exists {
+					out = append(out, &model.ServiceInstance{
+						Endpoint: model.NetworkEndpoint{
+							Address:     ea.IP,
+							Port:        int(port.Port),
+							ServicePort: svcPort,
+						},
+						Service:          svc,
+						Tags:             tags,
+						AvailabilityZone: az,
+						ServiceAccount:   sa,
+						Health:           ea.health,
+					})
 				}
 			}
-			return out
 		}
 	}
-	return nil
+	return out
 }
 
 // HostInstances implements a service catalog operation
 func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
 	var out []*model.ServiceInstance
-	for _, item := range c.endpoints.informer.GetStore().List() {
-		ep := *item.(*v1.Endpoints)
-		for _, ss := range ep.Subsets {
-			for _, ea := range ss.Addresses {
-				if addrs[ea.IP] {
+	for addr := range addrs {
+		items, err := c.endpoints.informer.GetIndexer().ByIndex(endpointIPIndex, addr)
+		if err != nil {
+			glog.V(2).Infof("HostInstances(%s) => error %v", addr, err)
+			continue
+		}
+		for _, item := range items {
+			ep := *item.(*v1.Endpoints)
+			for _, ss := range ep.Subsets {
+				for _, ea := range subsetAddresses(ss) {
+					if ea.IP != addr {
+						continue
+					}
 					item, exists := c.serviceByKey(ep.Name, ep.Namespace)
 					if !exists {
 						continue
 					}
-					svc := convertService(*item, c.domainSuffix)
+					svc := c.serviceCache.convert(item)
 					if svc == nil {
 						continue
 					}
@@ -370,6 +432,7 @@ func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstan
 							Tags:             tags,
 							AvailabilityZone: az,
 							ServiceAccount:   sa,
+							Health:           ea.health,
 						})
 					}
 				}
@@ -430,7 +493,7 @@ func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.
 	c.endpoints.handler.Append(func(obj interface{}, event model.Event) error {
 		ep := *obj.(*v1.Endpoints)
 		if item, exists := c.serviceByKey(ep.Name, ep.Namespace); exists {
-			if svc := convertService(*item, c.domainSuffix); svc != nil {
+			if svc := c.serviceCache.convert(item); svc != nil {
 				// TODO: we're passing an incomplete instance to the
 				// handler since endpoints is an aggregate structure
 				f(&model.ServiceInstance{Service: svc}, event)
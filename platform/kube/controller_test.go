@@ -297,6 +297,132 @@ func TestController_GetIstioServiceAccounts(t *testing.T) {
 	}
 }
 
+func TestController_HostInstances(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	createService(controller, "svc2", "nsA", nil, []int32{8081}, nil, t)
+	createService(controller, "svc3", "nsB", nil, []int32{8082}, nil, t)
+	portNames := []string{"test-port"}
+	createEndpoints(controller, "svc1", "nsA", portNames, []string{"128.0.0.1", "128.0.0.2"}, t)
+	createEndpoints(controller, "svc2", "nsA", portNames, []string{"128.0.0.2"}, t)
+	createEndpoints(controller, "svc3", "nsB", portNames, []string{"128.0.0.3"}, t)
+
+	instances := controller.HostInstances(map[string]bool{"128.0.0.2": true})
+	hostnames := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Endpoint.Address != "128.0.0.2" {
+			t.Errorf("HostInstances() => unexpected endpoint %#v", instance.Endpoint)
+		}
+		hostnames = append(hostnames, instance.Service.Hostname)
+	}
+	sort.Strings(hostnames)
+	expected := []string{serviceHostname("svc1", "nsA", domainSuffix), serviceHostname("svc2", "nsA", domainSuffix)}
+	if !reflect.DeepEqual(hostnames, expected) {
+		t.Errorf("HostInstances() => got %v, want %v", hostnames, expected)
+	}
+
+	if instances = controller.HostInstances(map[string]bool{"128.0.0.4": true}); len(instances) != 0 {
+		t.Errorf("HostInstances() => got %v, want none", instances)
+	}
+}
+
+func TestController_InstancesHealth(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	endpoints := &v1.Endpoints{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "svc1", Namespace: "nsA"},
+		Subsets: []v1.EndpointSubset{{
+			Addresses:         []v1.EndpointAddress{{IP: "128.0.0.1"}},
+			NotReadyAddresses: []v1.EndpointAddress{{IP: "128.0.0.2"}},
+			Ports:             []v1.EndpointPort{{Name: "test-port", Port: 8080}},
+		}},
+	}
+	if err := controller.endpoints.informer.GetStore().Add(endpoints); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]model.HealthStatus{"128.0.0.1": model.Healthy, "128.0.0.2": model.Unhealthy}
+	hostname := serviceHostname("svc1", "nsA", domainSuffix)
+	instances := controller.Instances(hostname, []string{"test-port"}, nil)
+	if len(instances) != len(expected) {
+		t.Fatalf("Instances() => got %d instances, want %d", len(instances), len(expected))
+	}
+	for _, instance := range instances {
+		if health := expected[instance.Endpoint.Address]; instance.Health != health {
+			t.Errorf("Instances() => got health %v for %s, want %v", instance.Health, instance.Endpoint.Address, health)
+		}
+	}
+
+	instances = controller.HostInstances(map[string]bool{"128.0.0.2": true})
+	if len(instances) != 1 || instances[0].Health != model.Unhealthy {
+		t.Errorf("HostInstances() => got %v, want one unhealthy instance", instances)
+	}
+}
+
+func TestController_ServiceCache(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+
+	hostname := serviceHostname("svc1", "nsA", domainSuffix)
+	svc, exists := controller.GetService(hostname)
+	if !exists {
+		t.Fatalf("GetService(%s) => missing service", hostname)
+	}
+	if cached, _ := controller.GetService(hostname); cached != svc {
+		t.Errorf("GetService(%s) => converted service is not cached", hostname)
+	}
+
+	// an update replaces the service object in the store
+	createService(controller, "svc1", "nsA", nil, []int32{8080, 9090}, nil, t)
+	if updated, _ := controller.GetService(hostname); updated == svc || len(updated.Ports) != 2 {
+		t.Errorf("GetService(%s) => got stale service %#v", hostname, updated)
+	}
+}
+
+// makeBenchmarkController populates a controller with services, each with
+// endpoints on distinct addresses
+func makeBenchmarkController(b *testing.B, services, endpoints int) *Controller {
+	controller := makeFakeKubeAPIController()
+	portNames := []string{"test-port"}
+	for i := 0; i < services; i++ {
+		name := fmt.Sprintf("svc%d", i)
+		createService(controller, name, "default", nil, []int32{8080}, nil, b)
+		ips := make([]string, 0, endpoints)
+		for j := 0; j < endpoints; j++ {
+			ips = append(ips, fmt.Sprintf("10.%d.%d.%d", i/256, i%256, j))
+		}
+		createEndpoints(controller, name, "default", portNames, ips, b)
+	}
+	return controller
+}
+
+func BenchmarkServices(b *testing.B) {
+	controller := makeBenchmarkController(b, 3000, 3)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		controller.Services()
+	}
+}
+
+func BenchmarkInstances(b *testing.B) {
+	controller := makeBenchmarkController(b, 3000, 3)
+	hostname := serviceHostname("svc1500", "default", domainSuffix)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		controller.Instances(hostname, []string{"test-port"}, nil)
+	}
+}
+
+func BenchmarkHostInstances(b *testing.B) {
+	controller := makeBenchmarkController(b, 3000, 3)
+	addrs := map[string]bool{"10.5.220.1": true}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		controller.HostInstances(addrs)
+	}
+}
+
 func makeFakeKubeAPIController() *Controller {
 	clientSet := fake.NewSimpleClientset()
 	mesh := proxy.DefaultMeshConfig()
@@ -307,7 +433,7 @@ func makeFakeKubeAPIController() *Controller {
 	})
 }
 
-func createEndpoints(controller *Controller, name, namespace string, portNames, ips []string, t *testing.T) {
+func createEndpoints(controller *Controller, name, namespace string, portNames, ips []string, t testing.TB) {
 	eas := []v1.EndpointAddress{}
 	for _, ip := range ips {
 		eas = append(eas, v1.EndpointAddress{IP: ip})
@@ -334,7 +460,7 @@ func createEndpoints(controller *Controller, name, namespace string, portNames,
 }
 
 func createService(controller *Controller, name, namespace string, annotations map[string]string,
-	ports []int32, selector map[string]string, t *testing.T) {
+	ports []int32, selector map[string]string, t testing.TB) {
 
 	svcPorts := []v1.ServicePort{}
 	for _, p := range ports {
@@ -70,8 +70,11 @@ func convertService(svc v1.Service, domainSuffix string) *model.Service {
 		external = svc.Spec.ExternalName
 	}
 
-	// must have address or be external (but not both)
-	if (addr == "" && external == "") || (addr != "" && external != "") {
+	// headless services have no address and are reached through the endpoints
+	headless := svc.Spec.ClusterIP == v1.ClusterIPNone && external == ""
+
+	// must have address, be headless, or be external (but not both)
+	if (addr == "" && external == "" && !headless) || (addr != "" && external != "") {
 		return nil
 	}
 
@@ -95,6 +98,7 @@ func convertService(svc v1.Service, domainSuffix string) *model.Service {
 		Hostname:        serviceHostname(svc.Name, svc.Namespace, domainSuffix),
 		Ports:           ports,
 		Address:         addr,
+		Headless:        headless,
 		ExternalName:    external,
 		ServiceAccounts: serviceaccounts,
 	}
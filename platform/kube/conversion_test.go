@@ -172,6 +172,47 @@ func TestExternalServiceConversion(t *testing.T) {
 	}
 }
 
+func TestHeadlessServiceConversion(t *testing.T) {
+	serviceName := "service1"
+	namespace := "default"
+
+	headlessSvc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceName,
+			Namespace: namespace,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Name:     "tcp-cql",
+					Port:     9042,
+					Protocol: v1.ProtocolTCP,
+				},
+			},
+			ClusterIP: v1.ClusterIPNone,
+		},
+	}
+
+	service := convertService(headlessSvc, domainSuffix)
+	if service == nil {
+		t.Fatalf("could not convert headless service")
+	}
+
+	if service.Address != "" || !service.Headless || service.External() {
+		t.Errorf("service should be headless => %#v", service)
+	}
+
+	if len(service.Ports) != len(headlessSvc.Spec.Ports) {
+		t.Errorf("incorrect number of ports => %v, want %v",
+			len(service.Ports), len(headlessSvc.Spec.Ports))
+	}
+
+	if service.Hostname != serviceHostname(serviceName, namespace, domainSuffix) {
+		t.Errorf("service hostname incorrect => %q, want %q",
+			service.Hostname, serviceHostname(serviceName, namespace, domainSuffix))
+	}
+}
+
 func TestInvalidServiceConversion(t *testing.T) {
 	serviceName := "service1"
 	namespace := "default"
@@ -14,16 +14,17 @@
 
 package inject
 
-// NOTE: This tool only exists because kubernetes does not support
-// dynamic/out-of-tree admission controller for transparent proxy
-// injection. This file should be removed as soon as a proper kubernetes
-// admission controller is written for istio.
+// NOTE: Resource files are injected offline by istioctl kube-inject.
+// Pods are injected at admission time by the mutating admission webhook
+// in webhook.go, which shares the pod template injection below.
 
 import (
 	"bufio"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
 
 	"github.com/ghodss/yaml"
 	multierror "github.com/hashicorp/go-multierror"
@@ -31,6 +32,7 @@ import (
 	batch "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes"
@@ -71,6 +73,38 @@ const (
 	ConfigMapKey = "mesh"
 )
 
+// Pod template annotations overriding the injection parameters per workload
+const (
+	// IncludeIPRangesAnnotation overrides the IP ranges redirected to the proxy
+	IncludeIPRangesAnnotation = "alpha.istio.io/includeIPRanges"
+
+	// ExcludeIPRangesAnnotation overrides the IP ranges excluded from the
+	// redirection to the proxy
+	ExcludeIPRangesAnnotation = "alpha.istio.io/excludeIPRanges"
+
+	// ExcludeInboundPortsAnnotation overrides the inbound ports excluded
+	// from the redirection to the proxy
+	ExcludeInboundPortsAnnotation = "alpha.istio.io/excludeInboundPorts"
+
+	// ProxyImageAnnotation overrides the proxy image name and tag
+	ProxyImageAnnotation = "alpha.istio.io/proxyImage"
+
+	// ProxyCPUAnnotation sets the CPU request of the proxy container
+	ProxyCPUAnnotation = "alpha.istio.io/proxyCPU"
+
+	// ProxyMemoryAnnotation sets the memory request of the proxy container
+	ProxyMemoryAnnotation = "alpha.istio.io/proxyMemory"
+
+	// ProxyCPULimitAnnotation sets the CPU limit of the proxy container
+	ProxyCPULimitAnnotation = "alpha.istio.io/proxyCPULimit"
+
+	// ProxyMemoryLimitAnnotation sets the memory limit of the proxy container
+	ProxyMemoryLimitAnnotation = "alpha.istio.io/proxyMemoryLimit"
+
+	// CoreDumpAnnotation overrides the core dump enablement
+	CoreDumpAnnotation = "alpha.istio.io/coreDump"
+)
+
 // InitImageName returns the fully qualified image name for the istio
 // init image given a docker hub and tag
 func InitImageName(hub, tag string) string { return hub + "/proxy_init:" + tag }
@@ -95,6 +129,110 @@ type Params struct {
 	// redirect outbound traffic to Envoy for these IP
 	// ranges. Otherwise all outbound traffic is redirected to Envoy.
 	IncludeIPRanges string
+	// Comma separated list of IP ranges in CIDR form excluded from
+	// the redirection of outbound traffic to Envoy.
+	ExcludeIPRanges string
+	// Comma separated list of inbound ports excluded from the
+	// redirection of inbound traffic to Envoy.
+	ExcludeInboundPorts string
+	// Compute resource requirements of the proxy container
+	ProxyResources v1.ResourceRequirements
+}
+
+// validateIPRanges checks a comma separated list of IP ranges in CIDR form
+func validateIPRanges(ranges string) error {
+	var errs error
+	for _, cidr := range strings.Split(ranges, ",") {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validatePorts checks a comma separated list of ports
+func validatePorts(ports string) error {
+	var errs error
+	for _, port := range strings.Split(ports, ",") {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			errs = multierror.Append(errs, fmt.Errorf("invalid port %q", port))
+		}
+	}
+	return errs
+}
+
+// withAnnotations returns the parameters overridden by the pod template
+// annotations, or an error listing all invalid annotations
+func withAnnotations(p *Params, annotations map[string]string) (*Params, error) {
+	out := *p
+	out.ProxyResources = *p.ProxyResources.DeepCopy()
+
+	var errs error
+	if value, exists := annotations[IncludeIPRangesAnnotation]; exists {
+		if err := validateIPRanges(value); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, IncludeIPRangesAnnotation+":"))
+		}
+		out.IncludeIPRanges = value
+	}
+	if value, exists := annotations[ExcludeIPRangesAnnotation]; exists {
+		if err := validateIPRanges(value); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, ExcludeIPRangesAnnotation+":"))
+		}
+		out.ExcludeIPRanges = value
+	}
+	if value, exists := annotations[ExcludeInboundPortsAnnotation]; exists {
+		if err := validatePorts(value); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, ExcludeInboundPortsAnnotation+":"))
+		}
+		out.ExcludeInboundPorts = value
+	}
+	if value, exists := annotations[ProxyImageAnnotation]; exists {
+		if value == "" {
+			errs = multierror.Append(errs, fmt.Errorf("%s: image must be non-empty", ProxyImageAnnotation))
+		}
+		out.ProxyImage = value
+	}
+	if value, exists := annotations[CoreDumpAnnotation]; exists {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, CoreDumpAnnotation+":"))
+		}
+		out.EnableCoreDump = enabled
+	}
+
+	quantities := []struct {
+		annotation string
+		list       *v1.ResourceList
+		name       v1.ResourceName
+	}{
+		{ProxyCPUAnnotation, &out.ProxyResources.Requests, v1.ResourceCPU},
+		{ProxyMemoryAnnotation, &out.ProxyResources.Requests, v1.ResourceMemory},
+		{ProxyCPULimitAnnotation, &out.ProxyResources.Limits, v1.ResourceCPU},
+		{ProxyMemoryLimitAnnotation, &out.ProxyResources.Limits, v1.ResourceMemory},
+	}
+	for _, q := range quantities {
+		value, exists := annotations[q.annotation]
+		if !exists {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, q.annotation+":"))
+			continue
+		}
+		if *q.list == nil {
+			*q.list = make(v1.ResourceList)
+		}
+		(*q.list)[q.name] = quantity
+	}
+	for name, request := range out.ProxyResources.Requests {
+		if limit, exists := out.ProxyResources.Limits[name]; exists && request.Cmp(limit) > 0 {
+			errs = multierror.Append(errs, fmt.Errorf("proxy %s request %s exceeds limit %s",
+				name, request.String(), limit.String()))
+		}
+	}
+
+	return &out, errs
 }
 
 // GetMeshConfig fetches configuration from a config map
@@ -130,6 +268,12 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 		// Return unmodified resource if sidecar is already present or ignored.
 		return nil
 	}
+
+	p, err := withAnnotations(p, t.Annotations)
+	if err != nil {
+		return multierror.Prefix(err, "invalid injection annotations:")
+	}
+
 	t.Annotations[istioSidecarAnnotationSidecarKey] = istioSidecarAnnotationSidecarValue
 	t.Annotations[istioSidecarAnnotationVersionKey] = p.Version
 
@@ -141,6 +285,12 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 	if p.IncludeIPRanges != "" {
 		initArgs = append(initArgs, "-i", p.IncludeIPRanges)
 	}
+	if p.ExcludeIPRanges != "" {
+		initArgs = append(initArgs, "-x", p.ExcludeIPRanges)
+	}
+	if p.ExcludeInboundPorts != "" {
+		initArgs = append(initArgs, "-d", p.ExcludeInboundPorts)
+	}
 
 	var pullPolicy v1.PullPolicy
 	switch p.ImagePullPolicy {
@@ -283,6 +433,7 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 			RunAsUser:              &p.SidecarProxyUID,
 			ReadOnlyRootFilesystem: &readOnly,
 		},
+		Resources:    p.ProxyResources,
 		VolumeMounts: volumeMounts,
 	}
 
@@ -291,9 +442,77 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 	return nil
 }
 
+// removeFromPodTemplateSpec removes the containers, volumes, and annotations
+// added by injectIntoPodTemplateSpec. Templates with an ignored sidecar are
+// left unmodified.
+func removeFromPodTemplateSpec(t *v1.PodTemplateSpec) {
+	if t.Annotations[istioSidecarAnnotationSidecarKey] != istioSidecarAnnotationSidecarValue {
+		return
+	}
+	delete(t.Annotations, istioSidecarAnnotationSidecarKey)
+	delete(t.Annotations, istioSidecarAnnotationVersionKey)
+	if len(t.Annotations) == 0 {
+		t.Annotations = nil
+	}
+
+	var initContainers []v1.Container
+	for _, container := range t.Spec.InitContainers {
+		if container.Name != InitContainerName && container.Name != enableCoreDumpContainerName {
+			initContainers = append(initContainers, container)
+		}
+	}
+	t.Spec.InitContainers = initContainers
+
+	var containers []v1.Container
+	for _, container := range t.Spec.Containers {
+		if container.Name != ProxyContainerName {
+			containers = append(containers, container)
+		}
+	}
+	t.Spec.Containers = containers
+
+	var volumes []v1.Volume
+	for _, volume := range t.Spec.Volumes {
+		switch volume.Name {
+		case istioConfigVolumeName, istioEnvoyConfigVolumeName, istioCertVolumeName:
+		default:
+			volumes = append(volumes, volume)
+		}
+	}
+	t.Spec.Volumes = volumes
+}
+
 // IntoResourceFile injects the istio proxy into the specified
 // kubernetes YAML file.
 func IntoResourceFile(p *Params, in io.Reader, out io.Writer) error {
+	return updateResourceFile(in, out, func(t *v1.PodTemplateSpec) error {
+		return injectIntoPodTemplateSpec(p, t)
+	})
+}
+
+// FromResourceFile removes the istio proxy from the specified kubernetes
+// YAML file, reversing IntoResourceFile.
+func FromResourceFile(in io.Reader, out io.Writer) error {
+	return updateResourceFile(in, out, func(t *v1.PodTemplateSpec) error {
+		removeFromPodTemplateSpec(t)
+		return nil
+	})
+}
+
+// ReinjectResourceFile replaces the istio proxy in the specified kubernetes
+// YAML file with the proxy for the parameters. Resources without the proxy
+// are injected.
+func ReinjectResourceFile(p *Params, in io.Reader, out io.Writer) error {
+	return updateResourceFile(in, out, func(t *v1.PodTemplateSpec) error {
+		removeFromPodTemplateSpec(t)
+		return injectIntoPodTemplateSpec(p, t)
+	})
+}
+
+// updateResourceFile applies the update to the pod templates of the
+// supported resources in the kubernetes YAML file. Other resources are
+// left unmodified.
+func updateResourceFile(in io.Reader, out io.Writer, update func(*v1.PodTemplateSpec) error) error {
 	reader := yamlDecoder.NewYAMLReader(bufio.NewReaderSize(in, 4096))
 	for {
 		raw, err := reader.Read()
@@ -305,42 +524,42 @@ func IntoResourceFile(p *Params, in io.Reader, out io.Writer) error {
 		}
 		kinds := map[string]struct {
 			typ    interface{}
-			inject func(typ interface{}) error
+			update func(typ interface{}) error
 		}{
 			"Job": {
 				typ: &batch.Job{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*batch.Job)).Spec.Template))
+				update: func(typ interface{}) error {
+					return update(&((typ.(*batch.Job)).Spec.Template))
 				},
 			},
 			"DaemonSet": {
 				typ: &v1beta1.DaemonSet{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.DaemonSet)).Spec.Template))
+				update: func(typ interface{}) error {
+					return update(&((typ.(*v1beta1.DaemonSet)).Spec.Template))
 				},
 			},
 			"ReplicaSet": {
 				typ: &v1beta1.ReplicaSet{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.ReplicaSet)).Spec.Template))
+				update: func(typ interface{}) error {
+					return update(&((typ.(*v1beta1.ReplicaSet)).Spec.Template))
 				},
 			},
 			"Deployment": {
 				typ: &v1beta1.Deployment{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.Deployment)).Spec.Template))
+				update: func(typ interface{}) error {
+					return update(&((typ.(*v1beta1.Deployment)).Spec.Template))
 				},
 			},
 			"ReplicationController": {
 				typ: &v1.ReplicationController{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, ((typ.(*v1.ReplicationController)).Spec.Template))
+				update: func(typ interface{}) error {
+					return update(((typ.(*v1.ReplicationController)).Spec.Template))
 				},
 			},
 			"StatefulSet": {
 				typ: &appsv1beta1.StatefulSet{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*appsv1beta1.StatefulSet)).Spec.Template))
+				update: func(typ interface{}) error {
+					return update(&((typ.(*appsv1beta1.StatefulSet)).Spec.Template))
 				},
 			},
 		}
@@ -353,7 +572,7 @@ func IntoResourceFile(p *Params, in io.Reader, out io.Writer) error {
 			if err = yaml.Unmarshal(raw, kind.typ); err != nil {
 				return err
 			}
-			if err = kind.inject(kind.typ); err != nil {
+			if err = kind.update(kind.typ); err != nil {
 				return err
 			}
 			if updated, err = yaml.Marshal(kind.typ); err != nil {
@@ -16,9 +16,15 @@ package inject
 
 import (
 	"bytes"
+	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/proxy"
 	"istio.io/pilot/test/util"
@@ -167,3 +173,149 @@ func TestIntoResourceFile(t *testing.T) {
 	// file with existing annotation
 	// file with another init-container
 }
+
+func TestFromResourceFile(t *testing.T) {
+	cases := []struct {
+		in             string
+		enableAuth     bool
+		enableCoreDump bool
+	}{
+		{in: "testdata/hello.yaml.injected"},
+		{in: "testdata/hello-probes.yaml.injected"},
+		{in: "testdata/frontend.yaml.injected"},
+		{in: "testdata/hello-service.yaml.injected"},
+		{in: "testdata/hello-multi.yaml.injected"},
+		{in: "testdata/hello-ignore.yaml.injected"},
+		{in: "testdata/multi-init.yaml.injected"},
+		{in: "testdata/statefulset.yaml.injected"},
+		{in: "testdata/enable-core-dump.yaml.injected", enableCoreDump: true},
+		{in: "testdata/auth.yaml.injected", enableAuth: true},
+		{in: "testdata/auth.non-default-service-account.yaml.injected", enableAuth: true},
+	}
+
+	for _, c := range cases {
+		mesh := proxy.DefaultMeshConfig()
+		if c.enableAuth {
+			mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+			mesh.AuthCertsPath = "/etc/certs/"
+		}
+		params := Params{
+			InitImage:         InitImageName(unitTestHub, unitTestTag),
+			ProxyImage:        ProxyImageName(unitTestHub, unitTestTag),
+			ImagePullPolicy:   "IfNotPresent",
+			Verbosity:         DefaultVerbosity,
+			SidecarProxyUID:   DefaultSidecarProxyUID,
+			Version:           "12345678",
+			EnableCoreDump:    c.enableCoreDump,
+			Mesh:              &mesh,
+			MeshConfigMapName: "istio",
+		}
+
+		in, err := ioutil.ReadFile(c.in)
+		if err != nil {
+			t.Fatalf("Failed to read %q: %v", c.in, err)
+		}
+
+		// removal followed by injection restores the injected resources
+		var removed, got bytes.Buffer
+		if err = FromResourceFile(bytes.NewReader(in), &removed); err != nil {
+			t.Fatalf("FromResourceFile(%v) returned an error: %v", c.in, err)
+		}
+		if strings.Contains(removed.String(), istioSidecarAnnotationSidecarValue) ||
+			strings.Contains(removed.String(), ProxyContainerName) {
+			t.Errorf("FromResourceFile(%v) => sidecar is not removed:\n%s", c.in, removed.String())
+		}
+		if err = IntoResourceFile(&params, &removed, &got); err != nil {
+			t.Fatalf("IntoResourceFile(%v) returned an error: %v", c.in, err)
+		}
+		util.CompareContent(got.Bytes(), c.in, t)
+
+		// re-injection replaces an outdated sidecar
+		outdated := params
+		outdated.Version = "outdated"
+		outdated.ProxyImage = ProxyImageName(unitTestHub, "outdated")
+		outdated.Verbosity = 0
+		var old bytes.Buffer
+		got.Reset()
+		if err = ReinjectResourceFile(&outdated, bytes.NewReader(in), &old); err != nil {
+			t.Fatalf("ReinjectResourceFile(%v) returned an error: %v", c.in, err)
+		}
+		if err = ReinjectResourceFile(&params, &old, &got); err != nil {
+			t.Fatalf("ReinjectResourceFile(%v) returned an error: %v", c.in, err)
+		}
+		util.CompareContent(got.Bytes(), c.in, t)
+	}
+}
+
+func TestInjectionAnnotations(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := Params{
+		InitImage:         InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:        ProxyImageName(unitTestHub, unitTestTag),
+		ImagePullPolicy:   "IfNotPresent",
+		Verbosity:         DefaultVerbosity,
+		SidecarProxyUID:   DefaultSidecarProxyUID,
+		Version:           "12345678",
+		Mesh:              &mesh,
+		MeshConfigMapName: "istio",
+		IncludeIPRanges:   "10.0.0.0/8",
+	}
+
+	template := v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			IncludeIPRangesAnnotation:     "10.1.0.0/16,10.2.0.0/16",
+			ExcludeIPRangesAnnotation:     "10.1.1.0/24",
+			ExcludeInboundPortsAnnotation: "8080,9090",
+			ProxyImageAnnotation:          "example.com/proxy:canary",
+			ProxyCPUAnnotation:            "100m",
+			ProxyMemoryAnnotation:         "64Mi",
+			ProxyMemoryLimitAnnotation:    "128Mi",
+			CoreDumpAnnotation:            "true",
+		}},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "hello"}}},
+	}
+	if err := injectIntoPodTemplateSpec(&params, &template); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(template.Spec.InitContainers) != 2 || template.Spec.InitContainers[1].Name != enableCoreDumpContainerName {
+		t.Errorf("got init containers %v, want core dump container", template.Spec.InitContainers)
+	}
+	wantArgs := []string{"-p", "15001", "-u", "1337", "-i", "10.1.0.0/16,10.2.0.0/16",
+		"-x", "10.1.1.0/24", "-d", "8080,9090"}
+	if !reflect.DeepEqual(template.Spec.InitContainers[0].Args, wantArgs) {
+		t.Errorf("got init args %v, want %v", template.Spec.InitContainers[0].Args, wantArgs)
+	}
+	sidecar := template.Spec.Containers[1]
+	if sidecar.Image != "example.com/proxy:canary" {
+		t.Errorf("got proxy image %q", sidecar.Image)
+	}
+	cpu, memory, memoryLimit := sidecar.Resources.Requests[v1.ResourceCPU],
+		sidecar.Resources.Requests[v1.ResourceMemory], sidecar.Resources.Limits[v1.ResourceMemory]
+	if cpu.String() != "100m" || memory.String() != "64Mi" || memoryLimit.String() != "128Mi" {
+		t.Errorf("got proxy resources %v", sidecar.Resources)
+	}
+	if params.ProxyImage != ProxyImageName(unitTestHub, unitTestTag) || params.ProxyResources.Requests != nil {
+		t.Errorf("annotations modified the parameters: %v", params)
+	}
+
+	invalid := []map[string]string{
+		{IncludeIPRangesAnnotation: "10.1.0.0"},
+		{ExcludeIPRangesAnnotation: "10.1.0.0/16,"},
+		{ExcludeInboundPortsAnnotation: "http"},
+		{ExcludeInboundPortsAnnotation: "0"},
+		{ProxyImageAnnotation: ""},
+		{ProxyCPUAnnotation: "lots"},
+		{ProxyMemoryAnnotation: "128Mi", ProxyMemoryLimitAnnotation: "64Mi"},
+		{CoreDumpAnnotation: "maybe"},
+	}
+	for _, annotations := range invalid {
+		template := v1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hello"}}},
+		}
+		if err := injectIntoPodTemplateSpec(&params, &template); err == nil {
+			t.Errorf("injectIntoPodTemplateSpec() with annotations %v => expected an error", annotations)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// NamespaceInjectionLabel is the namespace label selecting the namespaces
+	// for automatic sidecar injection
+	NamespaceInjectionLabel = "istio-injection"
+
+	// NamespaceInjectionEnabled is the value of the namespace label enabling
+	// automatic sidecar injection
+	NamespaceInjectionEnabled = "enabled"
+
+	jsonPatchType = "JSONPatch"
+)
+
+// admissionReview mirrors the wire format of the admission review exchanged
+// with a mutating admission webhook (admission.k8s.io/v1beta1), which is
+// newer than the vendored Kubernetes API types
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *admissionRequest  `json:"request,omitempty"`
+	Response        *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string                  `json:"uid"`
+	Kind      metav1.GroupVersionKind `json:"kind"`
+	Namespace string                  `json:"namespace,omitempty"`
+	Name      string                  `json:"name,omitempty"`
+	Operation string                  `json:"operation"`
+	Object    json.RawMessage         `json:"object,omitempty"`
+}
+
+type admissionResponse struct {
+	UID       string         `json:"uid"`
+	Allowed   bool           `json:"allowed"`
+	Result    *metav1.Status `json:"status,omitempty"`
+	Patch     []byte         `json:"patch,omitempty"`
+	PatchType string         `json:"patchType,omitempty"`
+}
+
+// patchOperation is a JSON patch (RFC 6902) operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Webhook injects the sidecar proxy into pods at admission time. Pods are
+// injected if their namespace has the label "istio-injection=enabled",
+// unless the pod opts out with the annotation "alpha.istio.io/sidecar",
+// e.g. with the value "ignored", or already has the sidecar.
+type Webhook struct {
+	params *Params
+	client kubernetes.Interface
+}
+
+// NewWebhook creates a sidecar injection webhook with the injection
+// parameters. The client is used to look up the namespace labels.
+func NewWebhook(p *Params, client kubernetes.Interface) *Webhook {
+	return &Webhook{
+		params: p,
+		client: client,
+	}
+}
+
+// injectRequired checks that the pod namespace selects automatic injection
+func (wh *Webhook) injectRequired(namespace string) (bool, error) {
+	ns, err := wh.client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return ns.Labels[NamespaceInjectionLabel] == NamespaceInjectionEnabled, nil
+}
+
+// admit responds to a pod creation with a patch injecting the sidecar. Pods
+// with invalid injection annotations are rejected. Otherwise, pods are
+// always admitted, with failures logged and the pod left unmodified, so that
+// the webhook does not block workloads.
+func (wh *Webhook) admit(req *admissionRequest) *admissionResponse {
+	out := &admissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Kind != "Pod" || req.Operation != "CREATE" {
+		return out
+	}
+
+	namespace := req.Namespace
+	var pod v1.Pod
+	if err := json.Unmarshal(req.Object, &pod); err != nil {
+		glog.Warningf("Cannot decode pod %s/%s: %v", namespace, req.Name, err)
+		return out
+	}
+	if namespace == "" {
+		namespace = pod.Namespace
+	}
+
+	required, err := wh.injectRequired(namespace)
+	if err != nil {
+		glog.Warningf("Cannot look up namespace %q: %v", namespace, err)
+		return out
+	}
+	if !required {
+		return out
+	}
+
+	original := &v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}
+	injected := original.DeepCopy()
+	if err = injectIntoPodTemplateSpec(wh.params, injected); err != nil {
+		// reject pods with invalid injection annotations
+		glog.V(2).Infof("Cannot inject sidecar into pod %s/%s: %v", namespace, pod.Name, err)
+		out.Allowed = false
+		out.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+		return out
+	}
+
+	patch, err := createPatch(original, injected)
+	if err != nil {
+		glog.Warningf("Cannot create patch for pod %s/%s: %v", namespace, pod.Name, err)
+		return out
+	}
+	if patch != nil {
+		glog.V(2).Infof("Injecting sidecar into pod %s/%s%s", namespace, pod.GenerateName, pod.Name)
+		out.Patch = patch
+		out.PatchType = jsonPatchType
+	}
+	return out
+}
+
+// createPatch computes the JSON patch from the original to the injected pod
+// template, or returns nil if the template is unchanged. Injection only
+// appends to the lists and sets annotations.
+func createPatch(original, injected *v1.PodTemplateSpec) ([]byte, error) {
+	if len(original.Spec.Containers) == len(injected.Spec.Containers) {
+		// sidecar is already present or ignored
+		return nil, nil
+	}
+
+	var ops []patchOperation
+	if len(original.Annotations) == 0 {
+		ops = append(ops, patchOperation{Op: "add", Path: "/metadata/annotations", Value: injected.Annotations})
+	} else {
+		keys := make([]string, 0, len(injected.Annotations))
+		for key := range injected.Annotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			op := "add"
+			if value, exists := original.Annotations[key]; exists {
+				if value == injected.Annotations[key] {
+					continue
+				}
+				op = "replace"
+			}
+			ops = append(ops, patchOperation{
+				Op:    op,
+				Path:  "/metadata/annotations/" + escapeJSONPointer(key),
+				Value: injected.Annotations[key],
+			})
+		}
+	}
+
+	initContainers := make([]interface{}, 0)
+	for _, container := range injected.Spec.InitContainers[len(original.Spec.InitContainers):] {
+		initContainers = append(initContainers, container)
+	}
+	ops = append(ops, appendPatch("/spec/initContainers", len(original.Spec.InitContainers), initContainers)...)
+
+	containers := make([]interface{}, 0)
+	for _, container := range injected.Spec.Containers[len(original.Spec.Containers):] {
+		containers = append(containers, container)
+	}
+	ops = append(ops, appendPatch("/spec/containers", len(original.Spec.Containers), containers)...)
+
+	volumes := make([]interface{}, 0)
+	for _, volume := range injected.Spec.Volumes[len(original.Spec.Volumes):] {
+		volumes = append(volumes, volume)
+	}
+	ops = append(ops, appendPatch("/spec/volumes", len(original.Spec.Volumes), volumes)...)
+
+	return json.Marshal(ops)
+}
+
+// appendPatch creates the operations appending values to a list of the given
+// length, creating the list if it is empty
+func appendPatch(path string, length int, values []interface{}) []patchOperation {
+	if len(values) == 0 {
+		return nil
+	}
+	if length == 0 {
+		return []patchOperation{{Op: "add", Path: path, Value: values}}
+	}
+	out := make([]patchOperation, 0, len(values))
+	for _, value := range values {
+		out = append(out, patchOperation{Op: "add", Path: path + "/-", Value: value})
+	}
+	return out
+}
+
+// escapeJSONPointer escapes a reference token in a JSON pointer (RFC 6901)
+func escapeJSONPointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// ServeHTTP decodes an admission review request and responds with the review
+// response
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var review admissionReview
+	if err = json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("cannot decode admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = wh.admit(review.Request)
+	review.Request = nil
+
+	out, err := json.Marshal(&review)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(out); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// Run serves the injection webhook over HTTPS at the port with the TLS
+// certificate and key files until the stop channel is closed
+func (wh *Webhook) Run(port int, certFile, keyFile string, stop <-chan struct{}) {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: wh,
+	}
+	go func() {
+		<-stop
+		if err := server.Close(); err != nil {
+			glog.Warning(err)
+		}
+	}()
+
+	glog.Infof("Starting sidecar injection webhook at %v", server.Addr)
+	if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+		glog.Warning(err)
+	}
+}
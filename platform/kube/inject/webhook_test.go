@@ -0,0 +1,192 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/pilot/proxy"
+)
+
+func makeWebhook(t *testing.T) *Webhook {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "injected",
+			Labels: map[string]string{NamespaceInjectionLabel: NamespaceInjectionEnabled},
+		}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+	)
+	mesh := proxy.DefaultMeshConfig()
+	return NewWebhook(&Params{
+		InitImage:         InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:        ProxyImageName(unitTestHub, unitTestTag),
+		ImagePullPolicy:   "IfNotPresent",
+		Verbosity:         DefaultVerbosity,
+		SidecarProxyUID:   DefaultSidecarProxyUID,
+		Version:           "12345678",
+		Mesh:              &mesh,
+		MeshConfigMapName: "istio",
+	}, client)
+}
+
+func makePodRequest(t *testing.T, namespace string, annotations map[string]string) *admissionRequest {
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "hello",
+			Annotations: annotations,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}},
+		},
+	}
+	raw, err := json.Marshal(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionRequest{
+		UID:       "uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace: namespace,
+		Name:      "hello",
+		Operation: "CREATE",
+		Object:    raw,
+	}
+}
+
+func TestWebhookAdmit(t *testing.T) {
+	wh := makeWebhook(t)
+
+	service := makePodRequest(t, "injected", nil)
+	service.Kind.Kind = "Service"
+
+	cases := []struct {
+		name    string
+		request *admissionRequest
+		// want is the set of patched paths
+		want map[string]string
+	}{
+		{
+			name:    "injected namespace",
+			request: makePodRequest(t, "injected", nil),
+			want: map[string]string{
+				"/metadata/annotations": "add",
+				"/spec/initContainers":  "add",
+				"/spec/containers/-":    "add",
+				"/spec/volumes":         "add",
+			},
+		},
+		{
+			name:    "injected namespace with annotations",
+			request: makePodRequest(t, "injected", map[string]string{"app": "hello"}),
+			want: map[string]string{
+				"/metadata/annotations/alpha.istio.io~1sidecar": "add",
+				"/metadata/annotations/alpha.istio.io~1version": "add",
+				"/spec/initContainers":                          "add",
+				"/spec/containers/-":                            "add",
+				"/spec/volumes":                                 "add",
+			},
+		},
+		{
+			name:    "opt-out annotation",
+			request: makePodRequest(t, "injected", map[string]string{istioSidecarAnnotationSidecarKey: "ignored"}),
+		},
+		{
+			name:    "plain namespace",
+			request: makePodRequest(t, "plain", nil),
+		},
+		{
+			name:    "missing namespace",
+			request: makePodRequest(t, "missing", nil),
+		},
+		{
+			name:    "other kind",
+			request: service,
+		},
+	}
+
+	for _, c := range cases {
+		resp := wh.admit(c.request)
+		if !resp.Allowed || resp.UID != c.request.UID {
+			t.Errorf("%s: admit() => got %#v, want allowed response", c.name, resp)
+			continue
+		}
+		if len(c.want) == 0 {
+			if resp.Patch != nil {
+				t.Errorf("%s: admit() => got patch %s, want none", c.name, resp.Patch)
+			}
+			continue
+		}
+
+		if resp.PatchType != jsonPatchType {
+			t.Errorf("%s: admit() => got patch type %q", c.name, resp.PatchType)
+		}
+		var ops []patchOperation
+		if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, op := range ops {
+			got[op.Path] = op.Op
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: admit() => got patch %s, want paths %v", c.name, resp.Patch, c.want)
+		}
+		for path, op := range c.want {
+			if got[path] != op {
+				t.Errorf("%s: admit() => got %q for %q, want %q", c.name, got[path], path, op)
+			}
+		}
+	}
+}
+
+func TestWebhookServeHTTP(t *testing.T) {
+	ts := httptest.NewServer(makeWebhook(t))
+	defer ts.Close()
+
+	body, err := json.Marshal(&admissionReview{Request: makePodRequest(t, "injected", nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	var review admissionReview
+	if err = json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil || !review.Response.Allowed || review.Response.UID != "uid" ||
+		len(review.Response.Patch) == 0 {
+		t.Errorf("ServeHTTP() => got response %#v", review.Response)
+	}
+}
+
+func TestWebhookInvalidAnnotations(t *testing.T) {
+	wh := makeWebhook(t)
+	resp := wh.admit(makePodRequest(t, "injected", map[string]string{ExcludeInboundPortsAnnotation: "http"}))
+	if resp.Allowed || resp.Result == nil || resp.Patch != nil {
+		t.Errorf("admit() => got %#v, want rejection", resp)
+	}
+}
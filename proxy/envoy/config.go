@@ -137,8 +137,10 @@ func buildClusters(env proxy.Environment, role proxy.Node) (clusters Clusters) {
 	}
 
 	// apply custom policies for outbound clusters
+	zone := proxyZone(env.HostInstances(map[string]bool{role.IPAddress: true}))
+	policies := env.IstioConfigStore.DestinationPolicyIndex()
 	for _, cluster := range clusters {
-		applyClusterPolicy(cluster, env.IstioConfigStore, env.Mesh, env.ServiceAccounts)
+		applyClusterPolicy(cluster, policies, env.Mesh, env.ServiceAccounts, env.ServiceDiscovery, zone)
 	}
 
 	// append Mixer service definition if necessary
@@ -160,10 +162,13 @@ func buildSidecar(env proxy.Environment, sidecar proxy.Node) (Listeners, Cluster
 
 	inbound, inClusters := buildInboundListeners(env.Mesh, sidecar, instances)
 	outbound, outClusters := buildOutboundListeners(env.Mesh, sidecar, instances, services, env)
+	headless, headlessClusters := buildHeadlessTCPListeners(env.Mesh, services, env)
 	mgmtListeners, mgmtClusters := buildMgmtPortListeners(env.Mesh, managementPorts, sidecar.IPAddress)
 
-	listeners := append(inbound, outbound...)
-	clusters := append(inClusters, outClusters...)
+	// inbound listeners take precedence over the listeners for the endpoints of
+	// headless services co-located with the proxy instance
+	listeners := append(append(inbound, outbound...), headless...)
+	clusters := append(append(inClusters, outClusters...), headlessClusters...)
 
 	// If management listener port and service port are same, bad things happen
 	// when running in kubernetes, as the probes stop responding. So, append
@@ -308,7 +313,7 @@ func buildTCPListener(tcpConfig *TCPRouteConfig, ip string, port int) *Listener
 // buildOutboundListeners combines HTTP routes and TCP listeners
 func buildOutboundListeners(mesh *proxyconfig.ProxyMeshConfig, sidecar proxy.Node, instances []*model.ServiceInstance,
 	services []*model.Service, config model.IstioConfigStore) (Listeners, Clusters) {
-	listeners, clusters := buildOutboundTCPListeners(mesh, services)
+	listeners, clusters := buildOutboundTCPListeners(mesh, instances, services, config)
 
 	// note that outbound HTTP routes are supplied through RDS
 	httpOutbound := buildOutboundHTTPRoutes(mesh, sidecar, instances, services, config)
@@ -323,7 +328,8 @@ func buildOutboundListeners(mesh *proxyconfig.ProxyMeshConfig, sidecar proxy.Nod
 // buildDestinationHTTPRoutes creates HTTP route for a service and a port from rules
 func buildDestinationHTTPRoutes(service *model.Service,
 	servicePort *model.Port,
-	rules []*proxyconfig.RouteRule) []*HTTPRoute {
+	rules []model.Config,
+	policies *model.DestinationPolicyIndex) []*HTTPRoute {
 	protocol := servicePort.Protocol
 	switch protocol {
 	case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC:
@@ -331,9 +337,10 @@ func buildDestinationHTTPRoutes(service *model.Service,
 
 		// collect route rules
 		useDefaultRoute := true
-		for _, rule := range rules {
+		for _, ruleConfig := range rules {
+			rule := ruleConfig.Content.(*proxyconfig.RouteRule)
 			if rule.Destination == service.Hostname {
-				httpRoute := buildHTTPRoute(rule, servicePort)
+				httpRoute := buildHTTPRoute(ruleConfig, servicePort)
 				routes = append(routes, httpRoute)
 
 				// User can provide timeout/retry policies without any match condition,
@@ -356,6 +363,14 @@ func buildDestinationHTTPRoutes(service *model.Service,
 			routes = append(routes, buildDefaultRoute(cluster))
 		}
 
+		// consistent hash load balancing requires the hash policy of the
+		// destinations of each route
+		if !service.External() {
+			for _, route := range routes {
+				route.HashPolicy = buildRouteHashPolicy(route, policies)
+			}
+		}
+
 		return routes
 
 	case model.ProtocolHTTPS:
@@ -384,6 +399,7 @@ func buildOutboundHTTPRoutes(mesh *proxyconfig.ProxyMeshConfig, sidecar proxy.No
 
 	// get all the route rules applicable to the instances
 	rules := config.RouteRulesBySource(instances)
+	policies := config.DestinationPolicyIndex()
 
 	// outbound connections/requests are directed to service ports; we create a
 	// map for each service port to define filters
@@ -394,7 +410,7 @@ func buildOutboundHTTPRoutes(mesh *proxyconfig.ProxyMeshConfig, sidecar proxy.No
 				continue
 			}
 
-			routes := buildDestinationHTTPRoutes(service, servicePort, rules)
+			routes := buildDestinationHTTPRoutes(service, servicePort, rules, policies)
 
 			if len(routes) > 0 {
 				// must use egress proxy to route external name services
@@ -427,6 +443,43 @@ func buildOutboundHTTPRoutes(mesh *proxyconfig.ProxyMeshConfig, sidecar proxy.No
 	return httpConfigs
 }
 
+// buildDestinationTCPRoutes creates TCP routes for a service and a port from
+// rules. Envoy selects the first matching route, so the routes are listed in
+// the order of the rule precedence with the default route last.
+func buildDestinationTCPRoutes(service *model.Service, servicePort *model.Port,
+	rules []model.Config) []*TCPRoute {
+	routes := make([]*TCPRoute, 0)
+	for _, config := range rules {
+		rule := config.Content.(*proxyconfig.RouteRule)
+		if rule.Destination != service.Hostname {
+			continue
+		}
+
+		// HTTP match conditions can not be applied to TCP connections
+		if len(rule.GetMatch().GetHttpHeaders()) > 0 {
+			continue
+		}
+
+		route, err := buildTCPRouteFromRule(rule, servicePort, service.Address)
+		if err != nil {
+			glog.Warningf("Skipping route rule %q for TCP port %d of %s: %v",
+				config.Key, servicePort.Port, service.Hostname, err)
+			continue
+		}
+		routes = append(routes, route)
+
+		// a rule without source subnets matches all connections to the service
+		// address and shadows the rules of lower precedence and the default route
+		if len(rule.GetMatch().GetTcp().GetSourceSubnet()) == 0 {
+			return routes
+		}
+	}
+
+	// default route for the destination is always the lowest priority route
+	cluster := buildOutboundCluster(service.Hostname, servicePort, nil)
+	return append(routes, buildTCPRoute(cluster, []string{service.Address}))
+}
+
 // buildOutboundTCPListeners lists listeners and referenced clusters for TCP
 // protocols (including HTTPS)
 //
@@ -439,21 +492,31 @@ func buildOutboundHTTPRoutes(mesh *proxyconfig.ProxyMeshConfig, sidecar proxy.No
 //
 // Temporary workaround is to add a listener for each service IP that requires
 // TCP routing
-func buildOutboundTCPListeners(mesh *proxyconfig.ProxyMeshConfig, services []*model.Service) (Listeners, Clusters) {
+func buildOutboundTCPListeners(mesh *proxyconfig.ProxyMeshConfig, instances []*model.ServiceInstance,
+	services []*model.Service, config model.IstioConfigStore) (Listeners, Clusters) {
 	tcpListeners := make(Listeners, 0)
 	tcpClusters := make(Clusters, 0)
+
+	// get all the route rules applicable to the instances
+	rules := config.RouteRulesBySource(instances)
+
 	for _, service := range services {
 		if service.External() {
 			continue // TODO TCP external services not currently supported
 		}
+		if service.Headless {
+			continue // handled by buildHeadlessTCPListeners
+		}
 		for _, servicePort := range service.Ports {
 			switch servicePort.Protocol {
 			case model.ProtocolTCP, model.ProtocolHTTPS:
-				cluster := buildOutboundCluster(service.Hostname, servicePort, nil)
-				route := buildTCPRoute(cluster, []string{service.Address})
-				config := &TCPRouteConfig{Routes: []*TCPRoute{route}}
+				routes := buildDestinationTCPRoutes(service, servicePort, rules)
+				config := &TCPRouteConfig{Routes: routes}
 				listener := buildTCPListener(config, service.Address, servicePort.Port)
-				tcpClusters = append(tcpClusters, cluster)
+
+				for _, route := range routes {
+					tcpClusters = append(tcpClusters, route.clusters...)
+				}
 				tcpListeners = append(tcpListeners, listener)
 			}
 		}
@@ -461,6 +524,37 @@ func buildOutboundTCPListeners(mesh *proxyconfig.ProxyMeshConfig, services []*mo
 	return tcpListeners, tcpClusters
 }
 
+// buildHeadlessTCPListeners lists listeners and referenced clusters for the
+// TCP ports of headless services. Clients connect directly to the instance
+// endpoints of headless services (e.g. members of a stateful set), so the proxy
+// listens on every endpoint address and forwards the connections to their
+// original destination. Route rules do not apply to these listeners.
+func buildHeadlessTCPListeners(mesh *proxyconfig.ProxyMeshConfig, services []*model.Service,
+	discovery model.ServiceDiscovery) (Listeners, Clusters) {
+	listeners := make(Listeners, 0)
+	clusters := make(Clusters, 0)
+
+	for _, service := range services {
+		if !service.Headless {
+			continue
+		}
+		for _, servicePort := range service.Ports {
+			switch servicePort.Protocol {
+			case model.ProtocolTCP, model.ProtocolHTTPS:
+				cluster := buildOutboundOriginalDSTCluster(service.Hostname, servicePort, mesh.ConnectTimeout)
+				for _, instance := range discovery.Instances(service.Hostname, []string{servicePort.Name}, nil) {
+					endpoint := instance.Endpoint
+					route := buildTCPRoute(cluster, []string{endpoint.Address})
+					config := &TCPRouteConfig{Routes: []*TCPRoute{route}}
+					listeners = append(listeners, buildTCPListener(config, endpoint.Address, endpoint.Port))
+				}
+				clusters = append(clusters, cluster)
+			}
+		}
+	}
+	return listeners, clusters
+}
+
 // buildInboundListeners creates listeners for the server-side (inbound)
 // configuration for co-located service instances. The function also returns
 // all inbound clusters since they are statically declared in the proxy
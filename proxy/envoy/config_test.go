@@ -22,12 +22,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
 	"istio.io/pilot/model"
 	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
 	"istio.io/pilot/test/util"
 )
 
@@ -218,6 +221,128 @@ func TestTCPRouteConfigByRoute(t *testing.T) {
 	}
 }
 
+func TestBuildDestinationTCPRoutes(t *testing.T) {
+	service := mock.WorldService
+	port := service.Ports[2]
+	name := func(tags model.Tags) string {
+		return buildOutboundCluster(service.Hostname, port, tags).Name
+	}
+	v0, v1 := model.Tags{"version": "v0"}, model.Tags{"version": "v1"}
+
+	cases := []struct {
+		name  string
+		rules []*proxyconfig.RouteRule
+		want  []*TCPRoute
+	}{
+		{
+			name: "default route",
+			want: []*TCPRoute{{Cluster: name(nil), DestinationIPList: []string{"10.2.0.0/32"}}},
+		},
+		{
+			name: "subnet match and single weighted route",
+			rules: []*proxyconfig.RouteRule{{
+				Destination: service.Hostname,
+				Match: &proxyconfig.MatchCondition{Tcp: &proxyconfig.L4MatchAttributes{
+					SourceSubnet:      []string{"10.1.1.1", "10.1.0.0/16"},
+					DestinationSubnet: []string{"10.2.0.0/24"},
+				}},
+				Route: []*proxyconfig.DestinationWeight{
+					{Tags: v0, Weight: 100},
+					{Tags: v1, Weight: 0},
+				},
+			}, {
+				Destination: mock.HelloService.Hostname,
+			}},
+			want: []*TCPRoute{{
+				Cluster:           name(v0),
+				DestinationIPList: []string{"10.2.0.0/32"},
+				SourceIPList:      []string{"10.1.0.0/16", "10.1.1.1/32"},
+			}, {
+				Cluster:           name(nil),
+				DestinationIPList: []string{"10.2.0.0/32"},
+			}},
+		},
+		{
+			name: "destination subnet without service address ignored",
+			rules: []*proxyconfig.RouteRule{{
+				Destination: service.Hostname,
+				Match: &proxyconfig.MatchCondition{Tcp: &proxyconfig.L4MatchAttributes{
+					DestinationSubnet: []string{"10.3.0.0/16", "10.2.0.1"},
+				}},
+				Route: []*proxyconfig.DestinationWeight{{Tags: v1}},
+			}},
+			want: []*TCPRoute{{Cluster: name(nil), DestinationIPList: []string{"10.2.0.0/32"}}},
+		},
+		{
+			name: "destination subnet match shadows lower rules",
+			rules: []*proxyconfig.RouteRule{{
+				Destination: service.Hostname,
+				Match: &proxyconfig.MatchCondition{Tcp: &proxyconfig.L4MatchAttributes{
+					DestinationSubnet: []string{"10.2.0.0"},
+				}},
+				Route: []*proxyconfig.DestinationWeight{{Tags: v1}},
+			}, {
+				Destination: service.Hostname,
+				Route:       []*proxyconfig.DestinationWeight{{Tags: v0}},
+			}},
+			want: []*TCPRoute{{Cluster: name(v1), DestinationIPList: []string{"10.2.0.0/32"}}},
+		},
+		{
+			name: "traffic split ignored",
+			rules: []*proxyconfig.RouteRule{{
+				Destination: service.Hostname,
+				Route: []*proxyconfig.DestinationWeight{
+					{Tags: v0, Weight: 75},
+					{Tags: v1, Weight: 25},
+				},
+			}, {
+				Destination: service.Hostname,
+				Route:       []*proxyconfig.DestinationWeight{{Tags: v1}},
+			}},
+			want: []*TCPRoute{{Cluster: name(v1), DestinationIPList: []string{"10.2.0.0/32"}}},
+		},
+		{
+			name: "catch all version route",
+			rules: []*proxyconfig.RouteRule{{
+				Destination: service.Hostname,
+				Route:       []*proxyconfig.DestinationWeight{{Tags: v1}},
+			}, {
+				Destination: service.Hostname,
+				Route:       []*proxyconfig.DestinationWeight{{Tags: v0}},
+			}},
+			want: []*TCPRoute{{Cluster: name(v1), DestinationIPList: []string{"10.2.0.0/32"}}},
+		},
+		{
+			name: "HTTP match ignored",
+			rules: []*proxyconfig.RouteRule{{
+				Destination: service.Hostname,
+				Match: &proxyconfig.MatchCondition{HttpHeaders: map[string]*proxyconfig.StringMatch{
+					"uri": {MatchType: &proxyconfig.StringMatch_Prefix{Prefix: "/"}},
+				}},
+				Route: []*proxyconfig.DestinationWeight{{Tags: v1}},
+			}},
+			want: []*TCPRoute{{Cluster: name(nil), DestinationIPList: []string{"10.2.0.0/32"}}},
+		},
+	}
+
+	for _, c := range cases {
+		rules := make([]model.Config, 0, len(c.rules))
+		for _, rule := range c.rules {
+			rules = append(rules, model.Config{Type: model.RouteRule.Type, Key: rule.Name, Content: rule})
+		}
+		routes := buildDestinationTCPRoutes(service, port, rules)
+		for _, route := range routes {
+			if len(route.clusters) == 0 {
+				t.Errorf("%s: missing cluster references for route %#v", c.name, route)
+			}
+			route.clusters = nil
+		}
+		if !reflect.DeepEqual(routes, c.want) {
+			t.Errorf("%s: buildDestinationTCPRoutes => got %s, want %s", c.name, spew.Sdump(routes), spew.Sdump(c.want))
+		}
+	}
+}
+
 const (
 	envoyConfig = "testdata/envoy.json"
 
@@ -246,7 +371,7 @@ func addCircuitBreaker(r model.ConfigStore, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err = r.Post(msg); err != nil {
+	if _, err = r.Post(model.Config{Content: msg}); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -256,7 +381,7 @@ func addRewrite(r model.ConfigStore, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err = r.Post(msg); err != nil {
+	if _, err = r.Post(model.Config{Content: msg}); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -266,7 +391,7 @@ func addRedirect(r model.ConfigStore, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err = r.Post(msg); err != nil {
+	if _, err = r.Post(model.Config{Content: msg}); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -276,7 +401,7 @@ func addTimeout(r model.ConfigStore, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err = r.Post(msg); err != nil {
+	if _, err = r.Post(model.Config{Content: msg}); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -286,7 +411,7 @@ func addWeightedRoute(r model.ConfigStore, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err = r.Post(msg); err != nil {
+	if _, err = r.Post(model.Config{Content: msg}); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -296,11 +421,43 @@ func addFaultRoute(r model.ConfigStore, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err = r.Post(msg); err != nil {
+	if _, err = r.Post(model.Config{Content: msg}); err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestHeadlessTCPListeners(t *testing.T) {
+	mesh := makeMeshConfig()
+	headless := *mock.WorldService
+	headless.Address = ""
+	headless.Headless = true
+
+	listeners, clusters := buildHeadlessTCPListeners(&mesh,
+		[]*model.Service{mock.HelloService, &headless}, mock.Discovery)
+
+	// one original destination cluster for the TCP port of the headless service
+	if len(clusters) != 1 || clusters[0].Type != ClusterTypeOriginalDST || clusters[0].LbType != LbTypeOriginalDST ||
+		clusters[0].hostname != headless.Hostname || !clusters[0].outbound {
+		t.Fatalf("buildHeadlessTCPListeners => got clusters %s", spew.Sdump(clusters))
+	}
+
+	// one listener per endpoint of the headless service
+	instances := mock.Discovery.Instances(headless.Hostname, []string{"custom"}, nil)
+	if len(listeners) != len(instances) {
+		t.Fatalf("buildHeadlessTCPListeners => got %d listeners, want %d", len(listeners), len(instances))
+	}
+	for i, instance := range instances {
+		address := fmt.Sprintf("tcp://%s:%d", instance.Endpoint.Address, instance.Endpoint.Port)
+		if listeners[i].Address != address {
+			t.Errorf("buildHeadlessTCPListeners => got listener %q, want %q", listeners[i].Address, address)
+		}
+		config := listeners[i].Filters[0].Config.(*TCPProxyFilterConfig)
+		if route := config.RouteConfig.Routes[0]; route.Cluster != clusters[0].Name {
+			t.Errorf("buildHeadlessTCPListeners => got route to %q, want %q", route.Cluster, clusters[0].Name)
+		}
+	}
+}
+
 func makeMeshConfig() proxyconfig.ProxyMeshConfig {
 	mesh := proxy.DefaultMeshConfig()
 	mesh.MixerAddress = "localhost:9091"
@@ -23,11 +23,13 @@ import (
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
 
+	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/model"
 	"istio.io/pilot/proxy"
 )
@@ -37,16 +39,52 @@ type DiscoveryService struct {
 	proxy.Environment
 	server *http.Server
 
-	// TODO Profile and optimize cache eviction policy to avoid
-	// flushing the entire cache when any route, service, or endpoint
-	// changes. An explicit cache expiration policy should be
-	// considered with this change to avoid memory exhaustion as the
-	// entire cache will no longer be periodically flushed and stale
-	// entries can linger in the cache indefinitely.
+	// Cached responses record the dependencies of the generated
+	// configuration, so that a change only evicts the responses that
+	// depend on the changed services or configuration.
 	sdsCache *discoveryCache
 	cdsCache *discoveryCache
 	rdsCache *discoveryCache
 	ldsCache *discoveryCache
+
+	// nodeDeps holds the dependencies of the configuration per proxy node
+	depsMutex sync.Mutex
+	nodeDeps  map[string]map[string]bool
+
+	// configDeps holds the dependencies of the last known version of a
+	// configuration object by type and key
+	configMutex sync.Mutex
+	configDeps  map[string]map[string]bool
+
+	// pending dependencies are invalidated once events settle for
+	// debounceAfter, but no later than debounceMax after the first event
+	debounceAfter time.Duration
+	debounceMax   time.Duration
+	pendingMutex  sync.Mutex
+	pending       map[string]bool
+	pendingSince  time.Time
+	pendingTimer  *time.Timer
+
+	// includeUnhealthy lists the unhealthy instances in the SDS responses
+	includeUnhealthy bool
+}
+
+// Dependency keys in addition to service hostnames
+const (
+	// allDependencies invalidates all cached responses
+	allDependencies = "*"
+
+	// ingressDependency is recorded by the ingress proxy configuration
+	ingressDependency = "ingress"
+
+	// unregisteredDependency is recorded by the sidecar proxy configuration
+	// when the proxy has no service instances yet
+	unregisteredDependency = "unregistered"
+)
+
+// nodeDependency is the dependency key for the proxy with an IP address
+func nodeDependency(addr string) string {
+	return "node/" + addr
 }
 
 type discoveryCacheStatEntry struct {
@@ -60,6 +98,8 @@ type discoveryCacheStats struct {
 
 type discoveryCacheEntry struct {
 	data []byte
+	// deps of the response, the response depends on everything if nil
+	deps map[string]bool
 	hit  uint64 // atomic
 	miss uint64 // atomic
 }
@@ -95,7 +135,7 @@ func (c *discoveryCache) cachedDiscoveryResponse(key string) ([]byte, bool) {
 	return entry.data, true
 }
 
-func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte) {
+func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte, deps map[string]bool) {
 	if c.disabled {
 		return
 	}
@@ -111,6 +151,7 @@ func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte)
 		glog.Warningf("Overriding cached data for entry %v", key)
 	}
 	entry.data = data
+	entry.deps = deps
 	atomic.AddUint64(&entry.miss, 1)
 }
 
@@ -122,6 +163,29 @@ func (c *discoveryCache) clear() {
 	}
 }
 
+// clearDependencies evicts the entries that depend on any of the keys
+func (c *discoveryCache) clearDependencies(keys map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, v := range c.cache {
+		if v.data != nil && (v.deps == nil || intersects(v.deps, keys)) {
+			v.data = nil
+		}
+	}
+}
+
+func intersects(a, b map[string]bool) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	for key := range a {
+		if b[key] {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *discoveryCache) resetStats() {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -155,6 +219,16 @@ type host struct {
 	Tags    *tags  `json:"tags,omitempty"`
 }
 
+const (
+	// healthyHostWeight is the SDS load balancing weight of the healthy hosts
+	// listed along with unhealthy hosts
+	healthyHostWeight = 100
+
+	// unhealthyHostWeight is the minimal SDS load balancing weight given to
+	// the unhealthy hosts
+	unhealthyHostWeight = 1
+)
+
 type tags struct {
 	AZ     string `json:"az,omitempty"`
 	Canary bool   `json:"canary,omitempty"`
@@ -186,6 +260,21 @@ type DiscoveryServiceOptions struct {
 	Port            int
 	EnableProfiling bool
 	EnableCaching   bool
+
+	// DebounceAfter is the quiet period after an event before the cache is
+	// invalidated, 0 invalidates the cache on every event
+	DebounceAfter time.Duration
+
+	// DebounceMax bounds the delay of the invalidation after the first
+	// event of a burst
+	DebounceMax time.Duration
+
+	// IncludeUnhealthyHosts lists the instances reported unhealthy by the
+	// registries in the SDS responses instead of dropping them, e.g. if the
+	// registry health reports are not reliable. SDS hosts carry no health
+	// status, so the unhealthy instances are listed with the minimal load
+	// balancing weight and receive only a small share of the requests.
+	IncludeUnhealthyHosts bool
 }
 
 // NewDiscoveryService creates an Envoy discovery service on a given port
@@ -197,6 +286,14 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 		cdsCache:    newDiscoveryCache(o.EnableCaching),
 		rdsCache:    newDiscoveryCache(o.EnableCaching),
 		ldsCache:    newDiscoveryCache(o.EnableCaching),
+
+		nodeDeps:      make(map[string]map[string]bool),
+		configDeps:    make(map[string]map[string]bool),
+		debounceAfter: o.DebounceAfter,
+		debounceMax:   o.DebounceMax,
+		pending:       make(map[string]bool),
+
+		includeUnhealthy: o.IncludeUnhealthyHosts,
 	}
 	container := restful.NewContainer()
 	if o.EnableProfiling {
@@ -209,19 +306,38 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 	out.Register(container)
 	out.server = &http.Server{Addr: ":" + strconv.Itoa(o.Port), Handler: container}
 
-	// Flush cached discovery responses whenever services, service
-	// instances, or routing configuration changes.
-	serviceHandler := func(s *model.Service, e model.Event) { out.clearCache() }
+	// Evict cached discovery responses that depend on the services, service
+	// instances, or routing configuration that changed.
+	serviceHandler := func(s *model.Service, e model.Event) {
+		// a new service is visible to all proxies
+		if s == nil || s.Hostname == "" || e == model.EventAdd {
+			out.invalidate(allDependencies)
+			return
+		}
+		out.invalidate(s.Hostname)
+	}
 	if err := ctl.AppendServiceHandler(serviceHandler); err != nil {
 		return nil, err
 	}
-	instanceHandler := func(s *model.ServiceInstance, e model.Event) { out.clearCache() }
+	instanceHandler := func(i *model.ServiceInstance, e model.Event) {
+		if i == nil || i.Service == nil || i.Service.Hostname == "" {
+			out.invalidate(allDependencies)
+			return
+		}
+		deps := []string{i.Service.Hostname, unregisteredDependency}
+		if i.Endpoint.Address != "" {
+			deps = append(deps, nodeDependency(i.Endpoint.Address))
+		}
+		out.invalidate(deps...)
+	}
 	if err := ctl.AppendInstanceHandler(instanceHandler); err != nil {
 		return nil, err
 	}
 
 	if configCache != nil {
-		configHandler := func(model.Config, model.Event) { out.clearCache() }
+		configHandler := func(config model.Config, e model.Event) {
+			out.invalidate(out.configDependencies(config, e)...)
+		}
 		configCache.RegisterEventHandler(model.RouteRule.Type, configHandler)
 		configCache.RegisterEventHandler(model.IngressRule.Type, configHandler)
 		configCache.RegisterEventHandler(model.DestinationPolicy.Type, configHandler)
@@ -230,6 +346,146 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 	return out, nil
 }
 
+// configDependencies returns the dependency keys affected by a configuration
+// event, including the keys of the previous version of the object.
+func (ds *DiscoveryService) configDependencies(config model.Config, e model.Event) []string {
+	deps := make(map[string]bool)
+	switch rule := config.Content.(type) {
+	case *proxyconfig.RouteRule:
+		deps[rule.Destination] = true
+		for _, route := range rule.Route {
+			if route.Destination != "" {
+				deps[route.Destination] = true
+			}
+		}
+		if rule.Match != nil && rule.Match.Source != "" {
+			deps[rule.Match.Source] = true
+		}
+	case *proxyconfig.DestinationPolicy:
+		deps[rule.Destination] = true
+	case *proxyconfig.IngressRule:
+		deps[ingressDependency] = true
+	default:
+		deps[allDependencies] = true
+	}
+
+	key := config.Type + "/" + config.QualifiedKey()
+	ds.configMutex.Lock()
+	previous := ds.configDeps[key]
+	if e == model.EventDelete {
+		delete(ds.configDeps, key)
+	} else {
+		ds.configDeps[key] = deps
+	}
+	ds.configMutex.Unlock()
+
+	out := make([]string, 0, len(deps)+len(previous))
+	for dep := range deps {
+		out = append(out, dep)
+	}
+	for dep := range previous {
+		if !deps[dep] {
+			out = append(out, dep)
+		}
+	}
+	return out
+}
+
+// invalidate schedules the eviction of the cached responses that depend on
+// the keys. Event bursts are coalesced into a single eviction.
+func (ds *DiscoveryService) invalidate(deps ...string) {
+	ds.pendingMutex.Lock()
+	defer ds.pendingMutex.Unlock()
+	for _, dep := range deps {
+		ds.pending[dep] = true
+	}
+
+	if ds.debounceAfter <= 0 {
+		ds.flushLocked()
+		return
+	}
+
+	now := time.Now()
+	if ds.pendingTimer == nil {
+		ds.pendingSince = now
+		ds.pendingTimer = time.AfterFunc(ds.debounceAfter, ds.flush)
+	} else if now.Add(ds.debounceAfter).Sub(ds.pendingSince) < ds.debounceMax {
+		ds.pendingTimer.Reset(ds.debounceAfter)
+	}
+}
+
+func (ds *DiscoveryService) flush() {
+	ds.pendingMutex.Lock()
+	defer ds.pendingMutex.Unlock()
+	ds.flushLocked()
+}
+
+func (ds *DiscoveryService) flushLocked() {
+	pending := ds.pending
+	ds.pending = make(map[string]bool)
+	ds.pendingTimer = nil
+	if len(pending) == 0 {
+		return
+	}
+
+	if pending[allDependencies] {
+		ds.clearCache()
+		return
+	}
+
+	glog.V(2).Infof("Evicting discovery service cache entries for %d dependencies", len(pending))
+
+	ds.depsMutex.Lock()
+	for node, deps := range ds.nodeDeps {
+		if intersects(deps, pending) {
+			delete(ds.nodeDeps, node)
+		}
+	}
+	ds.depsMutex.Unlock()
+
+	ds.sdsCache.clearDependencies(pending)
+	ds.cdsCache.clearDependencies(pending)
+	ds.rdsCache.clearDependencies(pending)
+	ds.ldsCache.clearDependencies(pending)
+}
+
+// dependencies returns the keys that the configuration of a proxy node
+// depends on: the services referenced by the proxy clusters and the
+// services of the proxy instances.
+func (ds *DiscoveryService) dependencies(role proxy.Node) map[string]bool {
+	node := role.ServiceNode()
+	ds.depsMutex.Lock()
+	deps, exists := ds.nodeDeps[node]
+	ds.depsMutex.Unlock()
+	if exists {
+		return deps
+	}
+
+	deps = map[string]bool{nodeDependency(role.IPAddress): true}
+	for _, cluster := range buildClusters(ds.Environment, role) {
+		if cluster.hostname != "" {
+			deps[cluster.hostname] = true
+		}
+	}
+	switch role.Type {
+	case proxy.Ingress:
+		deps[ingressDependency] = true
+	case proxy.Sidecar:
+		instances := ds.HostInstances(map[string]bool{role.IPAddress: true})
+		if len(instances) == 0 {
+			deps[unregisteredDependency] = true
+		}
+		for _, instance := range instances {
+			deps[instance.Service.Hostname] = true
+		}
+	}
+
+	ds.depsMutex.Lock()
+	ds.nodeDeps[node] = deps
+	ds.depsMutex.Unlock()
+	return deps
+}
+
 // Register adds routes a web service container
 func (ds *DiscoveryService) Register(container *restful.Container) {
 	ws := &restful.WebService{}
@@ -342,6 +598,9 @@ func (ds *DiscoveryService) clearCache() {
 	ds.cdsCache.clear()
 	ds.rdsCache.clear()
 	ds.ldsCache.clear()
+	ds.depsMutex.Lock()
+	ds.nodeDeps = make(map[string]map[string]bool)
+	ds.depsMutex.Unlock()
 }
 
 // ListAllEndpoints responds with all Services and is not restricted to a single service-key
@@ -350,22 +609,10 @@ func (ds *DiscoveryService) ListAllEndpoints(request *restful.Request, response
 	for _, service := range ds.Services() {
 		if !service.External() {
 			for _, port := range service.Ports {
-				hosts := make([]*host, 0)
-				for _, instance := range ds.Instances(service.Hostname, []string{port.Name}, nil) {
-					// Only set tags if theres an AZ to set, ensures nil tags when there isnt
-					var t *tags
-					if instance.AvailabilityZone != "" {
-						t = &tags{AZ: instance.AvailabilityZone}
-					}
-					hosts = append(hosts, &host{
-						Address: instance.Endpoint.Address,
-						Port:    instance.Endpoint.Port,
-						Tags:    t,
-					})
-				}
+				hosts, unhealthy := ds.buildHosts(ds.Instances(service.Hostname, []string{port.Name}, nil))
 				services = append(services, &keyAndService{
 					Key:   service.Key(port, nil),
-					Hosts: hosts,
+					Hosts: applyHealthWeights(hosts, unhealthy),
 				})
 			}
 		}
@@ -385,25 +632,83 @@ func (ds *DiscoveryService) ListEndpoints(request *restful.Request, response *re
 	key := request.Request.URL.String()
 	out, cached := ds.sdsCache.cachedDiscoveryResponse(key)
 	if !cached {
-		hostname, ports, tags := model.ParseServiceKey(request.PathParameter(ServiceKey))
-		// envoy expects an empty array if no hosts are available
-		hostArray := make([]*host, 0)
-		for _, ep := range ds.Instances(hostname, ports.GetNames(), tags) {
-			hostArray = append(hostArray, &host{
-				Address: ep.Endpoint.Address,
-				Port:    ep.Endpoint.Port,
-			})
-		}
+		serviceKey := request.PathParameter(ServiceKey)
+		hostArray := ds.hosts(serviceKey)
 		var err error
 		if out, err = json.MarshalIndent(hosts{Hosts: hostArray}, " ", " "); err != nil {
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.sdsCache.updateCachedDiscoveryResponse(key, out)
+		hostname, _, _ := model.ParseServiceKey(serviceKey)
+		ds.sdsCache.updateCachedDiscoveryResponse(key, out, map[string]bool{hostname: true})
 	}
 	writeResponse(response, out)
 }
 
+// hosts lists the endpoints for a service key
+func (ds *DiscoveryService) hosts(key string) []*host {
+	key, mode, zone := parseLocalityServiceKey(key)
+	hostname, ports, tags := model.ParseServiceKey(key)
+	hostArray, unhealthy := ds.buildHosts(ds.Instances(hostname, ports.GetNames(), tags))
+	if mode != model.LocalityDisabled {
+		hostArray = applyLocality(hostArray, unhealthy, mode, zone)
+	}
+	return applyHealthWeights(hostArray, unhealthy)
+}
+
+// buildHosts converts service instances to SDS hosts. Unhealthy instances are
+// dropped unless they are included, in which case they are returned as well.
+func (ds *DiscoveryService) buildHosts(instances []*model.ServiceInstance) ([]*host, map[*host]bool) {
+	// envoy expects an empty array if no hosts are available
+	hosts := make([]*host, 0, len(instances))
+	unhealthy := make(map[*host]bool)
+	for _, instance := range instances {
+		if instance.Health == model.Unhealthy && !ds.includeUnhealthy {
+			continue
+		}
+		h := buildHost(instance)
+		if instance.Health == model.Unhealthy {
+			unhealthy[h] = true
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, unhealthy
+}
+
+// applyHealthWeights gives the included unhealthy hosts the minimal load
+// balancing weight, so that the proxies send them only a small share of the
+// requests. The healthy hosts keep their locality weight if they have one.
+func applyHealthWeights(hosts []*host, unhealthy map[*host]bool) []*host {
+	if len(unhealthy) == 0 {
+		return hosts
+	}
+	for _, h := range hosts {
+		if h.Tags == nil {
+			h.Tags = &tags{}
+		}
+		switch {
+		case unhealthy[h]:
+			h.Tags.Weight = unhealthyHostWeight
+		case h.Tags.Weight == 0:
+			h.Tags.Weight = healthyHostWeight
+		}
+	}
+	return hosts
+}
+
+// buildHost converts a service instance to an SDS host
+func buildHost(instance *model.ServiceInstance) *host {
+	out := &host{
+		Address: instance.Endpoint.Address,
+		Port:    instance.Endpoint.Port,
+	}
+	// Only set tags if theres an AZ to set, ensures nil tags when there isnt
+	if instance.AvailabilityZone != "" {
+		out.Tags = &tags{AZ: instance.AvailabilityZone}
+	}
+	return out
+}
+
 func (ds *DiscoveryService) parseRole(request *restful.Request) (proxy.Node, error) {
 	if sc := request.PathParameter(ServiceCluster); sc != ds.Mesh.IstioServiceCluster {
 		return proxy.Node{}, fmt.Errorf("unexpected %s %q", ServiceCluster, sc)
@@ -433,7 +738,7 @@ func (ds *DiscoveryService) ListClusters(request *restful.Request, response *res
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.cdsCache.updateCachedDiscoveryResponse(key, out)
+		ds.cdsCache.updateCachedDiscoveryResponse(key, out, ds.dependencies(role))
 	}
 	writeResponse(response, out)
 }
@@ -455,7 +760,7 @@ func (ds *DiscoveryService) ListListeners(request *restful.Request, response *re
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.ldsCache.updateCachedDiscoveryResponse(key, out)
+		ds.ldsCache.updateCachedDiscoveryResponse(key, out, ds.dependencies(role))
 	}
 	writeResponse(response, out)
 }
@@ -493,7 +798,7 @@ func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restf
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.rdsCache.updateCachedDiscoveryResponse(key, out)
+		ds.rdsCache.updateCachedDiscoveryResponse(key, out, ds.dependencies(role))
 	}
 	writeResponse(response, out)
 }
@@ -20,7 +20,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 
@@ -102,6 +105,49 @@ func compareResponse(body []byte, file string, t *testing.T) {
 	util.CompareYAML(file, t)
 }
 
+// unhealthyDiscovery marks the instances on the given addresses as unhealthy
+type unhealthyDiscovery struct {
+	model.ServiceDiscovery
+	addrs map[string]bool
+}
+
+func (sd *unhealthyDiscovery) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	out := sd.ServiceDiscovery.Instances(hostname, ports, tags)
+	for _, instance := range out {
+		if sd.addrs[instance.Endpoint.Address] {
+			instance.Health = model.Unhealthy
+		}
+	}
+	return out
+}
+
+func TestServiceDiscoveryUnhealthy(t *testing.T) {
+	mesh := makeMeshConfig()
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes), &mesh)
+	unhealthy := mock.MakeIP(mock.HelloService, 1)
+	ds.ServiceDiscovery = &unhealthyDiscovery{ServiceDiscovery: mock.Discovery, addrs: map[string]bool{unhealthy: true}}
+	key := mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+
+	hosts := ds.hosts(key)
+	if len(hosts) != 1 || hosts[0].Address == unhealthy {
+		t.Errorf("hosts(%s) => got %v, want only the healthy host", key, hosts)
+	}
+
+	ds.includeUnhealthy = true
+	if hosts = ds.hosts(key); len(hosts) != 2 {
+		t.Fatalf("hosts(%s) => got %v, want the healthy and the unhealthy hosts", key, hosts)
+	}
+	for _, h := range hosts {
+		want := healthyHostWeight
+		if h.Address == unhealthy {
+			want = unhealthyHostWeight
+		}
+		if h.Tags == nil || h.Tags.Weight != want {
+			t.Errorf("host %s => got tags %#v, want load balancing weight %d", h.Address, h.Tags, want)
+		}
+	}
+}
+
 func TestServiceDiscovery(t *testing.T) {
 	mesh := makeMeshConfig()
 	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes), &mesh)
@@ -373,7 +419,7 @@ func TestSidecarListenerDiscovery(t *testing.T) {
 				if err != nil {
 					t.Fatal(err)
 				}
-				if _, err = registry.Post(msg); err != nil {
+				if _, err = registry.Post(model.Config{Content: msg}); err != nil {
 					t.Fatal(err)
 				}
 			}
@@ -511,3 +557,115 @@ func TestDiscoveryCache(t *testing.T) {
 		compareResponse(got, c.wantCache, t)
 	}
 }
+
+func TestDiscoveryCacheDependencies(t *testing.T) {
+	mesh := makeMeshConfig()
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes), &mesh)
+
+	sds := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	cds := fmt.Sprintf("/v1/clusters/%s/%s", ds.Mesh.IstioServiceCluster, mock.ProxyV0.ServiceNode())
+	rds := fmt.Sprintf("/v1/routes/80/%s/%s", ds.Mesh.IstioServiceCluster, mock.ProxyV0.ServiceNode())
+	egress := fmt.Sprintf("/v1/clusters/%s/%s", ds.Mesh.IstioServiceCluster, mock.Egress.ServiceNode())
+	warm := func() {
+		for _, path := range []string{sds, cds, rds, egress} {
+			_ = makeDiscoveryRequest(ds, "GET", path, t)
+		}
+	}
+	cached := func(cache *discoveryCache, path string) bool {
+		_, ok := cache.cachedDiscoveryResponse(path)
+		return ok
+	}
+
+	warm()
+	ds.invalidate("unknown.default.svc.cluster.local")
+	if !cached(ds.sdsCache, sds) || !cached(ds.cdsCache, cds) || !cached(ds.rdsCache, rds) ||
+		!cached(ds.cdsCache, egress) {
+		t.Error("unrelated service evicted cached responses")
+	}
+
+	// sidecar configuration depends on all services, the egress proxy
+	// configuration depends only on external services
+	ds.invalidate(mock.WorldService.Hostname)
+	if !cached(ds.sdsCache, sds) || cached(ds.cdsCache, cds) || cached(ds.rdsCache, rds) ||
+		!cached(ds.cdsCache, egress) {
+		t.Error("service change did not evict dependent responses only")
+	}
+
+	warm()
+	ds.invalidate(mock.HelloService.Hostname)
+	if cached(ds.sdsCache, sds) || cached(ds.cdsCache, cds) || !cached(ds.cdsCache, egress) {
+		t.Error("service change did not evict dependent responses only")
+	}
+
+	warm()
+	ds.invalidate(mock.ExtHTTPService.Hostname)
+	if cached(ds.cdsCache, egress) {
+		t.Error("external service change did not evict egress responses")
+	}
+
+	warm()
+	ds.invalidate(allDependencies)
+	if cached(ds.sdsCache, sds) || cached(ds.cdsCache, cds) || cached(ds.rdsCache, rds) {
+		t.Error("full invalidation did not evict cached responses")
+	}
+}
+
+func TestDiscoveryCacheDebounce(t *testing.T) {
+	mesh := makeMeshConfig()
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes), &mesh)
+	ds.debounceAfter = 50 * time.Millisecond
+	ds.debounceMax = time.Second
+
+	sds := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	_ = makeDiscoveryRequest(ds, "GET", sds, t)
+
+	// a burst of events is coalesced into one eviction
+	for i := 0; i < 3; i++ {
+		ds.invalidate(mock.HelloService.Hostname)
+	}
+	if _, ok := ds.sdsCache.cachedDiscoveryResponse(sds); !ok {
+		t.Error("cache evicted before the debounce period")
+	}
+	util.Eventually(func() bool {
+		_, ok := ds.sdsCache.cachedDiscoveryResponse(sds)
+		return !ok
+	}, t)
+}
+
+func TestConfigDependencies(t *testing.T) {
+	mesh := makeMeshConfig()
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes), &mesh)
+
+	rule := model.Config{
+		Type: model.RouteRule.Type,
+		Key:  "rule",
+		Content: &proxyconfig.RouteRule{
+			Destination: mock.HelloService.Hostname,
+			Route:       []*proxyconfig.DestinationWeight{{Destination: mock.WorldService.Hostname}},
+		},
+	}
+	deps := ds.configDependencies(rule, model.EventAdd)
+	sort.Strings(deps)
+	if !reflect.DeepEqual(deps, []string{mock.HelloService.Hostname, mock.WorldService.Hostname}) {
+		t.Errorf("configDependencies(add) => got %v", deps)
+	}
+
+	// an update includes the dependencies of the previous version
+	rule.Content = &proxyconfig.RouteRule{Destination: mock.WorldService.Hostname}
+	deps = ds.configDependencies(rule, model.EventUpdate)
+	sort.Strings(deps)
+	if !reflect.DeepEqual(deps, []string{mock.HelloService.Hostname, mock.WorldService.Hostname}) {
+		t.Errorf("configDependencies(update) => got %v", deps)
+	}
+
+	// dependencies of older versions are not retained
+	deps = ds.configDependencies(rule, model.EventUpdate)
+	if !reflect.DeepEqual(deps, []string{mock.WorldService.Hostname}) {
+		t.Errorf("configDependencies(second update) => got %v", deps)
+	}
+
+	ingress := model.Config{Type: model.IngressRule.Type, Key: "ingress", Content: &proxyconfig.IngressRule{}}
+	if deps = ds.configDependencies(ingress, model.EventDelete); !reflect.DeepEqual(deps, []string{ingressDependency}) {
+		t.Errorf("configDependencies(ingress) => got %v", deps)
+	}
+}
@@ -65,6 +65,7 @@ func buildEgressHTTPRoute(mesh *proxyconfig.ProxyMeshConfig, svc *model.Service)
 				Hosts: []Host{{
 					URL: fmt.Sprintf("tcp://%s:%d", svc.ExternalName, servicePort.Port),
 				}},
+				hostname: svc.Hostname,
 			}
 
 			if servicePort.Protocol == model.ProtocolGRPC || servicePort.Protocol == model.ProtocolHTTP2 {
@@ -76,6 +77,8 @@ func buildEgressHTTPRoute(mesh *proxyconfig.ProxyMeshConfig, svc *model.Service)
 				cluster.SSLContext = &SSLContextExternal{}
 			}
 
+			// route rules, including their header annotations, are applied by
+			// the sidecar proxy of the source and not on the egress route
 			route := &HTTPRoute{
 				Prefix:          "/",
 				Cluster:         cluster.Name,
@@ -72,3 +72,13 @@ func buildHeader(name string, match *proxyconfig.StringMatch) Header {
 
 	return header
 }
+
+// applyRouteHeaders sets the request headers to add of a route rule on the route
+func applyRouteHeaders(route *HTTPRoute, headers *model.RouteHeaders) {
+	var out []*HeaderValue
+	for name, value := range headers.RequestAdd {
+		out = append(out, &HeaderValue{Key: name, Value: value})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	route.RequestHeadersToAdd = out
+}
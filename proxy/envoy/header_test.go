@@ -79,3 +79,28 @@ func TestHTTPMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildHTTPRouteHeaders(t *testing.T) {
+	rule := &proxyconfig.RouteRule{Name: "headers", Destination: "world.default.svc.cluster.local"}
+	port := &model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}
+	config := model.Config{
+		Type:        model.RouteRule.Type,
+		Key:         rule.Name,
+		Content:     rule,
+		Annotations: map[string]string{model.HeadersAnnotation: `{"request": {"add": {"x-env": "prod", "x-b": "b"}}}`},
+	}
+
+	route := buildHTTPRoute(config, port)
+	wantAdd := []*HeaderValue{
+		{Key: "x-b", Value: "b"},
+		{Key: "x-env", Value: "prod"},
+	}
+	if !reflect.DeepEqual(route.RequestHeadersToAdd, wantAdd) {
+		t.Errorf("got request headers to add %v, want %v", route.RequestHeadersToAdd, wantAdd)
+	}
+
+	config.Annotations[model.HeadersAnnotation] = `{"request": {"add": {"X-Env": "prod"}}}`
+	if route = buildHTTPRoute(config, port); route.RequestHeadersToAdd != nil {
+		t.Errorf("got request headers to add %v for an invalid annotation, want none", route.RequestHeadersToAdd)
+	}
+}
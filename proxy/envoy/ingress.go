@@ -62,9 +62,10 @@ func buildIngressRoutes(mesh *proxyconfig.ProxyMeshConfig,
 
 	// skip over source-matched route rules
 	rules := config.RouteRulesBySource(nil)
+	policies := config.DestinationPolicyIndex()
 
 	for _, rule := range ingressRules {
-		routes, tls, err := buildIngressRoute(mesh, rule, discovery, rules)
+		routes, tls, err := buildIngressRoute(mesh, rule, discovery, policies, rules)
 		if err != nil {
 			glog.Warningf("Error constructing Envoy route from ingress rule: %v", err)
 			continue
@@ -125,7 +126,8 @@ func buildIngressRoutes(mesh *proxyconfig.ProxyMeshConfig,
 
 // buildIngressRoute translates an ingress rule to an Envoy route
 func buildIngressRoute(mesh *proxyconfig.ProxyMeshConfig, ingress *proxyconfig.IngressRule,
-	discovery model.ServiceDiscovery, rules []*proxyconfig.RouteRule) ([]*HTTPRoute, string, error) {
+	discovery model.ServiceDiscovery, policies *model.DestinationPolicyIndex,
+	rules []model.Config) ([]*HTTPRoute, string, error) {
 	service, exists := discovery.GetService(ingress.Destination)
 	if !exists {
 		return nil, "", fmt.Errorf("cannot find service %q", ingress.Destination)
@@ -140,7 +142,7 @@ func buildIngressRoute(mesh *proxyconfig.ProxyMeshConfig, ingress *proxyconfig.I
 	}
 
 	// unfold the rules for the destination port
-	routes := buildDestinationHTTPRoutes(service, servicePort, rules)
+	routes := buildDestinationHTTPRoutes(service, servicePort, rules, policies)
 
 	// filter by path, prefix from the ingress
 	ingressRoute := buildHTTPRouteMatch(ingress.Match)
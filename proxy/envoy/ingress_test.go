@@ -20,7 +20,7 @@ func addIngressRoutes(r model.ConfigStore, t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if _, err = r.Post(msg); err != nil {
+		if _, err = r.Post(model.Config{Content: msg}); err != nil {
 			t.Fatal(err)
 		}
 	}
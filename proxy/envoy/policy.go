@@ -18,13 +18,39 @@
 package envoy
 
 import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/model"
 )
 
-// applyClusterPolicy assumes an outbound cluster and inserts custom configuration for the cluster
-func applyClusterPolicy(cluster *Cluster, config model.IstioConfigStore,
-	mesh *proxyconfig.ProxyMeshConfig, accounts model.ServiceAccounts) {
+const (
+	// localityLocalWeight is the SDS load balancing weight of the hosts in the
+	// zone of the proxy for the weighted locality-aware load balancing
+	localityLocalWeight = 100
+
+	// localityRemoteWeight is the SDS load balancing weight of the hosts
+	// outside of the zone of the proxy for the weighted locality-aware load balancing
+	localityRemoteWeight = 10
+
+	// localityFailoverMinPercent is the minimum percentage of the healthy
+	// hosts that must be in the zone of the proxy for the failover
+	// locality-aware load balancing to drop the hosts in other zones. Below
+	// the threshold, the hosts are weighted instead so that a few local hosts
+	// are not overloaded by the requests of the whole zone.
+	localityFailoverMinPercent = 20
+)
+
+// applyClusterPolicy assumes an outbound cluster and inserts custom configuration for the cluster.
+// The zone of the proxy scopes the locality-aware load balancing.
+func applyClusterPolicy(cluster *Cluster, policies *model.DestinationPolicyIndex,
+	mesh *proxyconfig.ProxyMeshConfig, accounts model.ServiceAccounts,
+	discovery model.ServiceDiscovery, zone string) {
 	duration := protoDurationToMS(mesh.ConnectTimeout)
 	cluster.ConnectTimeoutMs = duration
 
@@ -44,14 +70,35 @@ func applyClusterPolicy(cluster *Cluster, config model.IstioConfigStore,
 		cluster.SSLContext = buildClusterSSLContext(mesh.AuthCertsPath, serviceAccounts)
 	}
 
+	annotations := policies.Annotations(cluster.hostname)
+	applyLocalityPolicy(cluster, discovery, annotations.Locality, zone)
+
+	// consistent hash load balancing overrides the load balancing policy except
+	// for original destination clusters that require the original destination load balancer
+	hash := annotations.ConsistentHash
+	if hash != nil && cluster.Type != ClusterTypeOriginalDST {
+		cluster.LbType = LbTypeRingHash
+	}
+
+	// original destination clusters do not support active health checks
+	if cluster.Type != ClusterTypeOriginalDST {
+		cluster.HealthCheck = buildHealthCheck(annotations.HealthCheck(cluster.tags))
+	}
+
+	// limit the outstanding retries to the destination
+	if annotations.MaxRetries > 0 {
+		cluster.CircuitBreaker = &CircuitBreaker{}
+		cluster.CircuitBreaker.Default.MaxRetries = annotations.MaxRetries
+	}
+
 	// apply destination policies
-	policy := config.DestinationPolicy(cluster.hostname, cluster.tags)
+	policy := policies.Policy(cluster.hostname, cluster.tags)
 
 	if policy == nil {
 		return
 	}
 
-	if policy.LoadBalancing != nil {
+	if policy.LoadBalancing != nil && hash == nil && cluster.Type != ClusterTypeOriginalDST {
 		switch policy.LoadBalancing.GetName() {
 		case proxyconfig.LoadBalancing_ROUND_ROBIN:
 			cluster.LbType = LbTypeRoundRobin
@@ -69,7 +116,9 @@ func applyClusterPolicy(cluster *Cluster, config model.IstioConfigStore,
 
 		// Envoy's circuit breaker is a combination of its circuit breaker (which is actually a bulk head)
 		// outlier detection (which is per pod circuit breaker)
-		cluster.CircuitBreaker = &CircuitBreaker{}
+		if cluster.CircuitBreaker == nil {
+			cluster.CircuitBreaker = &CircuitBreaker{}
+		}
 		if cbconfig.MaxConnections > 0 {
 			cluster.CircuitBreaker.Default.MaxConnections = int(cbconfig.MaxConnections)
 		}
@@ -79,7 +128,6 @@ func applyClusterPolicy(cluster *Cluster, config model.IstioConfigStore,
 		if cbconfig.HttpMaxPendingRequests > 0 {
 			cluster.CircuitBreaker.Default.MaxPendingRequests = int(cbconfig.HttpMaxPendingRequests)
 		}
-		//TODO: need to add max_retries as well. Currently it defaults to 3
 
 		cluster.OutlierDetection = &OutlierDetection{}
 
@@ -98,3 +146,182 @@ func applyClusterPolicy(cluster *Cluster, config model.IstioConfigStore,
 		}
 	}
 }
+
+// buildHashPolicy translates the consistent hash configuration of a destination
+// to the route hash policy
+func buildHashPolicy(hash *model.ConsistentHash) *HashPolicy {
+	if hash == nil || hash.HTTPHeaderName == "" {
+		return nil
+	}
+	return &HashPolicy{HeaderName: hash.HTTPHeaderName}
+}
+
+// buildRouteHashPolicy selects the hash policy of the destinations of a route.
+// Envoy applies the hash policy of a route to all the upstream clusters that
+// use the ring hash load balancer, so the hash policy is set only if these
+// destinations agree on it.
+func buildRouteHashPolicy(route *HTTPRoute, policies *model.DestinationPolicyIndex) *HashPolicy {
+	var out *HashPolicy
+	for _, cluster := range route.clusters {
+		// mirrored requests do not select the upstream host of the route
+		if !cluster.outbound || route.Shadow != nil && route.Shadow.Cluster == cluster.Name {
+			continue
+		}
+
+		policy := buildHashPolicy(policies.Annotations(cluster.hostname).ConsistentHash)
+		switch {
+		case policy == nil:
+		case out == nil:
+			out = policy
+		case *out != *policy:
+			glog.Warningf("Conflicting hash policies %#v and %#v for the destinations of route %#v",
+				out, policy, route)
+			return nil
+		}
+	}
+	return out
+}
+
+// buildHealthCheck translates the active health check of a service version
+// to the cluster health check
+func buildHealthCheck(check *model.HealthCheck) *HealthCheck {
+	if check == nil {
+		return nil
+	}
+
+	out := &HealthCheck{
+		Type:               string(check.Type),
+		TimeoutMS:          int64(check.Timeout / time.Millisecond),
+		IntervalMS:         int64(check.Interval / time.Millisecond),
+		UnhealthyThreshold: check.UnhealthyThreshold,
+		HealthyThreshold:   check.HealthyThreshold,
+		Path:               check.Path,
+	}
+	if check.Type == model.HealthCheckTCP {
+		out.Send = []HealthCheckPayload{{Binary: check.Send}}
+		out.Receive = []HealthCheckPayload{{Binary: check.Receive}}
+	}
+	return out
+}
+
+// applyLocalityPolicy scopes the service discovery of an outbound cluster to
+// the zone of the proxy if the destination policy enables locality-aware load
+// balancing. Locality has no effect unless the instances of the destination
+// span the zone of the proxy and other zones, so the cluster keeps the service
+// key shared by all zones otherwise, bounding the SDS keys per service to the
+// zones that host its instances.
+func applyLocalityPolicy(cluster *Cluster, discovery model.ServiceDiscovery,
+	mode model.LocalityLoadBalancing, zone string) {
+	if !cluster.outbound || cluster.Type != SDSName || zone == "" || mode == model.LocalityDisabled {
+		return
+	}
+
+	var tags model.TagsList
+	if len(cluster.tags) > 0 {
+		tags = model.TagsList{cluster.tags}
+	}
+	local, remote := false, false
+	for _, instance := range discovery.Instances(cluster.hostname, []string{cluster.port.Name}, tags) {
+		if instance.AvailabilityZone == zone {
+			local = true
+		} else {
+			remote = true
+		}
+	}
+	if local && remote {
+		cluster.ServiceName = localityServiceKey(cluster.ServiceName, mode, zone)
+	}
+}
+
+// localityServiceKey appends the locality mode and the zone of the proxy to the
+// service key, e.g. "name.namespace|http|version=v1|failover=<zone>". The zone
+// is base64 encoded since the service key is a path segment of the SDS request.
+func localityServiceKey(key string, mode model.LocalityLoadBalancing, zone string) string {
+	parts := strings.Split(key, "|")
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return fmt.Sprintf("%s|%s=%s", strings.Join(parts, "|"), mode,
+		base64.RawURLEncoding.EncodeToString([]byte(zone)))
+}
+
+// parseLocalityServiceKey is the inverse of localityServiceKey. Keys without
+// a valid locality suffix are returned unchanged with the locality disabled.
+func parseLocalityServiceKey(key string) (string, model.LocalityLoadBalancing, string) {
+	parts := strings.Split(key, "|")
+	if len(parts) < 4 {
+		return key, model.LocalityDisabled, ""
+	}
+
+	service := strings.Join(parts[:3], "|")
+	locality := strings.SplitN(parts[3], "=", 2)
+	if len(locality) != 2 {
+		return service, model.LocalityDisabled, ""
+	}
+	mode := model.LocalityLoadBalancing(locality[0])
+	if mode != model.LocalityFailover && mode != model.LocalityWeighted {
+		return service, model.LocalityDisabled, ""
+	}
+	zone, err := base64.RawURLEncoding.DecodeString(locality[1])
+	if err != nil || len(zone) == 0 {
+		return service, model.LocalityDisabled, ""
+	}
+	return service, mode, string(zone)
+}
+
+// applyLocality selects and weighs the hosts of a service relative to the zone
+// of the proxy. The unhealthy hosts are not counted as available in the zone.
+func applyLocality(hosts []*host, unhealthy map[*host]bool, mode model.LocalityLoadBalancing,
+	zone string) []*host {
+	local := make([]*host, 0, len(hosts))
+	healthy, healthyLocal := 0, 0
+	for _, h := range hosts {
+		isLocal := h.Tags != nil && h.Tags.AZ == zone
+		if isLocal {
+			local = append(local, h)
+		}
+		if !unhealthy[h] {
+			healthy++
+			if isLocal {
+				healthyLocal++
+			}
+		}
+	}
+
+	// locality has no effect unless the hosts span the proxy zone and other zones
+	if healthyLocal == 0 || len(local) == len(hosts) {
+		return hosts
+	}
+
+	// fail over only if enough healthy hosts are in the zone of the proxy
+	if mode == model.LocalityFailover && healthyLocal*100 < healthy*localityFailoverMinPercent {
+		mode = model.LocalityWeighted
+	}
+
+	switch mode {
+	case model.LocalityFailover:
+		return local
+	case model.LocalityWeighted:
+		for _, h := range hosts {
+			if h.Tags == nil {
+				h.Tags = &tags{}
+			}
+			if h.Tags.AZ == zone {
+				h.Tags.Weight = localityLocalWeight
+			} else {
+				h.Tags.Weight = localityRemoteWeight
+			}
+		}
+	}
+	return hosts
+}
+
+// proxyZone returns the availability zone of the proxy from its service instances
+func proxyZone(instances []*model.ServiceInstance) string {
+	for _, instance := range instances {
+		if instance.AvailabilityZone != "" {
+			return instance.AvailabilityZone
+		}
+	}
+	return ""
+}
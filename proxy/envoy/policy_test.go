@@ -0,0 +1,396 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestLocalityServiceKey(t *testing.T) {
+	testCases := []struct {
+		key  string
+		mode model.LocalityLoadBalancing
+		zone string
+	}{
+		{key: "world.default.svc.cluster.local", mode: model.LocalityFailover, zone: "region1/zone1"},
+		{key: "world.default.svc.cluster.local|http", mode: model.LocalityWeighted, zone: "us-east1-b"},
+		{key: "world.default.svc.cluster.local|http|version=v1", mode: model.LocalityFailover, zone: "a/b/c"},
+	}
+
+	for _, tc := range testCases {
+		locality := localityServiceKey(tc.key, tc.mode, tc.zone)
+		hostname, ports, tags := model.ParseServiceKey(locality)
+		wantHostname, wantPorts, wantTags := model.ParseServiceKey(tc.key)
+		if hostname != wantHostname || !reflect.DeepEqual(ports, wantPorts) || !reflect.DeepEqual(tags, wantTags) {
+			t.Errorf("ParseServiceKey(%q) does not match the service key %q", locality, tc.key)
+		}
+
+		key, mode, zone := parseLocalityServiceKey(locality)
+		if mode != tc.mode || zone != tc.zone {
+			t.Errorf("parseLocalityServiceKey(%q) => got (%q, %q), want (%q, %q)",
+				locality, mode, zone, tc.mode, tc.zone)
+		}
+		if hostname, _, _ := model.ParseServiceKey(key); hostname != wantHostname {
+			t.Errorf("parseLocalityServiceKey(%q) => got key %q for %q", locality, key, tc.key)
+		}
+	}
+
+	for _, key := range []string{
+		"world.default.svc.cluster.local|http|version=v1",
+		"world.default.svc.cluster.local|http||nearest=em9uZQ",
+		"world.default.svc.cluster.local|http||failover",
+		"world.default.svc.cluster.local|http||failover=!!",
+	} {
+		if _, mode, _ := parseLocalityServiceKey(key); mode != model.LocalityDisabled {
+			t.Errorf("parseLocalityServiceKey(%q) => got %q, want disabled locality", key, mode)
+		}
+	}
+}
+
+func makeLocalityHosts() []*host {
+	return []*host{
+		{Address: "10.1.1.0", Port: 80, Tags: &tags{AZ: "region/zone1"}},
+		{Address: "10.1.1.1", Port: 80, Tags: &tags{AZ: "region/zone2"}},
+		{Address: "10.1.1.2", Port: 80},
+	}
+}
+
+// zonedDiscovery assigns the instances on the given addresses to zones
+type zonedDiscovery struct {
+	model.ServiceDiscovery
+	zones map[string]string
+}
+
+func (sd *zonedDiscovery) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	out := sd.ServiceDiscovery.Instances(hostname, ports, tags)
+	for _, instance := range out {
+		instance.AvailabilityZone = sd.zones[instance.Endpoint.Address]
+	}
+	return out
+}
+
+func TestApplyLocality(t *testing.T) {
+	if got := applyLocality(makeLocalityHosts(), nil, model.LocalityFailover, "region/zone1"); len(got) != 1 ||
+		got[0].Address != "10.1.1.0" {
+		t.Errorf("failover locality => got %v, want only the hosts in the proxy zone", got)
+	}
+
+	if got := applyLocality(makeLocalityHosts(), nil, model.LocalityFailover, "region/zone3"); len(got) != 3 {
+		t.Errorf("failover locality => got %v, want all hosts without hosts in the proxy zone", got)
+	}
+
+	// an unhealthy host does not count as available in the proxy zone
+	hosts := makeLocalityHosts()
+	if got := applyLocality(hosts, map[*host]bool{hosts[0]: true}, model.LocalityFailover,
+		"region/zone1"); len(got) != 3 {
+		t.Errorf("failover locality => got %v, want all hosts without healthy hosts in the proxy zone", got)
+	}
+
+	// too few local hosts are weighted instead of taking all requests
+	hosts = makeLocalityHosts()
+	for i := 3; i < 6; i++ {
+		hosts = append(hosts, &host{Address: fmt.Sprintf("10.1.1.%d", i), Port: 80, Tags: &tags{AZ: "region/zone2"}})
+	}
+	if got := applyLocality(hosts, nil, model.LocalityFailover, "region/zone1"); len(got) != 6 ||
+		got[0].Tags.Weight != localityLocalWeight || got[1].Tags.Weight != localityRemoteWeight {
+		t.Errorf("failover locality => got %v, want weighted hosts below the local threshold", got)
+	}
+
+	got := applyLocality(makeLocalityHosts(), nil, model.LocalityWeighted, "region/zone1")
+	want := []*host{
+		{Address: "10.1.1.0", Port: 80, Tags: &tags{AZ: "region/zone1", Weight: localityLocalWeight}},
+		{Address: "10.1.1.1", Port: 80, Tags: &tags{AZ: "region/zone2", Weight: localityRemoteWeight}},
+		{Address: "10.1.1.2", Port: 80, Tags: &tags{Weight: localityRemoteWeight}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("weighted locality => got %v, want %v", got, want)
+	}
+
+	local := []*host{{Address: "10.1.1.0", Port: 80, Tags: &tags{AZ: "region/zone1"}}}
+	if got := applyLocality(local, nil, model.LocalityWeighted, "region/zone1"); got[0].Tags.Weight != 0 {
+		t.Errorf("weighted locality => got weight %d, want no weights within a single zone", got[0].Tags.Weight)
+	}
+}
+
+func TestApplyLocalityPolicy(t *testing.T) {
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	policy := &proxyconfig.DestinationPolicy{Destination: mock.HelloService.Hostname}
+	if _, err := store.Post(model.Config{
+		Content:     policy,
+		Annotations: map[string]string{model.LocalityAnnotation: string(model.LocalityFailover)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	mesh := makeMeshConfig()
+	port := mock.HelloService.Ports[0]
+	cluster := buildOutboundCluster(mock.HelloService.Hostname, port, nil)
+	key := cluster.ServiceName
+	applyClusterPolicy(cluster, store.DestinationPolicyIndex(), &mesh, mock.Discovery, mock.Discovery, "")
+	if cluster.ServiceName != key {
+		t.Errorf("got service name %q, want %q for a proxy without a zone", cluster.ServiceName, key)
+	}
+
+	discovery := &zonedDiscovery{ServiceDiscovery: mock.Discovery, zones: map[string]string{
+		mock.MakeIP(mock.HelloService, 0): "region/zone1",
+		mock.MakeIP(mock.HelloService, 1): "region/zone2",
+	}}
+	cluster = buildOutboundCluster(mock.HelloService.Hostname, port, nil)
+	applyClusterPolicy(cluster, store.DestinationPolicyIndex(), &mesh, mock.Discovery, discovery, "region/zone1")
+	if _, mode, zone := parseLocalityServiceKey(cluster.ServiceName); mode != model.LocalityFailover ||
+		zone != "region/zone1" {
+		t.Errorf("got service name %q, want failover locality in the proxy zone", cluster.ServiceName)
+	}
+
+	// the shared service key is kept if the instances are all outside the proxy zone
+	cluster = buildOutboundCluster(mock.HelloService.Hostname, port, nil)
+	applyClusterPolicy(cluster, store.DestinationPolicyIndex(), &mesh, mock.Discovery, discovery, "region/zone3")
+	if cluster.ServiceName != key {
+		t.Errorf("got service name %q, want %q without instances in the proxy zone", cluster.ServiceName, key)
+	}
+
+	other := buildOutboundCluster(mock.WorldService.Hostname, mock.WorldService.Ports[0], nil)
+	key = other.ServiceName
+	applyClusterPolicy(other, store.DestinationPolicyIndex(), &mesh, mock.Discovery, discovery, "region/zone1")
+	if other.ServiceName != key {
+		t.Errorf("got service name %q, want %q without a locality policy", other.ServiceName, key)
+	}
+}
+
+func TestBuildHashPolicy(t *testing.T) {
+	testCases := []struct {
+		in   *model.ConsistentHash
+		want *HashPolicy
+	}{
+		{in: nil, want: nil},
+		{in: &model.ConsistentHash{HTTPHeaderName: "x-user"}, want: &HashPolicy{HeaderName: "x-user"}},
+		{in: &model.ConsistentHash{}, want: nil},
+	}
+
+	for _, tc := range testCases {
+		if got := buildHashPolicy(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("buildHashPolicy(%#v) => got %#v, want %#v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestApplyClusterPolicyConsistentHash(t *testing.T) {
+	mesh := makeMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	policy := &proxyconfig.DestinationPolicy{
+		Destination: mock.HelloService.Hostname,
+		Policy: []*proxyconfig.DestinationVersionPolicy{{
+			LoadBalancing: &proxyconfig.LoadBalancing{
+				LbPolicy: &proxyconfig.LoadBalancing_Name{Name: proxyconfig.LoadBalancing_RANDOM},
+			},
+		}},
+	}
+	if _, err := store.Post(model.Config{
+		Content:     policy,
+		Annotations: map[string]string{model.ConsistentHashAnnotation: "httpHeaderName=x-user"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := buildOutboundCluster(mock.HelloService.Hostname, mock.HelloService.Ports[0], nil)
+	applyClusterPolicy(cluster, store.DestinationPolicyIndex(), &mesh, mock.Discovery, mock.Discovery, "")
+	if cluster.LbType != LbTypeRingHash {
+		t.Errorf("got LB type %q, want %q", cluster.LbType, LbTypeRingHash)
+	}
+
+	orig := buildOutboundOriginalDSTCluster(mock.HelloService.Hostname, mock.HelloService.Ports[0], mesh.ConnectTimeout)
+	applyClusterPolicy(orig, store.DestinationPolicyIndex(), &mesh, mock.Discovery, mock.Discovery, "")
+	if orig.LbType != LbTypeOriginalDST {
+		t.Errorf("got LB type %q, want %q", orig.LbType, LbTypeOriginalDST)
+	}
+
+	if _, err := store.Post(model.Config{Content: &proxyconfig.IngressRule{
+		Name:                   "hello",
+		Destination:            mock.HelloService.Hostname,
+		DestinationServicePort: &proxyconfig.IngressRule_DestinationPortName{DestinationPortName: "http"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	outbound := buildOutboundHTTPRoutes(&mesh, mock.ProxyV0, nil, []*model.Service{mock.HelloService}, store)
+	ingress, _ := buildIngressRoutes(&mesh, mock.Discovery, store)
+	for _, routes := range []HTTPRouteConfigs{outbound, ingress} {
+		for _, config := range routes {
+			for _, host := range config.VirtualHosts {
+				for _, route := range host.Routes {
+					if !reflect.DeepEqual(route.HashPolicy, &HashPolicy{HeaderName: "x-user"}) {
+						t.Errorf("got hash policy %#v for route %#v", route.HashPolicy, route)
+					}
+				}
+			}
+		}
+	}
+	if len(ingress[80].VirtualHosts) == 0 {
+		t.Error("got no ingress routes")
+	}
+}
+
+func TestBuildRouteHashPolicy(t *testing.T) {
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	for hostname, header := range map[string]string{
+		mock.HelloService.Hostname: "x-user",
+		mock.WorldService.Hostname: "x-session",
+	} {
+		if _, err := store.Post(model.Config{
+			Content:     &proxyconfig.DestinationPolicy{Destination: hostname},
+			Annotations: map[string]string{model.ConsistentHashAnnotation: "httpHeaderName=" + header},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hello := buildOutboundCluster(mock.HelloService.Hostname, mock.HelloService.Ports[0], nil)
+	world := buildOutboundCluster(mock.WorldService.Hostname, mock.WorldService.Ports[0], nil)
+	other := buildOutboundCluster("other.default.svc.cluster.local", mock.HelloService.Ports[0], nil)
+
+	cases := []struct {
+		name  string
+		route *HTTPRoute
+		want  *HashPolicy
+	}{
+		{
+			name:  "single destination",
+			route: &HTTPRoute{clusters: Clusters{hello}},
+			want:  &HashPolicy{HeaderName: "x-user"},
+		},
+		{
+			name:  "destination without consistent hash",
+			route: &HTTPRoute{clusters: Clusters{other}},
+		},
+		{
+			name:  "weighted destinations with a single hash policy",
+			route: &HTTPRoute{clusters: Clusters{other, world}},
+			want:  &HashPolicy{HeaderName: "x-session"},
+		},
+		{
+			name:  "weighted destinations with conflicting hash policies",
+			route: &HTTPRoute{clusters: Clusters{hello, world}},
+		},
+		{
+			name:  "mirror destination ignored",
+			route: &HTTPRoute{clusters: Clusters{other, hello}, Shadow: &ShadowPolicy{Cluster: hello.Name}},
+		},
+	}
+
+	policies := store.DestinationPolicyIndex()
+	for _, c := range cases {
+		if got := buildRouteHashPolicy(c.route, policies); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: buildRouteHashPolicy => got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestBuildHealthCheck(t *testing.T) {
+	check := &model.HealthCheck{
+		Type:               model.HealthCheckHTTP,
+		Path:               "/healthz",
+		Interval:           10 * time.Second,
+		Timeout:            time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
+	want := &HealthCheck{
+		Type:               "http",
+		TimeoutMS:          1000,
+		IntervalMS:         10000,
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   2,
+		Path:               "/healthz",
+	}
+	if got := buildHealthCheck(check); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildHealthCheck => got %#v, want %#v", got, want)
+	}
+
+	if got := buildHealthCheck(nil); got != nil {
+		t.Errorf("buildHealthCheck => got %#v, want nil", got)
+	}
+}
+
+func TestApplyClusterPolicyHealthCheck(t *testing.T) {
+	mesh := makeMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	policy := &proxyconfig.DestinationPolicy{Destination: mock.HelloService.Hostname}
+	if _, err := store.Post(model.Config{
+		Content: policy,
+		Annotations: map[string]string{model.HealthCheckAnnotation: `[{
+			"tags": {"version": "v1"}, "type": "tcp", "send": "50494e47", "receive": "504f4e47",
+			"interval": "5s", "timeout": "500ms",
+			"healthyThreshold": 1, "unhealthyThreshold": 2}]`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	port := mock.HelloService.Ports[0]
+	cluster := buildOutboundCluster(mock.HelloService.Hostname, port, model.Tags{"version": "v1"})
+	applyClusterPolicy(cluster, store.DestinationPolicyIndex(), &mesh, mock.Discovery, mock.Discovery, "")
+	want := &HealthCheck{Type: "tcp", TimeoutMS: 500, IntervalMS: 5000, UnhealthyThreshold: 2, HealthyThreshold: 1,
+		Send: []HealthCheckPayload{{Binary: "50494e47"}}, Receive: []HealthCheckPayload{{Binary: "504f4e47"}}}
+	if !reflect.DeepEqual(cluster.HealthCheck, want) {
+		t.Errorf("got health check %#v, want %#v", cluster.HealthCheck, want)
+	}
+
+	other := buildOutboundCluster(mock.HelloService.Hostname, port, model.Tags{"version": "v2"})
+	applyClusterPolicy(other, store.DestinationPolicyIndex(), &mesh, mock.Discovery, mock.Discovery, "")
+	if other.HealthCheck != nil {
+		t.Errorf("got health check %#v for another version, want nil", other.HealthCheck)
+	}
+}
+
+func TestApplyClusterPolicyMaxRetries(t *testing.T) {
+	mesh := makeMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	policy := &proxyconfig.DestinationPolicy{
+		Destination: mock.HelloService.Hostname,
+		Policy: []*proxyconfig.DestinationVersionPolicy{{
+			CircuitBreaker: &proxyconfig.CircuitBreaker{
+				CbPolicy: &proxyconfig.CircuitBreaker_SimpleCb{
+					SimpleCb: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{
+						MaxConnections:        10,
+						SleepWindow:           ptypes.DurationProto(time.Minute),
+						HttpDetectionInterval: ptypes.DurationProto(time.Second),
+					},
+				},
+			},
+		}},
+	}
+	if _, err := store.Post(model.Config{
+		Content:     policy,
+		Annotations: map[string]string{model.MaxRetriesAnnotation: "5"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := buildOutboundCluster(mock.HelloService.Hostname, mock.HelloService.Ports[0], nil)
+	applyClusterPolicy(cluster, store.DestinationPolicyIndex(), &mesh, mock.Discovery, mock.Discovery, "")
+	want := DefaultCBPriority{MaxConnections: 10, MaxRetries: 5}
+	if cluster.CircuitBreaker == nil || cluster.CircuitBreaker.Default != want {
+		t.Errorf("got circuit breaker %#v, want %#v", cluster.CircuitBreaker, want)
+	}
+}
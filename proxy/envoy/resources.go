@@ -69,6 +69,9 @@ const (
 	// LbTypeOriginalDST is the name for LB of original_dst
 	LbTypeOriginalDST = "original_dst_lb"
 
+	// LbTypeRingHash is the name for ring hash LB
+	LbTypeRingHash = "ring_hash"
+
 	// ClusterFeatureHTTP2 is the feature to use HTTP/2 for a cluster
 	ClusterFeatureHTTP2 = "http2"
 
@@ -177,6 +180,12 @@ type Header struct {
 	Regex bool   `json:"regex,omitempty"`
 }
 
+// HeaderValue definition for adding a header value
+type HeaderValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
 // FilterFaultConfig definition
 type FilterFaultConfig struct {
 	Abort           *AbortFilter `json:"abort,omitempty"`
@@ -204,6 +213,16 @@ type Runtime struct {
 	Default int    `json:"default"`
 }
 
+// ShadowPolicy definition for mirroring requests to a cluster
+type ShadowPolicy struct {
+	Cluster string `json:"cluster"`
+}
+
+// HashPolicy definition for the ring hash load balancer
+type HashPolicy struct {
+	HeaderName string `json:"header_name"`
+}
+
 // HTTPRoute definition
 type HTTPRoute struct {
 	Runtime *Runtime `json:"runtime,omitempty"`
@@ -223,10 +242,14 @@ type HTTPRoute struct {
 	Headers      Headers           `json:"headers,omitempty"`
 	TimeoutMS    int64             `json:"timeout_ms,omitempty"`
 	RetryPolicy  *RetryPolicy      `json:"retry_policy,omitempty"`
+	HashPolicy   *HashPolicy       `json:"hash_policy,omitempty"`
+	Shadow       *ShadowPolicy     `json:"shadow,omitempty"`
 	OpaqueConfig map[string]string `json:"opaque_config,omitempty"`
 
 	AutoHostRewrite bool `json:"auto_host_rewrite,omitempty"`
 
+	RequestHeadersToAdd []*HeaderValue `json:"request_headers_to_add,omitempty"`
+
 	// clusters contains the set of referenced clusters in the route; the field is special
 	// and used only to aggregate cluster information after composing routes
 	clusters Clusters
@@ -399,8 +422,8 @@ type TCPRoute struct {
 	SourceIPList      []string `json:"source_ip_list,omitempty"`
 	SourcePorts       string   `json:"source_ports,omitempty"`
 
-	// special value to retain dependent cluster definition for TCP routes.
-	clusterRef *Cluster
+	// special value to retain dependent cluster definitions for TCP routes.
+	clusters []*Cluster
 }
 
 // TCPRouteByRoute sorts TCP routes over all route sub fields.
@@ -555,6 +578,7 @@ type Cluster struct {
 	Features                 string            `json:"features,omitempty"`
 	CircuitBreaker           *CircuitBreaker   `json:"circuit_breakers,omitempty"`
 	OutlierDetection         *OutlierDetection `json:"outlier_detection,omitempty"`
+	HealthCheck              *HealthCheck      `json:"health_check,omitempty"`
 
 	// special values used by the post-processing passes for outbound mesh-local clusters
 	outbound bool
@@ -577,6 +601,25 @@ type DefaultCBPriority struct {
 	MaxRetries         int `json:"max_retries,omitempty"`
 }
 
+// HealthCheck definition
+// See: https://lyft.github.io/envoy/docs/configuration/cluster_manager/cluster_hc.html
+type HealthCheck struct {
+	Type               string `json:"type"`
+	TimeoutMS          int64  `json:"timeout_ms"`
+	IntervalMS         int64  `json:"interval_ms"`
+	UnhealthyThreshold int    `json:"unhealthy_threshold"`
+	HealthyThreshold   int    `json:"healthy_threshold"`
+	Path               string `json:"path,omitempty"`
+
+	Send    []HealthCheckPayload `json:"send,omitempty"`
+	Receive []HealthCheckPayload `json:"receive,omitempty"`
+}
+
+// HealthCheckPayload definition
+type HealthCheckPayload struct {
+	Binary string `json:"binary"`
+}
+
 // OutlierDetection definition
 // See: https://lyft.github.io/envoy/docs/configuration/cluster_manager/cluster_runtime.html#outlier-detection
 type OutlierDetection struct {
@@ -20,9 +20,11 @@ package envoy
 import (
 	"crypto/sha1"
 	"fmt"
+	"net"
 	"sort"
 	"strings"
 
+	"github.com/golang/glog"
 	"github.com/golang/protobuf/ptypes/duration"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
@@ -103,7 +105,8 @@ func buildOutboundCluster(hostname string, port *model.Port, tags model.Tags) *C
 }
 
 // buildHTTPRoute translates a route rule to an Envoy route
-func buildHTTPRoute(rule *proxyconfig.RouteRule, port *model.Port) *HTTPRoute {
+func buildHTTPRoute(config model.Config, port *model.Port) *HTTPRoute {
+	rule := config.Content.(*proxyconfig.RouteRule)
 	route := buildHTTPRouteMatch(rule.Match)
 
 	// setup timeouts for the route
@@ -125,6 +128,13 @@ func buildHTTPRoute(rule *proxyconfig.RouteRule, port *model.Port) *HTTPRoute {
 		if protoDurationToMS(rule.HttpReqRetries.GetSimpleRetry().PerTryTimeout) > 0 {
 			route.RetryPolicy.PerTryTimeoutMS = protoDurationToMS(rule.HttpReqRetries.GetSimpleRetry().PerTryTimeout)
 		}
+		if value, exists := config.Annotations[model.RetryPolicyAnnotation]; exists {
+			if retry, err := model.ParseRetryPolicy(value); err != nil {
+				glog.Warningf("Ignoring %s for route rule %q: %v", model.RetryPolicyAnnotation, config.Key, err)
+			} else {
+				applyRetryPolicy(route.RetryPolicy, retry)
+			}
+		}
 	}
 
 	if len(rule.Route) > 0 {
@@ -171,6 +181,14 @@ func buildHTTPRoute(rule *proxyconfig.RouteRule, port *model.Port) *HTTPRoute {
 		route.PrefixRewrite = rule.Rewrite.GetUri()
 	}
 
+	if value, exists := config.Annotations[model.HeadersAnnotation]; exists {
+		if headers, err := model.ParseRouteHeaders(value); err != nil {
+			glog.Warningf("Ignoring %s for route rule %q: %v", model.HeadersAnnotation, config.Key, err)
+		} else {
+			applyRouteHeaders(route, headers)
+		}
+	}
+
 	// Add the fault filters, one per cluster defined in weighted cluster or cluster
 	if rule.HttpFault != nil {
 		route.faults = make([]*HTTPFilter, 0, len(route.clusters))
@@ -181,9 +199,28 @@ func buildHTTPRoute(rule *proxyconfig.RouteRule, port *model.Port) *HTTPRoute {
 		}
 	}
 
+	// mirror requests to the shadow cluster after the faults so that the
+	// mirrored requests are not subject to the fault filters
+	if value, exists := config.Annotations[model.MirrorAnnotation]; exists && rule.Redirect == nil {
+		if mirror, err := model.ParseMirror(value); err != nil {
+			glog.Warningf("Ignoring %s for route rule %q: %v", model.MirrorAnnotation, config.Key, err)
+		} else {
+			cluster := buildOutboundCluster(mirror.Destination, port, mirror.Tags)
+			route.Shadow = &ShadowPolicy{Cluster: cluster.Name}
+			route.clusters = append(route.clusters, cluster)
+		}
+	}
+
 	return route
 }
 
+// applyRetryPolicy overrides the retry conditions of the route retry policy
+func applyRetryPolicy(policy *RetryPolicy, retry *model.RetryPolicy) {
+	if len(retry.RetryOn) > 0 {
+		policy.Policy = strings.Join(retry.RetryOn, ",")
+	}
+}
+
 func buildCluster(address, name string, timeout *duration.Duration) *Cluster {
 	return &Cluster{
 		Name:             name,
@@ -301,8 +338,8 @@ func buildTCPRoute(cluster *Cluster, addresses []string) *TCPRoute {
 	// destination port is unnecessary with use_original_dst since
 	// the listener address already contains the port
 	route := &TCPRoute{
-		Cluster:    cluster.Name,
-		clusterRef: cluster,
+		Cluster:  cluster.Name,
+		clusters: []*Cluster{cluster},
 	}
 	sort.Sort(sort.StringSlice(addresses))
 	for _, addr := range addresses {
@@ -311,7 +348,89 @@ func buildTCPRoute(cluster *Cluster, addresses []string) *TCPRoute {
 	return route
 }
 
-// nolint: deadcode, megacheck
+// buildTCPRouteFromRule translates a route rule to an Envoy TCP route for the
+// service address. The listener is bound to the service address, so the
+// destination subnets of the L4 match attributes only select the route if they
+// contain that address, and the source subnets restrict the route. Envoy TCP
+// routes select a single cluster, so traffic splits by weight are rejected.
+func buildTCPRouteFromRule(rule *proxyconfig.RouteRule, port *model.Port, address string) (*TCPRoute, error) {
+	var cluster *Cluster
+	switch {
+	case len(rule.Route) == 0:
+		// default route for the destination
+		cluster = buildOutboundCluster(rule.Destination, port, nil)
+	case len(rule.Route) == 1:
+		cluster = buildTCPRouteCluster(rule, rule.Route[0], port)
+	default:
+		for _, dst := range rule.Route {
+			if dst.Weight == 0 {
+				continue
+			}
+			if cluster != nil {
+				return nil, fmt.Errorf("weighted routes are not supported for TCP connections")
+			}
+			cluster = buildTCPRouteCluster(rule, dst, port)
+		}
+		if cluster == nil {
+			return nil, fmt.Errorf("no route with a positive weight")
+		}
+	}
+
+	match := rule.GetMatch().GetTcp()
+	if subnets := match.GetDestinationSubnet(); len(subnets) > 0 && !subnetsContain(subnets, address) {
+		return nil, fmt.Errorf("destination subnets %v do not contain the service address %s", subnets, address)
+	}
+
+	route := buildTCPRoute(cluster, []string{address})
+	route.SourceIPList = buildSubnets(match.GetSourceSubnet())
+	return route, nil
+}
+
+// buildTCPRouteCluster builds the outbound cluster for a rule destination,
+// with fallback to the rule destination
+func buildTCPRouteCluster(rule *proxyconfig.RouteRule, dst *proxyconfig.DestinationWeight,
+	port *model.Port) *Cluster {
+	destination := dst.Destination
+	if destination == "" {
+		destination = rule.Destination
+	}
+	return buildOutboundCluster(destination, port, dst.Tags)
+}
+
+// buildSubnets converts L4 match subnets to CIDR notation, treating plain IP
+// addresses as single host subnets
+func buildSubnets(subnets []string) []string {
+	if len(subnets) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		if !strings.Contains(subnet, "/") {
+			subnet = subnet + "/32"
+		}
+		out = append(out, subnet)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// subnetsContain checks if any of the L4 match subnets contains the address
+func subnetsContain(subnets []string, address string) bool {
+	ip := net.ParseIP(address)
+	for _, subnet := range subnets {
+		if !strings.Contains(subnet, "/") {
+			if other := net.ParseIP(subnet); other != nil && other.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(subnet); err == nil && ip != nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func buildOriginalDSTCluster(name string, timeout *duration.Duration) *Cluster {
 	return &Cluster{
 		Name:             OutboundClusterPrefix + name,
@@ -320,3 +439,18 @@ func buildOriginalDSTCluster(name string, timeout *duration.Duration) *Cluster {
 		LbType:           LbTypeOriginalDST,
 	}
 }
+
+// buildOutboundOriginalDSTCluster builds a cluster for a service port that
+// forwards the connections to their original destination, for headless
+// services addressed by the instance endpoints
+func buildOutboundOriginalDSTCluster(hostname string, port *model.Port, timeout *duration.Duration) *Cluster {
+	svc := model.Service{Hostname: hostname}
+	key := svc.Key(port, nil)
+
+	// cluster name must be below 60 characters
+	cluster := buildOriginalDSTCluster(fmt.Sprintf("orig.%x", sha1.Sum([]byte(key))), timeout)
+	cluster.outbound = true
+	cluster.hostname = hostname
+	cluster.port = port
+	return cluster
+}
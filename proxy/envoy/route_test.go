@@ -15,8 +15,12 @@
 package envoy
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
 )
 
 var (
@@ -58,3 +62,115 @@ func TestBuildListenerSSLContext(t *testing.T) {
 			dir, context.RequireClientCertificate)
 	}
 }
+
+func TestBuildHTTPRouteRetryPolicy(t *testing.T) {
+	rule := &proxyconfig.RouteRule{
+		Name:        "retries",
+		Destination: "world.default.svc.cluster.local",
+		HttpReqRetries: &proxyconfig.HTTPRetry{
+			RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+				SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{Attempts: 3},
+			},
+		},
+	}
+	port := &model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}
+
+	testCases := []struct {
+		name       string
+		annotation string
+		want       *RetryPolicy
+	}{
+		{
+			name: "default conditions",
+			want: &RetryPolicy{Policy: "5xx,connect-failure,refused-stream", NumRetries: 3},
+		},
+		{
+			name:       "invalid annotation",
+			annotation: `{"retryOn": ["sometimes"]}`,
+			want:       &RetryPolicy{Policy: "5xx,connect-failure,refused-stream", NumRetries: 3},
+		},
+		{
+			name:       "unsupported backoff",
+			annotation: `{"retryOn": ["5xx"], "backoff": {"baseInterval": "25ms"}}`,
+			want:       &RetryPolicy{Policy: "5xx,connect-failure,refused-stream", NumRetries: 3},
+		},
+		{
+			name:       "conditions",
+			annotation: `{"retryOn": ["5xx", "retriable-4xx"]}`,
+			want:       &RetryPolicy{Policy: "5xx,retriable-4xx", NumRetries: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		config := model.Config{Type: model.RouteRule.Type, Key: rule.Name, Content: rule}
+		if tc.annotation != "" {
+			config.Annotations = map[string]string{model.RetryPolicyAnnotation: tc.annotation}
+		}
+		route := buildHTTPRoute(config, port)
+		if !reflect.DeepEqual(route.RetryPolicy, tc.want) {
+			t.Errorf("%s: got retry policy %#v, want %#v", tc.name, route.RetryPolicy, tc.want)
+		}
+	}
+}
+
+func TestBuildHTTPRouteMirror(t *testing.T) {
+	rule := &proxyconfig.RouteRule{
+		Name:        "mirror",
+		Destination: "world.default.svc.cluster.local",
+		HttpFault: &proxyconfig.HTTPFaultInjection{
+			Abort: &proxyconfig.HTTPFaultInjection_Abort{
+				Percent:   10,
+				ErrorType: &proxyconfig.HTTPFaultInjection_Abort_HttpStatus{HttpStatus: 503},
+			},
+		},
+	}
+	port := &model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}
+	shadow := buildOutboundCluster("hello.default.svc.cluster.local", port, model.Tags{"version": "v2"})
+
+	testCases := []struct {
+		name       string
+		annotation string
+		want       *ShadowPolicy
+	}{
+		{
+			name:       "invalid annotation",
+			annotation: `{"destination": "hello.default.svc.cluster.local", "percent": 200}`,
+		},
+		{
+			name: "unsupported percentage of requests",
+			annotation: `{"destination": "hello.default.svc.cluster.local", "tags": {"version": "v2"},
+				"percent": 12.5}`,
+		},
+		{
+			name:       "all requests",
+			annotation: `{"destination": "hello.default.svc.cluster.local", "tags": {"version": "v2"}}`,
+			want:       &ShadowPolicy{Cluster: shadow.Name},
+		},
+	}
+
+	for _, tc := range testCases {
+		config := model.Config{
+			Type:        model.RouteRule.Type,
+			Key:         rule.Name,
+			Content:     rule,
+			Annotations: map[string]string{model.MirrorAnnotation: tc.annotation},
+		}
+		route := buildHTTPRoute(config, port)
+		if !reflect.DeepEqual(route.Shadow, tc.want) {
+			t.Errorf("%s: got shadow policy %#v, want %#v", tc.name, route.Shadow, tc.want)
+		}
+		if len(route.faults) != 1 {
+			t.Errorf("%s: got %d fault filters, want 1", tc.name, len(route.faults))
+		}
+		wantClusters := 1
+		if tc.want != nil {
+			wantClusters = 2
+			if last := route.clusters[len(route.clusters)-1]; !reflect.DeepEqual(last, shadow) {
+				t.Errorf("%s: got shadow cluster %#v, want %#v", tc.name, last, shadow)
+			}
+		}
+		if len(route.clusters) != wantClusters {
+			t.Errorf("%s: got %d clusters, want %d", tc.name, len(route.clusters), wantClusters)
+		}
+	}
+}
@@ -278,11 +278,11 @@ func (infra *infra) applyConfig(inFile string, data map[string]string, typ strin
 		return err
 	}
 
-	_, exists, rev := istioClient.Get(typ, schema.Key(v))
+	old, exists := istioClient.Get(typ, schema.Key(v), infra.Namespace)
 	if exists {
-		_, err = istioClient.Put(v, rev)
+		_, err = istioClient.Put(model.Config{Namespace: infra.Namespace, Revision: old.Revision, Content: v})
 	} else {
-		_, err = istioClient.Post(v)
+		_, err = istioClient.Post(model.Config{Namespace: infra.Namespace, Content: v})
 	}
 	if err != nil {
 		return err
@@ -299,13 +299,13 @@ func (infra *infra) deleteAllConfigs() error {
 		return err
 	}
 	for _, desc := range istioClient.ConfigDescriptor() {
-		configs, err := istioClient.List(desc.Type)
+		configs, err := istioClient.List(desc.Type, infra.Namespace)
 		if err != nil {
 			return err
 		}
 		for _, config := range configs {
 			glog.Infof("Delete config %s %s", desc.Type, config.Key)
-			if err = istioClient.Delete(desc.Type, config.Key); err != nil {
+			if err = istioClient.Delete(desc.Type, config.Key, config.Namespace); err != nil {
 				return err
 			}
 		}
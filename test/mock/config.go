@@ -73,7 +73,7 @@ func Make(i int) *test.MockConfig {
 }
 
 // CheckMapInvariant validates operational invariants of an empty config registry
-func CheckMapInvariant(r model.ConfigStore, t *testing.T, n int) {
+func CheckMapInvariant(r model.ConfigStore, t *testing.T, namespace string, n int) {
 	// check that the config descriptor is the mock config descriptor
 	_, contains := r.ConfigDescriptor().GetByType(model.MockConfig.Type)
 	if !contains {
@@ -81,9 +81,13 @@ func CheckMapInvariant(r model.ConfigStore, t *testing.T, n int) {
 	}
 
 	// create configuration objects
-	elts := make(map[int]*test.MockConfig)
+	elts := make(map[int]model.Config)
 	for i := 0; i < n; i++ {
-		elts[i] = Make(i)
+		elts[i] = model.Config{
+			Namespace: namespace,
+			Labels:    map[string]string{"key": strconv.Itoa(i)},
+			Content:   Make(i),
+		}
 	}
 
 	// post all elements
@@ -97,10 +101,17 @@ func CheckMapInvariant(r model.ConfigStore, t *testing.T, n int) {
 
 	// check that elements are stored
 	for i, elt := range elts {
-		if v1, ok, rev := r.Get(model.MockConfig.Type, elts[i].Key); !ok || !reflect.DeepEqual(v1, elt) {
+		key := model.MockConfig.Key(elt.Content)
+		v1, ok := r.Get(model.MockConfig.Type, key, namespace)
+		if !ok || !reflect.DeepEqual(v1.Content, elt.Content) {
 			t.Errorf("wanted %v, got %v", elt, v1)
 		} else {
-			revs[i] = rev
+			// the store assigns the default namespace to objects without one
+			if v1.Key != key || (namespace != "" && v1.Namespace != namespace) ||
+				!reflect.DeepEqual(v1.Labels, elt.Labels) {
+				t.Errorf("wanted metadata of %v, got %v", elt, v1)
+			}
+			revs[i] = v1.Revision
 		}
 	}
 
@@ -108,61 +119,64 @@ func CheckMapInvariant(r model.ConfigStore, t *testing.T, n int) {
 		t.Error("expected error posting twice")
 	}
 
-	if _, err := r.Post(nil); err == nil {
+	if _, err := r.Post(model.Config{Namespace: namespace}); err == nil {
 		t.Error("expected error posting invalid object")
 	}
 
-	if _, err := r.Post(&test.MockConfig{}); err == nil {
+	if _, err := r.Post(model.Config{Namespace: namespace, Content: &test.MockConfig{}}); err == nil {
 		t.Error("expected error posting invalid object")
 	}
 
-	if _, err := r.Put(nil, revs[0]); err == nil {
+	if _, err := r.Put(model.Config{Namespace: namespace, Revision: revs[0]}); err == nil {
 		t.Error("expected error putting invalid object")
 	}
 
-	if _, err := r.Put(&test.MockConfig{}, revs[0]); err == nil {
+	if _, err := r.Put(model.Config{Namespace: namespace, Revision: revs[0],
+		Content: &test.MockConfig{}}); err == nil {
 		t.Error("expected error putting invalid object")
 	}
 
-	if _, err := r.Put(&test.MockConfig{Key: "missing"}, revs[0]); err == nil {
+	if _, err := r.Put(model.Config{Namespace: namespace, Revision: revs[0],
+		Content: &test.MockConfig{Key: "missing"}}); err == nil {
 		t.Error("expected error putting missing object with a missing key")
 	}
 
-	if _, err := r.Put(elts[0], ""); err == nil {
+	if _, err := r.Put(model.Config{Namespace: namespace, Content: elts[0].Content}); err == nil {
 		t.Error("expected error putting object without revision")
 	}
 
-	if _, err := r.Put(elts[0], "missing"); err == nil {
+	if _, err := r.Put(model.Config{Namespace: namespace, Revision: "missing",
+		Content: elts[0].Content}); err == nil {
 		t.Error("expected error putting object with a bad revision")
 	}
 
 	// check for missing type
-	if l, _ := r.List("missing"); len(l) > 0 {
+	if l, _ := r.List("missing", namespace); len(l) > 0 {
 		t.Errorf("unexpected objects for missing type")
 	}
 
 	// check for missing element
-	if _, ok, _ := r.Get(model.MockConfig.Type, "missing"); ok {
+	if _, ok := r.Get(model.MockConfig.Type, "missing", namespace); ok {
 		t.Error("unexpected configuration object found")
 	}
 
 	// check for missing element
-	if _, ok, _ := r.Get("missing", "missing"); ok {
+	if _, ok := r.Get("missing", "missing", namespace); ok {
 		t.Error("unexpected configuration object found")
 	}
 
 	// delete missing elements
-	if err := r.Delete("missing", "missing"); err == nil {
+	if err := r.Delete("missing", "missing", namespace); err == nil {
 		t.Error("expected error on deletion of missing type")
 	}
 
 	// delete missing elements
-	if err := r.Delete(model.MockConfig.Type, "missing"); err == nil {
+	if err := r.Delete(model.MockConfig.Type, "missing", namespace); err == nil {
 		t.Error("expected error on deletion of missing element")
 	}
 
 	// list elements
-	l, err := r.List(model.MockConfig.Type)
+	l, err := r.List(model.MockConfig.Type, namespace)
 	if err != nil {
 		t.Errorf("List error %#v, %v", l, err)
 	}
@@ -172,28 +186,34 @@ func CheckMapInvariant(r model.ConfigStore, t *testing.T, n int) {
 
 	// update all elements
 	for i := 0; i < n; i++ {
-		elts[i] = Make(i)
-		elts[i].Pairs[0].Value += "(updated)"
-		if _, err = r.Put(elts[i], revs[i]); err != nil {
+		elt := Make(i)
+		elt.Pairs[0].Value += "(updated)"
+		elts[i] = model.Config{
+			Namespace: namespace,
+			Revision:  revs[i],
+			Content:   elt,
+		}
+		if _, err = r.Put(elts[i]); err != nil {
 			t.Error(err)
 		}
 	}
 
 	// check that elements are stored
-	for i, elt := range elts {
-		if v1, ok, _ := r.Get(model.MockConfig.Type, elts[i].Key); !ok || !reflect.DeepEqual(v1, elt) {
+	for _, elt := range elts {
+		key := model.MockConfig.Key(elt.Content)
+		if v1, ok := r.Get(model.MockConfig.Type, key, namespace); !ok || !reflect.DeepEqual(v1.Content, elt.Content) {
 			t.Errorf("wanted %v, got %v", elt, v1)
 		}
 	}
 
 	// delete all elements
-	for i := range elts {
-		if err = r.Delete(model.MockConfig.Type, elts[i].Key); err != nil {
+	for _, elt := range elts {
+		if err = r.Delete(model.MockConfig.Type, model.MockConfig.Key(elt.Content), namespace); err != nil {
 			t.Error(err)
 		}
 	}
 
-	l, err = r.List(model.MockConfig.Type)
+	l, err = r.List(model.MockConfig.Type, namespace)
 	if err != nil {
 		t.Error(err)
 	}
@@ -202,21 +222,65 @@ func CheckMapInvariant(r model.ConfigStore, t *testing.T, n int) {
 	}
 }
 
+// CheckNamespaces validates that config objects with the same key co-exist in
+// distinct namespaces of an empty config registry
+func CheckNamespaces(r model.ConfigStore, t *testing.T, namespaces []string) {
+	for _, namespace := range namespaces {
+		if _, err := r.Post(model.Config{Namespace: namespace, Content: Make(0)}); err != nil {
+			t.Errorf("Post(%s) => got %v", namespace, err)
+		}
+	}
+
+	for _, namespace := range namespaces {
+		config, ok := r.Get(model.MockConfig.Type, Make(0).Key, namespace)
+		if !ok || config.Namespace != namespace {
+			t.Errorf("Get(%s) => got %v", namespace, config)
+		}
+		if l, _ := r.List(model.MockConfig.Type, namespace); len(l) != 1 {
+			t.Errorf("List(%s) => got %d element(s), want 1", namespace, len(l))
+		}
+	}
+
+	// the store may hold objects in other namespaces
+	l, _ := r.List(model.MockConfig.Type, model.NamespaceAll)
+	found := 0
+	for _, config := range l {
+		for _, namespace := range namespaces {
+			if config.Namespace == namespace {
+				found++
+			}
+		}
+	}
+	if found != len(namespaces) {
+		t.Errorf("List(all namespaces) => got %d element(s), want %d", found, len(namespaces))
+	}
+
+	for _, namespace := range namespaces {
+		if err := r.Delete(model.MockConfig.Type, Make(0).Key, namespace); err != nil {
+			t.Errorf("Delete(%s) => got %v", namespace, err)
+		}
+		if _, ok := r.Get(model.MockConfig.Type, Make(0).Key, namespace); ok {
+			t.Errorf("Get(%s) => unexpected object after deletion", namespace)
+		}
+	}
+}
+
 // CheckIstioConfigTypes validates that an empty store can ingest Istio config objects
-func CheckIstioConfigTypes(store model.ConfigStore, t *testing.T) {
-	if _, err := store.Post(ExampleRouteRule); err != nil {
+func CheckIstioConfigTypes(store model.ConfigStore, namespace string, t *testing.T) {
+	if _, err := store.Post(model.Config{Namespace: namespace, Content: ExampleRouteRule}); err != nil {
 		t.Errorf("Post(RouteRule) => got %v", err)
 	}
-	if _, err := store.Post(ExampleIngressRule); err != nil {
+	if _, err := store.Post(model.Config{Namespace: namespace, Content: ExampleIngressRule}); err != nil {
 		t.Errorf("Post(IngressRule) => got %v", err)
 	}
-	if _, err := store.Post(ExampleDestinationPolicy); err != nil {
+	if _, err := store.Post(model.Config{Namespace: namespace, Content: ExampleDestinationPolicy}); err != nil {
 		t.Errorf("Post(DestinationPolicy) => got %v", err)
 	}
 
 	registry := model.MakeIstioStore(store)
 	rules := registry.RouteRules()
-	if len(rules) != 1 || !reflect.DeepEqual(rules[ExampleRouteRule.Name], ExampleRouteRule) {
+	key := model.Config{Namespace: namespace, Key: ExampleRouteRule.Name}.QualifiedKey()
+	if len(rules) != 1 || !reflect.DeepEqual(rules[key], ExampleRouteRule) {
 		t.Errorf("RouteRules() => %v, want %v", rules, ExampleRouteRule)
 	}
 }
@@ -245,7 +309,7 @@ func CheckCacheEvents(store model.ConfigStore, cache model.ConfigStoreCache, n i
 	go cache.Run(stop)
 
 	// run map invariant sequence
-	CheckMapInvariant(store, t, n)
+	CheckMapInvariant(store, t, "", n)
 
 	glog.Infof("Waiting till all events are received")
 	util.Eventually(func() bool { return added == n && deleted == n }, t)
@@ -259,14 +323,14 @@ func CheckCacheFreshness(cache model.ConfigStoreCache, t *testing.T) {
 
 	// validate cache consistency
 	cache.RegisterEventHandler(model.MockConfig.Type, func(config model.Config, ev model.Event) {
-		elts, _ := cache.List(model.MockConfig.Type)
+		elts, _ := cache.List(model.MockConfig.Type, model.NamespaceAll)
 		switch ev {
 		case model.EventAdd:
 			if len(elts) != 1 {
 				t.Errorf("Got %#v, expected %d element(s) on ADD event", elts, 1)
 			}
 			glog.Infof("Calling Delete(%#v)", config.Key)
-			if err := cache.Delete(model.MockConfig.Type, config.Key); err != nil {
+			if err := cache.Delete(model.MockConfig.Type, config.Key, config.Namespace); err != nil {
 				t.Error(err)
 			}
 		case model.EventDelete:
@@ -286,7 +350,7 @@ func CheckCacheFreshness(cache model.ConfigStoreCache, t *testing.T) {
 
 	// add and remove
 	glog.Infof("Calling Post(%#v)", o)
-	if _, err := cache.Post(o); err != nil {
+	if _, err := cache.Post(model.Config{Content: o}); err != nil {
 		t.Error(err)
 	}
 
@@ -303,7 +367,7 @@ func CheckCacheSync(store model.ConfigStore, cache model.ConfigStoreCache, n int
 	// add elements directly through client
 	for i := 0; i < n; i++ {
 		keys[i] = Make(i)
-		if _, err := store.Post(keys[i]); err != nil {
+		if _, err := store.Post(model.Config{Content: keys[i]}); err != nil {
 			t.Error(err)
 		}
 	}
@@ -313,36 +377,36 @@ func CheckCacheSync(store model.ConfigStore, cache model.ConfigStoreCache, n int
 	defer close(stop)
 	go cache.Run(stop)
 	util.Eventually(func() bool { return cache.HasSynced() }, t)
-	os, _ := cache.List(model.MockConfig.Type)
+	os, _ := cache.List(model.MockConfig.Type, model.NamespaceAll)
 	if len(os) != n {
 		t.Errorf("cache.List => Got %d, expected %d", len(os), n)
 	}
 
 	// remove elements directly through client
 	for i := 0; i < n; i++ {
-		if err := store.Delete(model.MockConfig.Type, keys[i].Key); err != nil {
+		if err := store.Delete(model.MockConfig.Type, keys[i].Key, ""); err != nil {
 			t.Error(err)
 		}
 	}
 
 	// check again in the controller cache
 	util.Eventually(func() bool {
-		os, _ = cache.List(model.MockConfig.Type)
+		os, _ = cache.List(model.MockConfig.Type, model.NamespaceAll)
 		glog.Infof("cache.List => Got %d, expected %d", len(os), 0)
 		return len(os) == 0
 	}, t)
 
 	// now add through the controller
 	for i := 0; i < n; i++ {
-		if _, err := cache.Post(Make(i)); err != nil {
+		if _, err := cache.Post(model.Config{Content: Make(i)}); err != nil {
 			t.Error(err)
 		}
 	}
 
 	// check directly through the client
 	util.Eventually(func() bool {
-		cs, _ := cache.List(model.MockConfig.Type)
-		os, _ := store.List(model.MockConfig.Type)
+		cs, _ := cache.List(model.MockConfig.Type, model.NamespaceAll)
+		os, _ := store.List(model.MockConfig.Type, model.NamespaceAll)
 		glog.Infof("cache.List => Got %d, expected %d", len(cs), n)
 		glog.Infof("store.List => Got %d, expected %d", len(os), n)
 		return len(os) == n && len(cs) == n
@@ -350,7 +414,7 @@ func CheckCacheSync(store model.ConfigStore, cache model.ConfigStoreCache, n int
 
 	// remove elements directly through the client
 	for i := 0; i < n; i++ {
-		if err := store.Delete(model.MockConfig.Type, keys[i].Key); err != nil {
+		if err := store.Delete(model.MockConfig.Type, keys[i].Key, ""); err != nil {
 			t.Error(err)
 		}
 	}
@@ -159,6 +159,15 @@ type ServiceDiscovery struct {
 	versions int
 }
 
+// NewDiscovery builds a mock discovery interface with the given services and
+// the number of versions per service
+func NewDiscovery(services map[string]*model.Service, versions int) *ServiceDiscovery {
+	return &ServiceDiscovery{
+		services: services,
+		versions: versions,
+	}
+}
+
 // Services implements discovery interface
 func (sd *ServiceDiscovery) Services() []*model.Service {
 	out := make([]*model.Service, 0, len(sd.services))